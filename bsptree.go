@@ -6,18 +6,35 @@ import (
 )
 
 const (
-	clusterInvalidId = ClusterId(65535)
+	clusterInvalidId = ClusterId(q2file.ClusterInvalid)
 )
 
-type ClusterId uint16
+type ClusterId uint32
 
 type TreeLeaf struct {
 	LeafIndex int   // index in bsp leaf array
 	Faces     []int // contains face index in face array
 }
 
+// Checks if two areas can see each other, either from the area portals or a recorded frame
+type areaConnector interface {
+	AreasConnected(area1 int, area2 int) bool
+}
+
 type BSPTree struct {
 	TreeLeaves []TreeLeaf
+
+	// Faces inside each leaf, without the faces from other visible clusters
+	leafFaces [][]int
+
+	// Faces already added in the current frustum walk are marked with its walk number
+	// This avoids clearing the marks before every walk
+	faceMarks []int
+	markCount int
+
+	facesInCluster  map[ClusterId][]int
+	visibleClusters map[ClusterId][]ClusterId
+	clusterAreas    map[ClusterId]int
 }
 
 func NewBSPTree(mapData *q2file.MapData) *BSPTree {
@@ -27,11 +44,20 @@ func NewBSPTree(mapData *q2file.MapData) *BSPTree {
 	}
 	allLeaves, leavesInCluster := getLeavesInCluster(mapData)
 	facesInCluster := getFacesInCluster(leavesInCluster)
-	facesFromCluster := getFacesFromCluster(mapData, facesInCluster)
+	visibleClusters := getVisibleClusters(mapData, facesInCluster)
+	facesFromCluster := getFacesFromCluster(visibleClusters, facesInCluster)
 	// Use the PVS to get the full visibility data
 	treeLeaves := getTreeLeaves(mapData, allLeaves, facesFromCluster, allFaceIds)
+	leafFaces := make([][]int, len(allLeaves))
+	for i, leaf := range allLeaves {
+		leafFaces[i] = leaf.Faces
+	}
 	return &BSPTree{
-		TreeLeaves: treeLeaves,
+		TreeLeaves:      treeLeaves,
+		leafFaces:       leafFaces,
+		facesInCluster:  facesInCluster,
+		visibleClusters: visibleClusters,
+		clusterAreas:    getClusterAreas(mapData),
 	}
 }
 
@@ -82,7 +108,7 @@ func getFacesInCluster(leavesInCluster map[ClusterId][]TreeLeaf) map[ClusterId][
 }
 
 // Use PVS to calculate faces in other clusters that are visible from this cluster
-func getFacesFromCluster(mapData *q2file.MapData, facesInCluster map[ClusterId][]int) map[ClusterId][]int {
+func getFacesFromCluster(visibleClusters map[ClusterId][]ClusterId, facesInCluster map[ClusterId][]int) map[ClusterId][]int {
 	facesFromCluster := make(map[ClusterId][]int)
 	for cluster, faces := range facesInCluster {
 		if cluster == clusterInvalidId {
@@ -91,6 +117,26 @@ func getFacesFromCluster(mapData *q2file.MapData, facesInCluster map[ClusterId][
 
 		// copy existing faces
 		visibleFaces := getFaceIdsFromFaces(faces)
+		for _, otherCluster := range visibleClusters[cluster] {
+			visibleFaces = append(visibleFaces, facesInCluster[otherCluster]...)
+		}
+
+		uniqueFaces := getUniqueFacesFromVisibleFaces(visibleFaces)
+		facesFromCluster[cluster] = getFaceIdsFromUniqueFaces(uniqueFaces)
+		sort.Ints(facesFromCluster[cluster])
+	}
+	return facesFromCluster
+}
+
+// Decompress the PVS to find the clusters visible from each cluster
+func getVisibleClusters(mapData *q2file.MapData, facesInCluster map[ClusterId][]int) map[ClusterId][]ClusterId {
+	visibleClusters := make(map[ClusterId][]ClusterId)
+	for cluster := range facesInCluster {
+		if cluster == clusterInvalidId {
+			continue
+		}
+
+		clusters := make([]ClusterId, 0)
 
 		// PVS buffer index
 		v := mapData.VisibilityOffsets[cluster].Pvs
@@ -108,8 +154,7 @@ func getFacesFromCluster(mapData *q2file.MapData, facesInCluster map[ClusterId][
 				for bit := 0; bit < 8; bit++ {
 					_, clusterExists := facesInCluster[ClusterId(otherClusterIndex)]
 					if mapData.VisibilityData[v]&(1<<uint32(bit)) != 0 && clusterExists {
-						clusterFaceIds := getFaceIdsFromFaces(facesInCluster[ClusterId(otherClusterIndex)])
-						visibleFaces = append(visibleFaces, clusterFaceIds...)
+						clusters = append(clusters, ClusterId(otherClusterIndex))
 					}
 					otherClusterIndex += 1
 				}
@@ -117,11 +162,22 @@ func getFacesFromCluster(mapData *q2file.MapData, facesInCluster map[ClusterId][
 			v += 1
 		}
 
-		uniqueFaces := getUniqueFacesFromVisibleFaces(visibleFaces)
-		facesFromCluster[cluster] = getFaceIdsFromUniqueFaces(uniqueFaces)
-		sort.Ints(facesFromCluster[cluster])
+		visibleClusters[cluster] = clusters
 	}
-	return facesFromCluster
+	return visibleClusters
+}
+
+// Each cluster belongs to the area of its leaves
+func getClusterAreas(mapData *q2file.MapData) map[ClusterId]int {
+	clusterAreas := make(map[ClusterId]int)
+	for _, leaf := range mapData.BSPLeaves {
+		c := ClusterId(leaf.Cluster)
+		if c == clusterInvalidId {
+			continue
+		}
+		clusterAreas[c] = int(leaf.Area)
+	}
+	return clusterAreas
 }
 
 func getUniqueFacesFromVisibleFaces(visibleFaces []int) map[int]bool {
@@ -197,3 +253,83 @@ func (tree *BSPTree) findLeafNode(startNode int, mapData *q2file.MapData, positi
 	}
 	return tree.TreeLeaves[-(nodeId + 1)]
 }
+
+// Get the faces visible from a leaf, dropping clusters in areas that can't be reached through open portals
+func (tree *BSPTree) getVisibleFaces(mapData *q2file.MapData, leaf TreeLeaf, areaConnectivity areaConnector) []int {
+	c := ClusterId(mapData.BSPLeaves[leaf.LeafIndex].Cluster)
+	if c == clusterInvalidId || areaConnectivity == nil {
+		return leaf.Faces
+	}
+
+	visibleFaces := make([]int, 0)
+	for _, cluster := range tree.getVisibleClusters(mapData, leaf, areaConnectivity) {
+		visibleFaces = append(visibleFaces, tree.facesInCluster[cluster]...)
+	}
+
+	uniqueFaces := getUniqueFacesFromVisibleFaces(visibleFaces)
+	faceIds := getFaceIdsFromUniqueFaces(uniqueFaces)
+	sort.Ints(faceIds)
+	return faceIds
+}
+
+// Get the cluster of a leaf and the clusters in its PVS that are in a connected area
+func (tree *BSPTree) getVisibleClusters(mapData *q2file.MapData, leaf TreeLeaf, areaConnectivity areaConnector) []ClusterId {
+	c := ClusterId(mapData.BSPLeaves[leaf.LeafIndex].Cluster)
+	if c == clusterInvalidId {
+		return []ClusterId{}
+	}
+
+	leafArea := int(mapData.BSPLeaves[leaf.LeafIndex].Area)
+	clusters := []ClusterId{c}
+	for _, otherCluster := range tree.visibleClusters[c] {
+		if areaConnectivity != nil && !areaConnectivity.AreasConnected(leafArea, tree.clusterAreas[otherCluster]) {
+			continue
+		}
+		clusters = append(clusters, otherCluster)
+	}
+	return clusters
+}
+
+// Walk the nodes from the root, skipping every node whose bounding box is outside the view
+// Only the faces of leaves in a visible cluster are returned, and each face only once
+func (tree *BSPTree) getFrustumVisibleFaces(mapData *q2file.MapData, visibleClusters []ClusterId, frustum Frustum) []int {
+	isClusterVisible := make(map[ClusterId]bool)
+	for _, cluster := range visibleClusters {
+		isClusterVisible[cluster] = true
+	}
+
+	if len(tree.faceMarks) != len(mapData.Faces) {
+		tree.faceMarks = make([]int, len(mapData.Faces))
+		tree.markCount = 0
+	}
+	tree.markCount++
+	faceIds := make([]int, 0)
+	nodeStack := []int{0}
+	for len(nodeStack) > 0 {
+		nodeId := nodeStack[len(nodeStack)-1]
+		nodeStack = nodeStack[:len(nodeStack)-1]
+
+		// Leaves have a negative node id
+		if nodeId < 0 {
+			leafIndex := -(nodeId + 1)
+			leaf := mapData.BSPLeaves[leafIndex]
+			if !isClusterVisible[ClusterId(leaf.Cluster)] || !frustum.IntersectsBox(leaf.BBoxMin, leaf.BBoxMax) {
+				continue
+			}
+			for _, faceId := range tree.leafFaces[leafIndex] {
+				if tree.faceMarks[faceId] != tree.markCount {
+					tree.faceMarks[faceId] = tree.markCount
+					faceIds = append(faceIds, faceId)
+				}
+			}
+			continue
+		}
+
+		node := mapData.Nodes[nodeId]
+		if !frustum.IntersectsBox(node.BBoxMin, node.BBoxMax) {
+			continue
+		}
+		nodeStack = append(nodeStack, int(node.FrontChild), int(node.BackChild))
+	}
+	return faceIds
+}
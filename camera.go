@@ -87,3 +87,11 @@ func (c *Camera) GetCameraPosition() [3]float32 {
 	position := c.cameraPosition
 	return [3]float32{-position.X(), -position.Y(), -position.Z()}
 }
+
+// Move the camera to a recorded view, using Quake 2 angles in degrees
+// Yaw 0 looks along +x, while this camera looks along +y with no rotation
+func (c *Camera) SetView(position [3]float32, pitch float32, yaw float32) {
+	c.cameraPosition = mgl32.Vec3{-position[0], -position[1], -position[2]}
+	c.xAngle = mgl32.DegToRad(pitch)
+	c.zAngle = mgl32.DegToRad(90 - yaw)
+}
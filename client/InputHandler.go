@@ -13,11 +13,20 @@ const (
 	PLAYER_LEFT     Action = iota
 	PLAYER_RIGHT    Action = iota
 	PROGRAM_QUIT    Action = iota
+
+	DEMO_PAUSE         Action = iota
+	DEMO_SEEK_FORWARD  Action = iota
+	DEMO_SEEK_BACKWARD Action = iota
+	DEMO_FASTER        Action = iota
+	DEMO_SLOWER        Action = iota
+	DEMO_RESTART       Action = iota
 )
 
 type InputHandler struct {
 	actionToKeyMap map[Action]glfw.Key
 	keysPressed    [glfw.KeyLast]bool
+	// Keys pressed since the last frame, for actions that toggle
+	keysJustPressed [glfw.KeyLast]bool
 
 	firstCursorAction    bool
 	cursor               mgl64.Vec2
@@ -33,6 +42,13 @@ func NewInputHandler() *InputHandler {
 		PLAYER_LEFT:     glfw.KeyA,
 		PLAYER_RIGHT:    glfw.KeyD,
 		PROGRAM_QUIT:    glfw.KeyEscape,
+
+		DEMO_PAUSE:         glfw.KeySpace,
+		DEMO_SEEK_FORWARD:  glfw.KeyRight,
+		DEMO_SEEK_BACKWARD: glfw.KeyLeft,
+		DEMO_FASTER:        glfw.KeyUp,
+		DEMO_SLOWER:        glfw.KeyDown,
+		DEMO_RESTART:       glfw.KeyHome,
 	}
 
 	return &InputHandler{
@@ -45,12 +61,22 @@ func (handler *InputHandler) IsActive(a Action) bool {
 	return handler.keysPressed[handler.actionToKeyMap[a]]
 }
 
+// Only true on the frame the key was pressed, not while it is held down
+func (handler *InputHandler) WasPressed(a Action) bool {
+	return handler.keysJustPressed[handler.actionToKeyMap[a]]
+}
+
+func (handler *InputHandler) clearPressedKeys() {
+	handler.keysJustPressed = [glfw.KeyLast]bool{}
+}
+
 func (handler *InputHandler) keyCallback(window *glfw.Window, key glfw.Key, scancode int,
 	action glfw.Action, mods glfw.ModifierKey) {
 
 	switch action {
 	case glfw.Press:
 		handler.keysPressed[key] = true
+		handler.keysJustPressed[key] = true
 	case glfw.Release:
 		handler.keysPressed[key] = false
 	}
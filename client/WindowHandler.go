@@ -12,6 +12,7 @@ type WindowHandler struct {
 
 	firstFrame    bool
 	deltaTime     float64
+	elapsedTime   float64
 	lastFrameTime float64
 }
 
@@ -56,6 +57,7 @@ func (windowHandler *WindowHandler) StartFrame() {
 	windowHandler.glfwWindow.SwapBuffers()
 
 	// Window events for keyboard and mouse
+	windowHandler.InputHandler.clearPressedKeys()
 	glfw.PollEvents()
 
 	if windowHandler.InputHandler.IsActive(PROGRAM_QUIT) {
@@ -72,6 +74,7 @@ func (windowHandler *WindowHandler) StartFrame() {
 
 	windowHandler.deltaTime = currentFrameTime - windowHandler.lastFrameTime
 	windowHandler.lastFrameTime = currentFrameTime
+	windowHandler.elapsedTime += windowHandler.deltaTime
 
 	windowHandler.InputHandler.updateCursor()
 }
@@ -83,3 +86,8 @@ func (windowHandler *WindowHandler) ShouldClose() bool {
 func (windowHandler *WindowHandler) GetTimeSinceLastFrame() float64 {
 	return windowHandler.deltaTime
 }
+
+// Total time since the first frame, used for animations
+func (windowHandler *WindowHandler) GetElapsedTime() float64 {
+	return windowHandler.elapsedTime
+}
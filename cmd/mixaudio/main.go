@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/samuelyuan/go-quake2/q2file"
+	"github.com/samuelyuan/go-quake2/sound"
+)
+
+// Mixes the sounds heard along a camera path or demo into a WAV file, without a sound device
+// format: ./mixaudio -demo demos/demo1.dm2 -out mix.wav
+// format: ./mixaudio -map maps/base1.bsp -path camera.txt -out mix.wav
+func main() {
+	baseDirectory := flag.String("basedir", "./data", "directory with the base game files (pak0.pak)")
+	modDirectory := flag.String("game", "", "mod directory searched before the base directory")
+	demoFilename := flag.String("demo", "", "DM2 demo to follow, the map and sounds are loaded from the demo")
+	bspFilename := flag.String("map", "", "map with the target_speaker entities, when using a camera path")
+	pathFilename := flag.String("path", "", "camera path with one \"time x y z pitch yaw roll\" line per position")
+	outputFilename := flag.String("out", "mix.wav", "WAV file to write")
+	sampleRate := flag.Int("rate", 22050, "output sample rate")
+	flag.Parse()
+
+	if (*demoFilename == "") == (*pathFilename == "") {
+		log.Fatal("Either -demo or -path must be set")
+	}
+
+	fileSystem := q2file.NewFileSystem()
+	defer fileSystem.Close()
+	if err := fileSystem.AddGameDirectory(*baseDirectory); err != nil {
+		log.Fatal("Error initializing file system: ", err)
+	}
+	if *modDirectory != "" {
+		if err := fileSystem.AddGameDirectory(*modDirectory); err != nil {
+			log.Fatal("Error initializing file system: ", err)
+		}
+	}
+
+	cache := sound.NewSoundCache(fileSystem)
+	mixer := sound.NewMixer(*sampleRate)
+
+	var path sound.ListenerPath
+	mapFilename := *bspFilename
+	if *demoFilename != "" {
+		demoAudio, err := loadDemoAudio(fileSystem, cache, *demoFilename)
+		if err != nil {
+			log.Fatal("Error loading demo: ", err)
+		}
+		path = demoAudio.Path
+		mapFilename = demoAudio.MapFilename
+		for _, event := range demoAudio.Events {
+			mixer.AddSound(event)
+		}
+	} else {
+		pathFile, err := os.Open(*pathFilename)
+		if err != nil {
+			log.Fatal("Error loading camera path: ", err)
+		}
+		path, err = sound.LoadListenerPath(pathFile)
+		pathFile.Close()
+		if err != nil {
+			log.Fatal("Error loading camera path: ", err)
+		}
+	}
+
+	duration := path.Duration()
+	if mapFilename != "" {
+		entities, err := loadMapEntities(fileSystem, mapFilename)
+		if err != nil {
+			log.Fatal("Error loading map: ", err)
+		}
+		for _, event := range sound.CreateSpeakerEvents(cache, entities, duration) {
+			mixer.AddSound(event)
+		}
+	}
+	fmt.Println("Mixing", mixer.NumSounds(), "sounds over", fmt.Sprintf("%.1f", duration), "seconds")
+
+	samples := mixer.Mix(path, duration)
+	outputFile, err := os.Create(*outputFilename)
+	if err != nil {
+		log.Fatal("Error creating output: ", err)
+	}
+	defer outputFile.Close()
+	if err := q2file.WriteWAV(outputFile, *sampleRate, 2, samples); err != nil {
+		log.Fatal("Error writing output: ", err)
+	}
+	fmt.Println("Mix written to", *outputFilename)
+}
+
+// Demos are usually in the game directory, but can also be anywhere on disk
+func loadDemoAudio(fileSystem *q2file.FileSystem, cache *sound.SoundCache, filename string) (*sound.DemoAudio, error) {
+	if demoReader, err := fileSystem.Open(filename); err == nil {
+		return sound.LoadDemoAudio(cache, demoReader)
+	}
+
+	demoFile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer demoFile.Close()
+	return sound.LoadDemoAudio(cache, demoFile)
+}
+
+func loadMapEntities(fileSystem *q2file.FileSystem, filename string) ([]q2file.Entity, error) {
+	bspReader, err := fileSystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	mapData, err := q2file.LoadBSP(bspReader)
+	if err != nil {
+		return nil, err
+	}
+	return mapData.Entities, nil
+}
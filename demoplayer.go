@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/samuelyuan/go-quake2/client"
+	"github.com/samuelyuan/go-quake2/q2file"
+)
+
+const (
+	demoSeekSeconds = 5.0
+	demoMinSpeed    = 0.125
+	demoMaxSpeed    = 8.0
+
+	// Entities that move further than this between frames were teleported
+	demoTeleportDistance = 512.0
+	// The player can't move further than this in one frame
+	demoPlayerTeleportDistance = 256.0
+
+	entityEventPlayerTeleport = 6
+	entityEventOtherTeleport  = 7
+
+	// Items spin around in place
+	entityEffectRotate = 1
+)
+
+// Plays back the first level recorded in a DM2 demo
+// Every frame is decoded up front, which makes seeking backwards cheap
+type DemoPlayer struct {
+	ServerData    q2file.DM2ServerData
+	ConfigStrings [q2file.MaxConfigStrings]string
+
+	snapshots []q2file.DM2Snapshot
+	time      float64 // seconds since the first snapshot
+	speed     float64
+	paused    bool
+}
+
+// The entity state between two recorded frames
+type DemoEntity struct {
+	State     q2file.DM2EntityState // the newer frame
+	OldFrame  int
+	Origin    [3]float32
+	Angles    [3]float32
+	FrameLerp float32
+}
+
+func LoadDemoPlayer(fileSystem *q2file.FileSystem, filename string) (*DemoPlayer, error) {
+	// Demos are usually in the game directory, but can also be anywhere on disk
+	var demoReader io.Reader
+	if sectionReader, err := fileSystem.Open(filename); err == nil {
+		demoReader = sectionReader
+	} else {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		demoReader = file
+	}
+
+	reader := q2file.NewDM2Reader(demoReader)
+	demoClient := q2file.NewDM2Client()
+	player := &DemoPlayer{
+		snapshots: make([]q2file.DM2Snapshot, 0),
+		speed:     1.0,
+	}
+
+	for {
+		block, err := reader.NextBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Stop at the next level, since it needs a different map
+		if len(player.snapshots) > 0 && block.HasServerData() {
+			break
+		}
+
+		snapshots, err := demoClient.ParseBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		player.snapshots = append(player.snapshots, snapshots...)
+	}
+
+	if len(player.snapshots) == 0 {
+		return nil, fmt.Errorf("Demo %v has no frames", filename)
+	}
+	player.ServerData = demoClient.ServerData
+	player.ConfigStrings = demoClient.ConfigStrings
+	if player.MapFilename() == "" {
+		return nil, fmt.Errorf("Demo %v doesn't have a map", filename)
+	}
+
+	fmt.Println("Demo loaded:", player.ServerData.LevelName, "with", len(player.snapshots), "frames,",
+		fmt.Sprintf("%.1f", player.Duration()), "seconds")
+	return player, nil
+}
+
+// The server sends the map path as the first model
+func (player *DemoPlayer) MapFilename() string {
+	return player.ConfigStrings[q2file.ConfigStringModels+1]
+}
+
+// The server sends the style patterns, which change when switchable lights are turned on or off
+// The patterns of the frame at the current playback time are used, so seeking shows the right lights
+func (player *DemoPlayer) LightStyles() [q2file.MaxLightStyles]string {
+	prev, _, _ := player.currentSnapshots()
+	if prev.LightStyles == nil {
+		return [q2file.MaxLightStyles]string{}
+	}
+	return *prev.LightStyles
+}
+
+// The server sends the sky chosen by worldspawn, which is stored with the same keys
+func (player *DemoPlayer) SkyEntity() q2file.Entity {
+	return q2file.Entity{
+		Fields: []q2file.EntityField{
+			{Key: "sky", Value: player.ConfigStrings[q2file.ConfigStringSky]},
+			{Key: "skyrotate", Value: player.ConfigStrings[q2file.ConfigStringSkyRotate]},
+			{Key: "skyaxis", Value: player.ConfigStrings[q2file.ConfigStringSkyAxis]},
+		},
+	}
+}
+
+func (player *DemoPlayer) Duration() float64 {
+	return player.snapshotTime(len(player.snapshots) - 1)
+}
+
+func (player *DemoPlayer) snapshotTime(index int) float64 {
+	firstFrame := player.snapshots[0].ServerFrame
+	return float64(player.snapshots[index].ServerFrame-firstFrame) * q2file.DM2FrameTime
+}
+
+// Advance the playback time and handle the pause, seek and speed keys
+func (player *DemoPlayer) Update(deltaTime float64, input *client.InputHandler) {
+	if input.WasPressed(client.DEMO_PAUSE) {
+		player.paused = !player.paused
+		// Start over when unpausing at the end
+		if !player.paused && player.time >= player.Duration() {
+			player.time = 0
+		}
+	}
+	if input.WasPressed(client.DEMO_SEEK_FORWARD) {
+		player.Seek(player.time + demoSeekSeconds)
+	}
+	if input.WasPressed(client.DEMO_SEEK_BACKWARD) {
+		player.Seek(player.time - demoSeekSeconds)
+	}
+	if input.WasPressed(client.DEMO_RESTART) {
+		player.Seek(0)
+	}
+	if input.WasPressed(client.DEMO_FASTER) {
+		player.speed = math.Min(player.speed*2, demoMaxSpeed)
+		fmt.Println("Demo speed:", player.speed)
+	}
+	if input.WasPressed(client.DEMO_SLOWER) {
+		player.speed = math.Max(player.speed/2, demoMinSpeed)
+		fmt.Println("Demo speed:", player.speed)
+	}
+
+	if player.paused {
+		return
+	}
+	player.time += deltaTime * player.speed
+	if player.time >= player.Duration() {
+		player.time = player.Duration()
+		player.paused = true
+	}
+}
+
+func (player *DemoPlayer) Seek(time float64) {
+	player.time = math.Max(0, math.Min(time, player.Duration()))
+}
+
+// Get the frames before and after the current time, and how far between them we are
+func (player *DemoPlayer) currentSnapshots() (*q2file.DM2Snapshot, *q2file.DM2Snapshot, float32) {
+	nextIndex := sort.Search(len(player.snapshots), func(i int) bool {
+		return player.snapshotTime(i) > player.time
+	})
+	if nextIndex == 0 {
+		return &player.snapshots[0], &player.snapshots[0], 1
+	}
+	if nextIndex >= len(player.snapshots) {
+		last := &player.snapshots[len(player.snapshots)-1]
+		return last, last, 1
+	}
+
+	prevTime := player.snapshotTime(nextIndex - 1)
+	nextTime := player.snapshotTime(nextIndex)
+	lerp := float32((player.time - prevTime) / (nextTime - prevTime))
+	return &player.snapshots[nextIndex-1], &player.snapshots[nextIndex], lerp
+}
+
+// Get the view position and angles of the recording player
+func (player *DemoPlayer) GetView() ([3]float32, [3]float32) {
+	prev, next, lerp := player.currentSnapshots()
+	prevState := &prev.PlayerState
+	nextState := &next.PlayerState
+
+	if !isWithinDistance(prevState.Origin, nextState.Origin, demoPlayerTeleportDistance) {
+		prevState = nextState
+	}
+
+	var origin [3]float32
+	var angles [3]float32
+	for i := 0; i < 3; i++ {
+		origin[i] = lerpFloat(prevState.Origin[i]+prevState.ViewOffset[i], nextState.Origin[i]+nextState.ViewOffset[i], lerp)
+		angles[i] = q2file.LerpAngle(prevState.ViewAngles[i], nextState.ViewAngles[i], lerp) +
+			lerpFloat(prevState.KickAngles[i], nextState.KickAngles[i], lerp)
+	}
+	return origin, angles
+}
+
+// Get all entities in the current frame, interpolated from the previous frame
+func (player *DemoPlayer) GetEntities() []DemoEntity {
+	prev, next, lerp := player.currentSnapshots()
+	viewEntity := int(player.ServerData.PlayerNum) + 1
+
+	entities := make([]DemoEntity, 0, len(next.Entities))
+	for _, state := range next.Entities {
+		// The recording player is the camera
+		if state.Number == viewEntity || state.ModelIndex[0] == 0 {
+			continue
+		}
+
+		// Entities that just appeared or teleported snap to their new position
+		prevState := prev.FindEntity(state.Number)
+		if prevState == nil || prevState.ModelIndex[0] != state.ModelIndex[0] ||
+			state.Event == entityEventPlayerTeleport || state.Event == entityEventOtherTeleport ||
+			!isWithinDistance(prevState.Origin, state.Origin, demoTeleportDistance) {
+			prevState = &state
+		}
+
+		entity := DemoEntity{
+			State:     state,
+			OldFrame:  int(prevState.Frame),
+			FrameLerp: lerp,
+		}
+		for i := 0; i < 3; i++ {
+			entity.Origin[i] = lerpFloat(prevState.Origin[i], state.Origin[i], lerp)
+			entity.Angles[i] = q2file.LerpAngle(prevState.Angles[i], state.Angles[i], lerp)
+		}
+		if state.Effects&entityEffectRotate != 0 {
+			entity.Angles[1] = float32(math.Mod(player.time*100, 360))
+		}
+		entities = append(entities, entity)
+	}
+	return entities
+}
+
+// Use the areas the server marked as visible instead of the portal states
+func (player *DemoPlayer) GetAreaVisibility() *demoAreaVisibility {
+	_, next, _ := player.currentSnapshots()
+	return &demoAreaVisibility{snapshot: next}
+}
+
+type demoAreaVisibility struct {
+	snapshot *q2file.DM2Snapshot
+}
+
+func (visibility *demoAreaVisibility) AreasConnected(area1 int, area2 int) bool {
+	// Some servers don't send area bits
+	if len(visibility.snapshot.AreaBits) == 0 {
+		return true
+	}
+	return visibility.snapshot.IsAreaVisible(area2)
+}
+
+func isWithinDistance(a [3]float32, b [3]float32, distance float32) bool {
+	for i := 0; i < 3; i++ {
+		if float32(math.Abs(float64(a[i]-b[i]))) > distance {
+			return false
+		}
+	}
+	return true
+}
+
+func lerpFloat(from float32, to float32, lerp float32) float32 {
+	return from + (to-from)*lerp
+}
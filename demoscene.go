@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/samuelyuan/go-quake2/q2file"
+	"github.com/samuelyuan/go-quake2/render"
+)
+
+const (
+	// Model index used for player models, which depend on the player skin
+	demoPlayerModelIndex = 255
+	defaultPlayerModel   = "male"
+	defaultPlayerSkin    = "grunt"
+)
+
+// Loads and draws the models used by the entities in a demo
+// Each model is loaded the first time an entity uses it
+type DemoScene struct {
+	fileSystem  *q2file.FileSystem
+	mapData     *q2file.MapData
+	mapTextures []render.MapTexture
+
+	modelMeshes  map[string]*render.ModelMesh
+	spriteMeshes map[string]*render.SpriteMesh
+	brushModels  map[int]*render.RenderMap
+}
+
+func NewDemoScene(fileSystem *q2file.FileSystem, mapData *q2file.MapData, mapTextures []render.MapTexture) *DemoScene {
+	return &DemoScene{
+		fileSystem:   fileSystem,
+		mapData:      mapData,
+		mapTextures:  mapTextures,
+		modelMeshes:  make(map[string]*render.ModelMesh),
+		spriteMeshes: make(map[string]*render.SpriteMesh),
+		brushModels:  make(map[int]*render.RenderMap),
+	}
+}
+
+func (scene *DemoScene) Draw(
+	renderer *render.Renderer,
+	player *DemoPlayer,
+	elapsedTime float64,
+	styleValues [q2file.MaxLightStyles]float32,
+) {
+	modelInstances := make([]render.ModelInstance, 0)
+	spriteInstances := make([]render.SpriteInstance, 0)
+
+	for _, entity := range player.GetEntities() {
+		for slot, modelIndex := range entity.State.ModelIndex {
+			if modelIndex == 0 {
+				continue
+			}
+			modelName, skinName := scene.getModelName(player, slot, modelIndex, entity.State.Skin)
+
+			switch {
+			case strings.HasPrefix(modelName, "*"):
+				if renderMap, ok := scene.getBrushModel(modelName); ok {
+					render.UpdateLightStyles(renderMap, scene.mapData, styleValues)
+					render.DrawBrushModel(renderer, renderMap, entity.Origin, entity.Angles, elapsedTime)
+				}
+			case strings.HasSuffix(strings.ToLower(modelName), ".md2"):
+				mesh := scene.getModelMesh(modelName, skinName)
+				if mesh == nil {
+					continue
+				}
+				modelInstances = append(modelInstances, render.ModelInstance{
+					Mesh:       mesh,
+					Origin:     entity.Origin,
+					Angles:     entity.Angles,
+					FixedFrame: true,
+					OldFrame:   entity.OldFrame,
+					Frame:      int(entity.State.Frame),
+					FrameLerp:  entity.FrameLerp,
+				})
+			case strings.HasSuffix(strings.ToLower(modelName), ".sp2"):
+				mesh := scene.getSpriteMesh(modelName)
+				if mesh == nil {
+					continue
+				}
+				spriteInstances = append(spriteInstances, render.SpriteInstance{
+					Mesh:   mesh,
+					Origin: entity.Origin,
+					Frame:  int(entity.State.Frame),
+					Alpha:  1.0,
+				})
+			}
+		}
+	}
+
+	render.DrawModels(renderer, modelInstances, elapsedTime)
+	render.DrawSprites(renderer, spriteInstances, elapsedTime)
+}
+
+// Player models and weapons come from the skin config string, "name\model/skin"
+// The player model is in the first slot and the weapon is in the second slot
+func (scene *DemoScene) getModelName(player *DemoPlayer, slot int, modelIndex uint8, skin int32) (string, string) {
+	if modelIndex != demoPlayerModelIndex {
+		return player.ConfigStrings[q2file.ConfigStringModels+int(modelIndex)], ""
+	}
+
+	playerModel := defaultPlayerModel
+	playerSkin := defaultPlayerSkin
+	skinString := player.ConfigStrings[q2file.ConfigStringPlayerSkins+int(skin&0xff)]
+	if separator := strings.Index(skinString, "\\"); separator >= 0 {
+		modelSkin := strings.SplitN(skinString[separator+1:], "/", 2)
+		if len(modelSkin) == 2 && modelSkin[0] != "" && modelSkin[1] != "" {
+			playerModel = modelSkin[0]
+			playerSkin = modelSkin[1]
+		}
+	}
+	if slot > 0 {
+		return "players/" + playerModel + "/weapon.md2", "players/" + playerModel + "/weapon.pcx"
+	}
+	return "players/" + playerModel + "/tris.md2", "players/" + playerModel + "/" + playerSkin + ".pcx"
+}
+
+func (scene *DemoScene) getBrushModel(modelName string) (*render.RenderMap, bool) {
+	modelId, err := strconv.Atoi(modelName[1:])
+	if err != nil || modelId <= 0 || modelId >= len(scene.mapData.Models) {
+		return nil, false
+	}
+
+	renderMap, loaded := scene.brushModels[modelId]
+	if !loaded {
+		renderMap = render.CreateModelRenderingData(scene.mapData, scene.mapTextures, []int{modelId})
+		scene.brushModels[modelId] = renderMap
+	}
+	return renderMap, true
+}
+
+// Players share the same mesh with a different skin
+func (scene *DemoScene) getModelMesh(modelName string, skinName string) *render.ModelMesh {
+	key := modelName + ":" + skinName
+	if mesh, loaded := scene.modelMeshes[key]; loaded {
+		return mesh
+	}
+
+	mesh, loaded := scene.modelMeshes[modelName]
+	if !loaded {
+		mesh = loadModelMesh(scene.fileSystem, modelName)
+		scene.modelMeshes[modelName] = mesh
+	}
+	if mesh != nil && skinName != "" {
+		skinnedMesh := *mesh
+		skinnedMesh.SkinTexture = loadSkinTexture(scene.fileSystem, skinName)
+		mesh = &skinnedMesh
+	}
+	scene.modelMeshes[key] = mesh
+	return mesh
+}
+
+func (scene *DemoScene) getSpriteMesh(modelName string) *render.SpriteMesh {
+	mesh, loaded := scene.spriteMeshes[modelName]
+	if !loaded {
+		mesh = loadSpriteMesh(scene.fileSystem, modelName)
+		scene.spriteMeshes[modelName] = mesh
+	}
+	return mesh
+}
+
+func printDemoControls() {
+	fmt.Println("Demo controls: space to pause, left/right to seek, up/down to change speed, home to restart")
+}
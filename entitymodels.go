@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/samuelyuan/go-quake2/q2file"
+	"github.com/samuelyuan/go-quake2/render"
+)
+
+// Models used by the game code for each entity class
+var entityModelFilenames = map[string]string{
+	"monster_soldier_light":  "models/monsters/soldier/tris.md2",
+	"monster_soldier":        "models/monsters/soldier/tris.md2",
+	"monster_soldier_ss":     "models/monsters/soldier/tris.md2",
+	"monster_infantry":       "models/monsters/infantry/tris.md2",
+	"monster_gunner":         "models/monsters/gunner/tris.md2",
+	"monster_berserk":        "models/monsters/berserk/tris.md2",
+	"monster_gladiator":      "models/monsters/gladiatr/tris.md2",
+	"monster_tank":           "models/monsters/tank/tris.md2",
+	"monster_tank_commander": "models/monsters/tank/tris.md2",
+	"monster_medic":          "models/monsters/medic/tris.md2",
+	"monster_flyer":          "models/monsters/flyer/tris.md2",
+	"monster_hover":          "models/monsters/hover/tris.md2",
+	"monster_parasite":       "models/monsters/parasite/tris.md2",
+	"monster_mutant":         "models/monsters/mutant/tris.md2",
+	"monster_brain":          "models/monsters/brain/tris.md2",
+	"monster_flipper":        "models/monsters/flipper/tris.md2",
+	"monster_floater":        "models/monsters/float/tris.md2",
+	"monster_chick":          "models/monsters/bitch/tris.md2",
+	"monster_supertank":      "models/monsters/boss1/tris.md2",
+	"monster_boss2":          "models/monsters/boss2/tris.md2",
+	"monster_jorg":           "models/monsters/boss3/jorg/tris.md2",
+	"monster_makron":         "models/monsters/boss3/rider/tris.md2",
+	"monster_insane":         "models/monsters/insane/tris.md2",
+	"item_armor_body":        "models/items/armor/body/tris.md2",
+	"item_armor_combat":      "models/items/armor/combat/tris.md2",
+	"item_armor_jacket":      "models/items/armor/jacket/tris.md2",
+	"item_armor_shard":       "models/items/armor/shard/tris.md2",
+	"item_power_screen":      "models/items/armor/screen/tris.md2",
+	"item_power_shield":      "models/items/armor/shield/tris.md2",
+	"item_health":            "models/items/healing/medium/tris.md2",
+	"item_health_small":      "models/items/healing/stimpack/tris.md2",
+	"item_health_large":      "models/items/healing/large/tris.md2",
+	"item_health_mega":       "models/items/mega_h/tris.md2",
+	"item_quad":              "models/items/quaddama/tris.md2",
+	"item_invulnerability":   "models/items/invulner/tris.md2",
+	"item_silencer":          "models/items/silencer/tris.md2",
+	"item_breather":          "models/items/breather/tris.md2",
+	"item_enviro":            "models/items/enviro/tris.md2",
+	"item_adrenaline":        "models/items/adrenal/tris.md2",
+	"item_bandolier":         "models/items/band/tris.md2",
+	"item_pack":              "models/items/pack/tris.md2",
+	"weapon_shotgun":         "models/weapons/g_shotg/tris.md2",
+	"weapon_supershotgun":    "models/weapons/g_shotg2/tris.md2",
+	"weapon_machinegun":      "models/weapons/g_machn/tris.md2",
+	"weapon_chaingun":        "models/weapons/g_chain/tris.md2",
+	"weapon_grenadelauncher": "models/weapons/g_launch/tris.md2",
+	"weapon_rocketlauncher":  "models/weapons/g_rocket/tris.md2",
+	"weapon_hyperblaster":    "models/weapons/g_hyperb/tris.md2",
+	"weapon_railgun":         "models/weapons/g_rail/tris.md2",
+	"weapon_bfg":             "models/weapons/g_bfg/tris.md2",
+	"ammo_shells":            "models/items/ammo/shells/medium/tris.md2",
+	"ammo_bullets":           "models/items/ammo/bullets/medium/tris.md2",
+	"ammo_cells":             "models/items/ammo/cells/medium/tris.md2",
+	"ammo_rockets":           "models/items/ammo/rockets/medium/tris.md2",
+	"ammo_slugs":             "models/items/ammo/slugs/medium/tris.md2",
+	"ammo_grenades":          "models/items/ammo/grenades/medium/tris.md2",
+	"key_data_cd":            "models/items/keys/data_cd/tris.md2",
+	"key_power_cube":         "models/items/keys/power/tris.md2",
+	"key_pyramid":            "models/items/keys/pyramid/tris.md2",
+	"key_data_spinner":       "models/items/keys/spinner/tris.md2",
+	"key_pass":               "models/items/keys/pass/tris.md2",
+	"key_blue_key":           "models/items/keys/key/tris.md2",
+	"key_red_key":            "models/items/keys/red_key/tris.md2",
+	"key_commander_head":     "models/monsters/commandr/head/tris.md2",
+	"key_airstrike_target":   "models/items/keys/target/tris.md2",
+	"misc_explobox":          "models/objects/barrels/tris.md2",
+	"misc_banner":            "models/objects/banner/tris.md2",
+	"misc_satellite_dish":    "models/objects/satellite/tris.md2",
+	"misc_deadsoldier":       "models/deadbods/dude/tris.md2",
+	"misc_bigviper":          "models/ships/bigviper/tris.md2",
+	"misc_viper":             "models/ships/viper/tris.md2",
+	"misc_viper_bomb":        "models/objects/bomb/tris.md2",
+	"misc_strogg_ship":       "models/ships/strogg1/tris.md2",
+	"misc_blackhole":         "models/objects/black/tris.md2",
+	"misc_eastertank":        "models/monsters/tank/tris.md2",
+	"misc_easterchick":       "models/monsters/bitch/tris.md2",
+	"misc_easterchick2":      "models/monsters/bitch/tris.md2",
+	"misc_gib_arm":           "models/objects/gibs/arm/tris.md2",
+	"misc_gib_leg":           "models/objects/gibs/leg/tris.md2",
+	"misc_gib_head":          "models/objects/gibs/head/tris.md2",
+	"misc_actor":             "players/male/tris.md2",
+	"misc_insane":            "models/monsters/insane/tris.md2",
+	"turret_driver":          "models/monsters/infantry/tris.md2",
+	"monster_commander_body": "models/monsters/commandr/tris.md2",
+	"misc_transport":         "models/objects/ship/tris.md2",
+}
+
+// Get the MD2 model for an entity, either from its class or its model key
+func getEntityModelFilename(entity q2file.Entity) string {
+	filename, exists := entityModelFilenames[entity.ClassName()]
+	if exists {
+		return filename
+	}
+
+	// Some entities set the model directly, but "*N" is a brush model
+	model, hasModel := entity.Get("model")
+	if hasModel && strings.HasSuffix(strings.ToLower(model), ".md2") {
+		return model
+	}
+	return ""
+}
+
+func getEntityAngles(entity q2file.Entity) [3]float32 {
+	if angles, err := entity.GetVector("angles"); err == nil {
+		return angles
+	}
+	if angle, err := entity.GetFloat("angle"); err == nil {
+		return [3]float32{0, angle, 0}
+	}
+	return [3]float32{}
+}
+
+// Load the MD2 models for misc, item and monster entities
+// Each model file is only loaded once
+func createModelInstances(fileSystem *q2file.FileSystem, entities []q2file.Entity) []render.ModelInstance {
+	meshes := make(map[string]*render.ModelMesh)
+	instances := make([]render.ModelInstance, 0)
+
+	for _, entity := range entities {
+		filename := getEntityModelFilename(entity)
+		if filename == "" {
+			continue
+		}
+		origin, err := entity.GetVector("origin")
+		if err != nil {
+			continue
+		}
+
+		mesh, loaded := meshes[filename]
+		if !loaded {
+			mesh = loadModelMesh(fileSystem, filename)
+			meshes[filename] = mesh
+		}
+		if mesh == nil {
+			continue
+		}
+
+		// Loop the animation that starts at the first frame
+		firstFrame, numFrames := mesh.MD2Model.FindAnimation(0)
+		instances = append(instances, render.ModelInstance{
+			Mesh:       mesh,
+			Origin:     origin,
+			Angles:     getEntityAngles(entity),
+			FirstFrame: firstFrame,
+			NumFrames:  numFrames,
+		})
+	}
+
+	fmt.Println("Model count:", len(meshes), "instance count:", len(instances))
+	return instances
+}
+
+func loadModelMesh(fileSystem *q2file.FileSystem, filename string) *render.ModelMesh {
+	md2Reader, err := fileSystem.Open(filename)
+	if err != nil {
+		fmt.Println("Warning: model", filename, "is missing.")
+		return nil
+	}
+	md2Model, err := q2file.LoadQ2MD2(md2Reader)
+	if err != nil {
+		fmt.Println("Warning: model", filename, "can't be loaded:", err)
+		return nil
+	}
+
+	skinTexture := uint32(0)
+	if len(md2Model.Skins) > 0 {
+		skinTexture = loadSkinTexture(fileSystem, md2Model.Skins[0])
+	}
+	return render.NewModelMesh(md2Model, skinTexture)
+}
+
+func loadSkinTexture(fileSystem *q2file.FileSystem, filename string) uint32 {
+	pcxReader, err := fileSystem.Open(filename)
+	if err != nil {
+		fmt.Println("Warning: skin", filename, "is missing.")
+		return 0
+	}
+	skinImage, _, err := q2file.LoadQ2PCX(pcxReader)
+	if err != nil {
+		fmt.Println("Warning: skin", filename, "can't be loaded:", err)
+		return 0
+	}
+	return render.BuildImageTexture(skinImage)
+}
+
+// Load the SP2 sprites for entities that set a sprite as their model
+func createSpriteInstances(fileSystem *q2file.FileSystem, entities []q2file.Entity) []render.SpriteInstance {
+	meshes := make(map[string]*render.SpriteMesh)
+	instances := make([]render.SpriteInstance, 0)
+
+	for _, entity := range entities {
+		filename, hasModel := entity.Get("model")
+		if !hasModel || !strings.HasSuffix(strings.ToLower(filename), ".sp2") {
+			continue
+		}
+		origin, err := entity.GetVector("origin")
+		if err != nil {
+			continue
+		}
+
+		mesh, loaded := meshes[filename]
+		if !loaded {
+			mesh = loadSpriteMesh(fileSystem, filename)
+			meshes[filename] = mesh
+		}
+		if mesh == nil {
+			continue
+		}
+
+		instances = append(instances, render.SpriteInstance{
+			Mesh:    mesh,
+			Origin:  origin,
+			Animate: true,
+			Alpha:   1.0,
+		})
+	}
+
+	fmt.Println("Sprite count:", len(meshes), "instance count:", len(instances))
+	return instances
+}
+
+func loadSpriteMesh(fileSystem *q2file.FileSystem, filename string) *render.SpriteMesh {
+	sp2Reader, err := fileSystem.Open(filename)
+	if err != nil {
+		fmt.Println("Warning: sprite", filename, "is missing.")
+		return nil
+	}
+	sprite, err := q2file.LoadQ2SP2(sp2Reader)
+	if err != nil {
+		fmt.Println("Warning: sprite", filename, "can't be loaded:", err)
+		return nil
+	}
+
+	frameTextures := make([]uint32, len(sprite.Frames))
+	for i, frame := range sprite.Frames {
+		frameTextures[i] = loadSpriteTexture(fileSystem, frame.SkinName)
+	}
+	return render.NewSpriteMesh(sprite, frameTextures)
+}
+
+// Sprite frames use palette index 255 for transparent pixels
+func loadSpriteTexture(fileSystem *q2file.FileSystem, filename string) uint32 {
+	pcxReader, err := fileSystem.Open(filename)
+	if err != nil {
+		fmt.Println("Warning: sprite frame", filename, "is missing.")
+		return 0
+	}
+	frameImage, _, err := q2file.LoadQ2PCX(pcxReader)
+	if err != nil {
+		fmt.Println("Warning: sprite frame", filename, "can't be loaded:", err)
+		return 0
+	}
+	if palettedImage, ok := frameImage.(*image.Paletted); ok && len(palettedImage.Palette) == 256 {
+		palette := make(color.Palette, len(palettedImage.Palette))
+		copy(palette, palettedImage.Palette)
+		palette[255] = color.RGBA{}
+		palettedImage.Palette = palette
+	}
+	return render.BuildImageTexture(frameImage)
+}
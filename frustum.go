@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// The six planes around the part of the world the camera can see
+// Each plane is stored as (a, b, c, d) with the normal pointing inside
+type Frustum struct {
+	Planes [6]mgl32.Vec4
+}
+
+// Extract the planes from the combined projection and view matrix
+func NewFrustum(projectionMatrix mgl32.Mat4, viewMatrix mgl32.Mat4) Frustum {
+	clip := projectionMatrix.Mul4(viewMatrix)
+	row0, row1, row2, row3 := clip.Row(0), clip.Row(1), clip.Row(2), clip.Row(3)
+
+	return Frustum{
+		Planes: [6]mgl32.Vec4{
+			row3.Add(row0), // left
+			row3.Sub(row0), // right
+			row3.Add(row1), // bottom
+			row3.Sub(row1), // top
+			row3.Add(row2), // near
+			row3.Sub(row2), // far
+		},
+	}
+}
+
+// A box is outside if the corner closest to the inside of a plane is still behind it
+func (frustum Frustum) IntersectsBox(boxMin [3]float32, boxMax [3]float32) bool {
+	for _, plane := range frustum.Planes {
+		corner := boxMin
+		for axis := 0; axis < 3; axis++ {
+			if plane[axis] > 0 {
+				corner[axis] = boxMax[axis]
+			}
+		}
+		if plane[0]*corner[0]+plane[1]*corner[1]+plane[2]*corner[2]+plane[3] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Logs how many faces were drawn and how many were culled by the frustum
+// The counts are printed when they change, at most once per second
+type CullingStats struct {
+	drawnFaces  int
+	culledFaces int
+	lastLogTime float64
+}
+
+func (stats *CullingStats) Update(drawnFaces int, culledFaces int, elapsedTime float64) {
+	if drawnFaces == stats.drawnFaces && culledFaces == stats.culledFaces {
+		return
+	}
+	if elapsedTime-stats.lastLogTime < 1.0 {
+		return
+	}
+	fmt.Println("Faces drawn:", drawnFaces, "culled by frustum:", culledFaces)
+	stats.drawnFaces = drawnFaces
+	stats.culledFaces = culledFaces
+	stats.lastLogTime = elapsedTime
+}
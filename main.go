@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
-	"io"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"log"
-	"os"
 	"runtime"
 	"sort"
 	"strings"
@@ -25,10 +28,12 @@ var (
 )
 
 func createTextureList(
-	pakReader io.ReaderAt,
-	pakFileMap map[string]q2file.PakFile,
+	fileSystem *q2file.FileSystem,
+	replacementFileSystem *q2file.FileSystem,
 	textureIds map[string]int,
 ) []render.MapTexture {
+	palette := loadPalette(fileSystem)
+
 	// get sorted strings
 	var fileKeys []string
 	for texFilename := range textureIds {
@@ -38,48 +43,254 @@ func createTextureList(
 
 	// iterate through filenames in the same order
 	oldMapTextures := make([]render.MapTexture, len(fileKeys))
+	replacementCount := 0
 	for i := 0; i < len(fileKeys); i++ {
 		// stored in different folder
-		// append extension (.wal) as default
-		fullFilename := "textures/" + strings.Trim(fileKeys[i], " ") + ".wal"
-		fullFilename = strings.ToLower(fullFilename)
-		imageData, walData, err := q2file.LoadQ2WALFromPAK(pakReader, pakFileMap, fullFilename)
+		// the extension is added depending on which image is found
+		baseFilename := "textures/" + strings.Trim(fileKeys[i], " ")
+		baseFilename = strings.ToLower(baseFilename)
 
+		// the index is not necessarily in order
+		index := textureIds[fileKeys[i]]
+		mapTexture, isReplacement, err := loadMapTexture(fileSystem, replacementFileSystem, baseFilename, palette)
 		if err != nil {
-			fmt.Println("Warning: texture", fullFilename, "is missing.")
-			index := textureIds[fileKeys[i]]
+			fmt.Println("Warning: texture", baseFilename+".wal", "is missing.")
 			oldMapTextures[index] = render.NewMapTexture(0, 0, 0)
 			continue
 		}
-
-		// the index is not necessarily in order
-		index := textureIds[fileKeys[i]]
-		texId := render.BuildWALTexture(imageData, walData)
-		oldMapTextures[index] = render.NewMapTexture(texId, walData.Width, walData.Height)
+		if isReplacement {
+			replacementCount++
+		}
+		oldMapTextures[index] = mapTexture
 	}
 
+	if replacementCount > 0 {
+		fmt.Println("Replacement textures loaded:", replacementCount)
+	}
 	return oldMapTextures
 }
 
-func initMesh(pakFilename string, bspFilename string) (*q2file.MapData, []render.MapTexture, error) {
-	pakFile, err := os.Open(pakFilename)
-	defer pakFile.Close()
+// Use a PNG or TGA replacement if there is one, otherwise load the WAL texture
+// Replacements keep the WAL size, since the texture coordinates are in WAL pixels
+func loadMapTexture(
+	fileSystem *q2file.FileSystem,
+	replacementFileSystem *q2file.FileSystem,
+	baseFilename string,
+	palette q2file.Palette,
+) (render.MapTexture, bool, error) {
+	walReader, walErr := fileSystem.Open(baseFilename + ".wal")
 
+	replacementImage, replacementFilename := loadReplacementImage(fileSystem, replacementFileSystem, baseFilename)
+	if replacementImage != nil {
+		bounds := replacementImage.Bounds()
+		width, height := uint32(bounds.Dx()), uint32(bounds.Dy())
+		if walErr == nil {
+			if walData, err := q2file.LoadQ2WALHeader(walReader); err == nil {
+				width, height = walData.Width, walData.Height
+			}
+		} else {
+			fmt.Println("Warning: texture", replacementFilename, "has no WAL file, using the image size.")
+		}
+		texId := render.BuildImageTexture(replacementImage)
+		return render.NewMapTexture(texId, width, height), true, nil
+	}
+
+	if walErr != nil {
+		return render.MapTexture{}, false, walErr
+	}
+	mipLevels, walData, err := q2file.LoadQ2WALWithPalette(walReader, palette)
 	if err != nil {
-		log.Fatal("PAK file ", pakFilename, " doesn't exist")
-		return nil, nil, err
+		return render.MapTexture{}, false, err
+	}
+	texId := render.BuildWALTexture(mipLevels, walData, true)
+	return render.NewMapTexture(texId, walData.Width, walData.Height), false, nil
+}
+
+// Quake 1 textures are embedded in the map, or stored in the WAD files listed by worldspawn
+// PNG/TGA replacements are still used if there are any
+func createQ1TextureList(
+	fileSystem *q2file.FileSystem,
+	replacementFileSystem *q2file.FileSystem,
+	mapData *q2file.MapData,
+) []render.MapTexture {
+	palette := loadQ1Palette(fileSystem)
+	wads := loadWorldspawnWADs(fileSystem, mapData.Entities)
+
+	mapTextures := make([]render.MapTexture, len(mapData.TextureIds))
+	for textureName, index := range mapData.TextureIds {
+		texture, exists := mapData.MipTextures[textureName]
+		for i := 0; i < len(wads) && !exists; i++ {
+			if wadTexture, err := wads[i].LoadMipTexture(textureName); err == nil {
+				texture, exists = wadTexture, true
+			}
+		}
+		if !exists {
+			fmt.Println("Warning: texture", textureName, "isn't in the map or any WAD file.")
+			mapTextures[index] = render.NewMapTexture(0, 0, 0)
+			continue
+		}
+
+		baseFilename := strings.ToLower("textures/" + strings.Trim(textureName, " "))
+		if replacementImage, _ := loadReplacementImage(fileSystem, replacementFileSystem, baseFilename); replacementImage != nil {
+			texId := render.BuildImageTexture(replacementImage)
+			mapTextures[index] = render.NewMapTexture(texId, texture.Width, texture.Height)
+			continue
+		}
+
+		texId := render.BuildMipTexture(texture.ToRGB(palette), texture.Width, texture.Height, true)
+		mapTextures[index] = render.NewMapTexture(texId, texture.Width, texture.Height)
+	}
+	return mapTextures
+}
+
+// The "wad" key is a list of paths separated by semicolons, usually from the mapper's computer
+// Only the filename is used, which is searched in the game directory and gfx/
+func loadWorldspawnWADs(fileSystem *q2file.FileSystem, entities []q2file.Entity) []*q2file.Wad {
+	wads := make([]*q2file.Wad, 0)
+	worldspawns := q2file.FindEntitiesByClass(entities, "worldspawn")
+	if len(worldspawns) == 0 {
+		return wads
+	}
+	wadList, _ := worldspawns[0].Get("wad")
+
+	for _, wadPath := range strings.Split(wadList, ";") {
+		wadPath = strings.ReplaceAll(strings.TrimSpace(wadPath), "\\", "/")
+		if wadPath == "" {
+			continue
+		}
+		wadFilename := strings.ToLower(wadPath[strings.LastIndex(wadPath, "/")+1:])
+
+		loaded := false
+		for _, filename := range []string{wadFilename, "gfx/" + wadFilename} {
+			wadReader, err := fileSystem.Open(filename)
+			if err != nil {
+				continue
+			}
+			wad, err := q2file.LoadQ1WAD(wadReader)
+			if err != nil {
+				fmt.Println("Warning: WAD", filename, "can't be loaded:", err)
+				continue
+			}
+			wads = append(wads, wad)
+			loaded = true
+			break
+		}
+		if !loaded {
+			fmt.Println("Warning: WAD", wadFilename, "is missing.")
+		}
+	}
+	return wads
+}
+
+// The override folder is searched first, then the game files
+func loadReplacementImage(
+	fileSystem *q2file.FileSystem,
+	replacementFileSystem *q2file.FileSystem,
+	baseFilename string,
+) (image.Image, string) {
+	searchFileSystems := []*q2file.FileSystem{fileSystem}
+	if replacementFileSystem != nil {
+		searchFileSystems = []*q2file.FileSystem{replacementFileSystem, fileSystem}
+	}
+
+	for _, searchFileSystem := range searchFileSystems {
+		for _, extension := range []string{".png", ".tga", ".jpg"} {
+			filename := baseFilename + extension
+			imageReader, err := searchFileSystem.Open(filename)
+			if err != nil {
+				continue
+			}
+
+			var replacementImage image.Image
+			switch extension {
+			case ".png":
+				replacementImage, err = png.Decode(imageReader)
+			case ".jpg":
+				replacementImage, err = jpeg.Decode(imageReader)
+			default:
+				replacementImage, err = q2file.LoadQ2TGA(imageReader)
+			}
+			if err != nil {
+				fmt.Println("Warning: texture", filename, "can't be loaded:", err)
+				continue
+			}
+			return replacementImage, filename
+		}
+	}
+	return nil, ""
+}
+
+// Mods can replace the palette, so use colormap.pcx if it exists
+func loadPalette(fileSystem *q2file.FileSystem) q2file.Palette {
+	pcxReader, err := fileSystem.Open(q2file.PaletteFilename)
+	if err != nil {
+		fmt.Println("Warning: palette", q2file.PaletteFilename, "is missing, using the default palette.")
+		return q2file.DefaultPalette()
+	}
+
+	palette, err := q2file.LoadPaletteFromPCX(pcxReader)
+	if err != nil {
+		fmt.Println("Warning: palette", q2file.PaletteFilename, "can't be loaded, using the default palette:", err)
+		return q2file.DefaultPalette()
 	}
+	return palette
+}
+
+// Quake 1 has no colormap.pcx, so its palette is a raw lump
+func loadQ1Palette(fileSystem *q2file.FileSystem) q2file.Palette {
+	lmpReader, err := fileSystem.Open(q2file.Q1PaletteFilename)
+	if err != nil {
+		fmt.Println("Warning: palette", q2file.Q1PaletteFilename, "is missing, using the default palette.")
+		return q2file.DefaultPalette()
+	}
+
+	palette, err := q2file.LoadPaletteFromLMP(lmpReader)
+	if err != nil {
+		fmt.Println("Warning: palette", q2file.Q1PaletteFilename, "can't be loaded, using the default palette:", err)
+		return q2file.DefaultPalette()
+	}
+	return palette
+}
 
-	pakFileMap, err := q2file.LoadQ2PAK(pakFile)
+// Search the base game directory, then the mod directory on top of it
+func initFileSystem(baseDirectory string, modDirectory string) (*q2file.FileSystem, error) {
+	fileSystem := q2file.NewFileSystem()
+	if err := fileSystem.AddGameDirectory(baseDirectory); err != nil {
+		return nil, err
+	}
+	if modDirectory != "" {
+		if err := fileSystem.AddGameDirectory(modDirectory); err != nil {
+			fileSystem.Close()
+			return nil, err
+		}
+	}
+	return fileSystem, nil
+}
+
+func initMesh(
+	fileSystem *q2file.FileSystem,
+	replacementFileSystem *q2file.FileSystem,
+	bspFilename string,
+) (*q2file.MapData, []render.MapTexture, error) {
+	bspReader, err := fileSystem.Open(bspFilename)
+	if err != nil {
+		log.Fatal("Error loading bsp in main:", err)
+		return nil, nil, err
+	}
 
-	mapData, err := q2file.LoadQ2BSPFromPAK(pakFile, pakFileMap, bspFilename)
+	mapData, err := q2file.LoadBSP(bspReader)
 	if err != nil {
 		log.Fatal("Error loading bsp in main:", err)
 		return nil, nil, err
 	}
 	fmt.Println("BSP map successfully loaded")
 
-	oldMapTextures := createTextureList(pakFile, pakFileMap, mapData.TextureIds)
+	var oldMapTextures []render.MapTexture
+	if mapData.Version == q2file.Q1BSPVersion {
+		oldMapTextures = createQ1TextureList(fileSystem, replacementFileSystem, mapData)
+	} else {
+		oldMapTextures = createTextureList(fileSystem, replacementFileSystem, mapData.TextureIds)
+	}
 	if oldMapTextures == nil {
 		return nil, nil, fmt.Errorf("Error loading textures")
 	}
@@ -88,6 +299,15 @@ func initMesh(pakFilename string, bspFilename string) (*q2file.MapData, []render
 }
 
 func main() {
+	baseDirectory := flag.String("basedir", "./data", "directory with the base game files (pak0.pak)")
+	modDirectory := flag.String("game", "", "mod directory searched before the base directory")
+	bspFilename := flag.String("map", "maps/demo1.bsp", "map to load")
+	demoFilename := flag.String("demo", "", "DM2 demo to play back, the map is loaded from the demo")
+	textureDirectory := flag.String("texturedir", "", "directory with PNG/TGA/JPG replacement textures, searched before the game files")
+	tessellationLevel := flag.Int("tessellation", 8, "number of subdivisions along each side of a Quake 3 patch section")
+	openAreaPortals := flag.Bool("openportals", false, "open every area portal instead of starting them closed like the game does")
+	flag.Parse()
+
 	fmt.Println("Starting quake2 bsp loader\n")
 
 	// Run OpenGL code
@@ -102,7 +322,45 @@ func main() {
 	renderer.Init()
 
 	// Load files
-	mapData, mapTextures, err := initMesh("./data/pak0.pak", "maps/demo1.bsp")
+	fileSystem, err := initFileSystem(*baseDirectory, *modDirectory)
+	if err != nil {
+		fmt.Println("Error initializing file system: ", err)
+		return
+	}
+	defer fileSystem.Close()
+
+	// Replacement textures use the same paths as the game files, such as textures/e1u1/floor1_1.png
+	var replacementFileSystem *q2file.FileSystem
+	if *textureDirectory != "" {
+		replacementFileSystem = q2file.NewFileSystem()
+		if err := replacementFileSystem.AddGameDirectory(*textureDirectory); err != nil {
+			fmt.Println("Error initializing texture directory: ", err)
+			return
+		}
+		defer replacementFileSystem.Close()
+	}
+
+	// The demo decides which map is loaded
+	var demoPlayer *DemoPlayer
+	mapFilename := *bspFilename
+	if *demoFilename != "" {
+		demoPlayer, err = LoadDemoPlayer(fileSystem, *demoFilename)
+		if err != nil {
+			fmt.Println("Error loading demo: ", err)
+			return
+		}
+		mapFilename = demoPlayer.MapFilename()
+		printDemoControls()
+	}
+
+	if isQ3Map(fileSystem, mapFilename) {
+		if err := runQ3Map(renderer, fileSystem, replacementFileSystem, mapFilename, *tessellationLevel); err != nil {
+			fmt.Println("Error loading Quake 3 map: ", err)
+		}
+		return
+	}
+
+	mapData, mapTextures, err := initMesh(fileSystem, replacementFileSystem, mapFilename)
 	if err != nil {
 		fmt.Println("Error initializing mesh: ", err)
 		return
@@ -111,28 +369,127 @@ func main() {
 	bspTree := NewBSPTree(mapData)
 	fmt.Println("BSP Tree built")
 
+	// func_areaportal entities always start closed and doors are drawn in their closed position,
+	// so rooms behind a door stay hidden unless the portals are opened from the command line
+	areaConnectivity := q2file.NewAreaConnectivity(mapData)
+	if *openAreaPortals {
+		areaConnectivity.SetAllPortalStates(true)
+	}
+
 	camera := NewCamera(windowHandler)
 	prevLeaf := -1
 	curLeaf := 0
 
-	var renderMap render.RenderMap
+	// The whole world is built once, moving to a different leaf only changes which faces are drawn
+	renderMap := render.CreateRenderingData(mapData, mapTextures, render.GetModelFaceIds(mapData, 0))
+
+	// Demo entities replace the entities placed in the map
+	var demoScene *DemoScene
+	var modelRenderMap *render.RenderMap
+	var modelInstances []render.ModelInstance
+	var spriteInstances []render.SpriteInstance
+	if demoPlayer != nil {
+		demoScene = NewDemoScene(fileSystem, mapData, mapTextures)
+	} else {
+		// Brush entities (doors, platforms) aren't part of the leaf faces
+		// Quake 1 keeps the faces of trigger brushes, which are invisible in game
+		triggerModelIds := make(map[int]bool)
+		for _, entity := range mapData.Entities {
+			if modelId, ok := entity.GetInlineModel(); ok && strings.HasPrefix(entity.ClassName(), "trigger_") {
+				triggerModelIds[modelId] = true
+			}
+		}
+		inlineModelIds := make([]int, 0)
+		for modelId := 1; modelId < len(mapData.Models); modelId++ {
+			if triggerModelIds[modelId] {
+				continue
+			}
+			inlineModelIds = append(inlineModelIds, modelId)
+		}
+		modelRenderMap = render.CreateModelRenderingData(mapData, mapTextures, inlineModelIds)
+
+		// MD2 models for items, monsters and decorations
+		modelInstances = createModelInstances(fileSystem, mapData.Entities)
+		// SP2 sprites are drawn last since they are blended with everything behind them
+		spriteInstances = createSpriteInstances(fileSystem, mapData.Entities)
+	}
+	// The sky box is drawn behind the sky faces of the world
+	skyEntity := getWorldspawnSky(mapData)
+	if demoPlayer != nil {
+		skyEntity = demoPlayer.SkyEntity()
+	}
+	skyBox := loadSkyBox(fileSystem, skyEntity, mapData.Version != q2file.Q1BSPVersion)
+
+	var prevAreaBits []uint8
+	var visibleClusters []ClusterId
+	pvsFaceCount := 0
+	cullingStats := &CullingStats{}
+	prevViewMatrix := camera.GetViewMatrix()
+
+	// Switchable lights start in the state set by their spawnflags
+	lightStyles := q2file.LoadLightStyles(mapData.Entities)
 
 	for !windowHandler.ShouldClose() {
 		windowHandler.StartFrame()
+
+		// Follow the recorded player during demo playback
+		var areas areaConnector = areaConnectivity
+		areasChanged := false
+		if demoPlayer != nil {
+			demoPlayer.Update(windowHandler.GetTimeSinceLastFrame(), windowHandler.InputHandler)
+			viewOrigin, viewAngles := demoPlayer.GetView()
+			camera.SetView(viewOrigin, viewAngles[0], viewAngles[1])
+
+			areaVisibility := demoPlayer.GetAreaVisibility()
+			areas = areaVisibility
+			areasChanged = !bytes.Equal(prevAreaBits, areaVisibility.snapshot.AreaBits)
+			prevAreaBits = areaVisibility.snapshot.AreaBits
+		}
+
 		renderer.PrepareFrame(camera.GetViewMatrix(), camera.GetPerspectiveMatrix())
+		render.DrawSkyBox(renderer, skyBox, windowHandler.GetElapsedTime())
 
 		// Render map data to the screen
 		// Figure out which leaf the player is in and only render faces in that leaf
 		leaf := bspTree.findLeafNode(0, mapData, camera.GetCameraPosition())
 		curLeaf = leaf.LeafIndex
-		// Update the polygons if the player is in a different leaf
-		if prevLeaf != curLeaf {
-			if len(leaf.Faces) > 0 {
-				renderMap = render.CreateRenderingData(mapData, mapTextures, leaf.Faces)
+		// Update the potentially visible set if the player is in a different leaf
+		pvsChanged := prevLeaf != curLeaf || areasChanged
+		if pvsChanged {
+			visibleFaces := bspTree.getVisibleFaces(mapData, leaf, areas)
+			if len(visibleFaces) > 0 {
+				pvsFaceCount = len(visibleFaces)
+				visibleClusters = bspTree.getVisibleClusters(mapData, leaf, areas)
 			}
 			prevLeaf = curLeaf
 		}
-		render.DrawMap(renderer, renderMap)
+		// Only the faces in the potentially visible set that are in front of the camera are drawn
+		// The faces only change when the camera or the potentially visible set changes
+		viewChanged := camera.GetViewMatrix() != prevViewMatrix
+		if len(visibleClusters) > 0 && (pvsChanged || viewChanged) {
+			prevViewMatrix = camera.GetViewMatrix()
+			frustum := NewFrustum(camera.GetPerspectiveMatrix(), prevViewMatrix)
+			drawnFaces := bspTree.getFrustumVisibleFaces(mapData, visibleClusters, frustum)
+			renderMap.SetVisibleFaces(drawnFaces)
+			cullingStats.Update(len(drawnFaces), pvsFaceCount-len(drawnFaces), windowHandler.GetElapsedTime())
+		}
+		// Demos get the light styles from the server, which turns switchable lights on and off during the demo
+		if demoPlayer != nil {
+			lightStyles = demoPlayer.LightStyles()
+		}
+		styleValues := q2file.GetLightStyleValues(lightStyles, windowHandler.GetElapsedTime())
+		render.UpdateLightStyles(renderMap, mapData, styleValues)
+		render.DrawMap(renderer, renderMap, windowHandler.GetElapsedTime())
+
+		if demoScene != nil {
+			demoScene.Draw(renderer, demoPlayer, windowHandler.GetElapsedTime(), styleValues)
+			continue
+		}
+
+		render.UpdateLightStyles(modelRenderMap, mapData, styleValues)
+		render.DrawMap(renderer, modelRenderMap, windowHandler.GetElapsedTime())
+		render.DrawModels(renderer, modelInstances, windowHandler.GetElapsedTime())
+		render.DrawSprites(renderer, spriteInstances, windowHandler.GetElapsedTime())
 
 		camera.UpdateViewMatrix()
 	}
@@ -0,0 +1,492 @@
+package q2file
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+)
+
+const Q1BSPVersion = 29
+
+const (
+	Q1LumpEntities     = 0
+	Q1LumpPlanes       = 1
+	Q1LumpTextures     = 2
+	Q1LumpVertices     = 3
+	Q1LumpVisibility   = 4
+	Q1LumpBSPNodes     = 5
+	Q1LumpTexInfos     = 6
+	Q1LumpFaces        = 7
+	Q1LumpLightmaps    = 8
+	Q1LumpClipNodes    = 9
+	Q1LumpBSPLeaves    = 10
+	Q1LumpMarkSurfaces = 11
+	Q1LumpEdges        = 12
+	Q1LumpFaceEdges    = 13
+	Q1LumpModels       = 14
+)
+
+// Leaf contents are a single negative value instead of flags
+const (
+	Q1ContentsEmpty = int32(-1)
+	Q1ContentsSolid = int32(-2)
+	Q1ContentsWater = int32(-3)
+	Q1ContentsSlime = int32(-4)
+	Q1ContentsLava  = int32(-5)
+	Q1ContentsSky   = int32(-6)
+)
+
+type Q1Header struct {
+	Version uint32   // version of the BSP format (29), there is no magic number
+	Lumps   [15]Lump // directory of the lumps
+}
+
+type q1TexInfo struct {
+	UAxis   [3]float32
+	UOffset float32
+	VAxis   [3]float32
+	VOffset float32
+	MipTex  int32 // index of the texture (in the texture lump)
+	Flags   int32 // set for sky and liquids, which have no lightmap
+}
+
+type q1BSPNode struct {
+	Plane    int32
+	Children [2]int16 // negative children are leaves, -(leaf + 1)
+	BBoxMin  [3]int16
+	BBoxMax  [3]int16
+
+	FirstFace uint16
+	NumFaces  uint16
+}
+
+type q1BSPLeaf struct {
+	Contents         int32
+	VisibilityOffset int32 // -1 if there is no visibility information
+	BBoxMin          [3]int16
+	BBoxMax          [3]int16
+
+	FirstMarkSurface uint16 // index of the first face (in the mark surface array)
+	NumMarkSurfaces  uint16
+
+	AmbientLevels [4]uint8
+}
+
+type q1Model struct {
+	BBoxMin [3]float32
+	BBoxMax [3]float32
+	Origin  [3]float32
+
+	HeadNodes [4]int32 // node for drawing, followed by the clip node for each hull
+	VisLeafs  int32    // number of leaves with visibility information, not including leaf 0
+	FirstFace int32
+	NumFaces  int32
+}
+
+// Node in a Quake 1 collision hull
+type ClipNode struct {
+	Plane    int32
+	Children [2]int16 // negative children are contents values
+}
+
+// Read a Quake 1 map and convert it to the same map data as a Quake 2 map
+// Leaves become clusters, since Quake 1 stores visibility for every leaf
+// Lightmaps are expanded from grayscale to rgb
+func LoadQ1BSP(r io.ReaderAt) (*MapData, error) {
+	header := Q1Header{}
+
+	lumpReader := io.NewSectionReader(r, 0, int64(unsafe.Sizeof(header)))
+	if err := binary.Read(lumpReader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	if header.Version != Q1BSPVersion {
+		return nil, fmt.Errorf("BSP Header: Wrong version %v", header.Version)
+	}
+
+	fmt.Println("Header total lumps:", len(header.Lumps))
+
+	entities, err := loadEntities(header.Lumps[Q1LumpEntities], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load entities: %v", err)
+	}
+	vertices, err := loadVertices(header.Lumps[Q1LumpVertices], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load vertices")
+	}
+	edges, err := loadEdges(header.Lumps[Q1LumpEdges], r, false)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load edges")
+	}
+	// Faces have the same layout as Quake 2
+	faces, err := loadFaces(header.Lumps[Q1LumpFaces], r, false)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load faces")
+	}
+	faceEdges, err := loadFaceEdges(header.Lumps[Q1LumpFaceEdges], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load face edges")
+	}
+	textureNames, mipTextures, err := loadQ1MipTextures(header.Lumps[Q1LumpTextures], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load textures: %v", err)
+	}
+	texInfos, err := loadQ1TexInfos(header.Lumps[Q1LumpTexInfos], r, textureNames)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load texture info: %v", err)
+	}
+	lightmapData, err := loadQ1LightmapData(header.Lumps[Q1LumpLightmaps], r, faces)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load lightmap data")
+	}
+	bspNodes, err := loadQ1BSPNodes(header.Lumps[Q1LumpBSPNodes], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load BSP nodes")
+	}
+	planes, err := loadPlanes(header.Lumps[Q1LumpPlanes], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load BSP planes")
+	}
+	clipNodes, err := loadQ1ClipNodes(header.Lumps[Q1LumpClipNodes], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load clip nodes")
+	}
+	leafFaces, err := loadQ1MarkSurfaces(header.Lumps[Q1LumpMarkSurfaces], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load mark surfaces")
+	}
+	models, visLeafs, err := loadQ1Models(header.Lumps[Q1LumpModels], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load models")
+	}
+	visibilityData, err := loadVisibilityData(header.Lumps[Q1LumpVisibility], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load visibility data")
+	}
+	bspLeaves, visibilityData, visibilityOffsets, err := loadQ1BSPLeaves(header.Lumps[Q1LumpBSPLeaves], r, visibilityData, visLeafs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load BSP leaves")
+	}
+
+	for i, model := range models {
+		if int(model.FirstFace)+int(model.NumFaces) > len(faces) {
+			return nil, fmt.Errorf("Model %v has invalid face range %v-%v", i, model.FirstFace, model.FirstFace+model.NumFaces)
+		}
+	}
+
+	mapData := &MapData{
+		Version:           header.Version,
+		Entities:          entities,
+		Vertices:          vertices,
+		Edges:             edges,
+		Faces:             faces,
+		FaceEdges:         faceEdges,
+		TexInfos:          texInfos,
+		TextureIds:        getTextureIds(texInfos),
+		LightmapData:      lightmapData,
+		Nodes:             bspNodes,
+		Planes:            planes,
+		BSPLeaves:         bspLeaves,
+		LeafFaces:         leafFaces,
+		VisibilityData:    visibilityData,
+		VisibilityOffsets: visibilityOffsets,
+		Models:            models,
+		MipTextures:       mipTextures,
+		ClipNodes:         clipNodes,
+	}
+
+	return mapData, nil
+}
+
+// The texture lump starts with the number of textures and the offset of each one
+// Missing textures have an offset of -1, and textures that only have a name are stored in a WAD file
+func loadQ1MipTextures(lump Lump, r io.ReaderAt) ([]string, map[string]*MipTexture, error) {
+	mipTextures := make(map[string]*MipTexture)
+	if lump.Length == 0 {
+		return []string{}, mipTextures, nil
+	}
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	numTextures := int32(0)
+	if err := binary.Read(reader, binary.LittleEndian, &numTextures); err != nil {
+		return nil, nil, err
+	}
+	// Each texture has a 4 byte offset after the count
+	if numTextures < 0 || int64(numTextures) > (int64(lump.Length)-4)/4 {
+		return nil, nil, fmt.Errorf("Invalid texture count %v for lump of size %v", numTextures, lump.Length)
+	}
+	offsets := make([]int32, numTextures)
+	if err := binary.Read(reader, binary.LittleEndian, &offsets); err != nil {
+		return nil, nil, err
+	}
+
+	fmt.Println("Texture count:", numTextures)
+
+	textureNames := make([]string, numTextures)
+	for i, offset := range offsets {
+		if offset < 0 {
+			continue
+		}
+
+		textureReader := io.NewSectionReader(reader, int64(offset), int64(lump.Length)-int64(offset))
+		header := MipTexHeader{}
+		if err := binary.Read(textureReader, binary.LittleEndian, &header); err != nil {
+			return nil, nil, fmt.Errorf("texture %v: %v", i, err)
+		}
+		textureNames[i] = byteToString(header.Name[:])
+		if header.Offset[0] == 0 {
+			continue
+		}
+
+		texture, err := LoadMipTexture(textureReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		mipTextures[textureNames[i]] = texture
+	}
+	return textureNames, mipTextures, nil
+}
+
+// Texture info stores the texture index instead of the name, and has no surface flags
+// Sky and liquid surfaces are found by their texture names instead
+func loadQ1TexInfos(lump Lump, r io.ReaderAt, textureNames []string) ([]TexInfo, error) {
+	// A texture info is 40 bytes
+	num := int(lump.Length / 40)
+
+	fmt.Println("Texture info count:", num)
+
+	data := make([]TexInfo, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	for i := 0; i < num; i++ {
+		q1Item := q1TexInfo{}
+		if err := binary.Read(reader, binary.LittleEndian, &q1Item); err != nil {
+			return nil, err
+		}
+		if q1Item.MipTex < 0 || int(q1Item.MipTex) >= len(textureNames) {
+			return nil, fmt.Errorf("texture info %v has invalid texture %v", i, q1Item.MipTex)
+		}
+
+		name := textureNames[q1Item.MipTex]
+		newItem := TexInfo{
+			UAxis:       q1Item.UAxis,
+			UOffset:     q1Item.UOffset,
+			VAxis:       q1Item.VAxis,
+			VOffset:     q1Item.VOffset,
+			NextTexInfo: -1,
+		}
+		copy(newItem.TextureName[:], name)
+		if strings.HasPrefix(strings.ToLower(name), "sky") {
+			newItem.Flags = SurfaceSky
+		} else if strings.HasPrefix(name, "*") {
+			newItem.Flags = SurfaceWarp
+		}
+
+		data[i] = newItem
+	}
+
+	return data, nil
+}
+
+// Each luxel is a single brightness value, which is copied to rgb
+// The brightness is halved so the lightmap scale used for Quake 2 maps doesn't overbright the map
+func loadQ1LightmapData(lump Lump, r io.ReaderAt, faces []Face) ([]uint8, error) {
+	grayData, err := loadRawLump(lump, r)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Lightmap data count:", len(grayData))
+
+	data := make([]uint8, len(grayData)*3)
+	for i, value := range grayData {
+		data[i*3+0] = value / 2
+		data[i*3+1] = value / 2
+		data[i*3+2] = value / 2
+	}
+
+	// Faces without a lightmap keep the offset of -1
+	for i := range faces {
+		if int32(faces[i].LightmapOffset) >= 0 {
+			faces[i].LightmapOffset *= 3
+		}
+	}
+	return data, nil
+}
+
+func loadQ1BSPNodes(lump Lump, r io.ReaderAt) ([]BSPNode, error) {
+	// A node is 24 bytes
+	num := int(lump.Length / 24)
+
+	fmt.Println("BSP node count:", num)
+
+	data := make([]BSPNode, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	for i := 0; i < num; i++ {
+		q1Item := q1BSPNode{}
+		if err := binary.Read(reader, binary.LittleEndian, &q1Item); err != nil {
+			return nil, err
+		}
+
+		data[i] = BSPNode{
+			Plane:      uint32(q1Item.Plane),
+			FrontChild: int32(q1Item.Children[0]),
+			BackChild:  int32(q1Item.Children[1]),
+			BBoxMin:    shortsToFloats(q1Item.BBoxMin),
+			BBoxMax:    shortsToFloats(q1Item.BBoxMax),
+			FirstFace:  uint32(q1Item.FirstFace),
+			NumFaces:   uint32(q1Item.NumFaces),
+		}
+	}
+
+	return data, nil
+}
+
+func loadQ1ClipNodes(lump Lump, r io.ReaderAt) ([]ClipNode, error) {
+	// A clip node is 8 bytes
+	num := int(lump.Length / 8)
+
+	fmt.Println("Clip node count:", num)
+
+	data := make([]ClipNode, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	if err := binary.Read(reader, binary.LittleEndian, &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Mark surfaces are the Quake 1 leaf faces, stored as unsigned shorts
+func loadQ1MarkSurfaces(lump Lump, r io.ReaderAt) ([]LeafFace, error) {
+	num := int(lump.Length / 2)
+
+	fmt.Println("Mark surface count:", num)
+
+	markSurfaces := make([]uint16, num)
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	if err := binary.Read(reader, binary.LittleEndian, &markSurfaces); err != nil {
+		return nil, err
+	}
+
+	data := make([]LeafFace, num)
+	for i, face := range markSurfaces {
+		data[i] = LeafFace(face)
+	}
+	return data, nil
+}
+
+// Returns the models and the number of leaves in the world with visibility information
+func loadQ1Models(lump Lump, r io.ReaderAt) ([]Model, int, error) {
+	// A model is 64 bytes
+	num := int(lump.Length / 64)
+
+	fmt.Println("Model count:", num)
+
+	data := make([]Model, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	visLeafs := 0
+	for i := 0; i < num; i++ {
+		q1Item := q1Model{}
+		if err := binary.Read(reader, binary.LittleEndian, &q1Item); err != nil {
+			return nil, 0, err
+		}
+		if i == 0 {
+			visLeafs = int(q1Item.VisLeafs)
+		}
+
+		data[i] = Model{
+			BBoxMin:   q1Item.BBoxMin,
+			BBoxMax:   q1Item.BBoxMax,
+			Origin:    q1Item.Origin,
+			HeadNode:  q1Item.HeadNodes[0],
+			FirstFace: uint32(q1Item.FirstFace),
+			NumFaces:  uint32(q1Item.NumFaces),
+		}
+	}
+
+	return data, visLeafs, nil
+}
+
+// Every world leaf after leaf 0 becomes its own cluster, so the PVS of leaf N is the PVS of cluster N-1
+// Leaves without visibility information see everything, using a row added to the end of the visibility data
+func loadQ1BSPLeaves(
+	lump Lump,
+	r io.ReaderAt,
+	visibilityData []uint8,
+	visLeafs int,
+) ([]BSPLeaf, []uint8, []VisibilityOffset, error) {
+	// A leaf is 28 bytes
+	num := int(lump.Length / 28)
+
+	fmt.Println("BSP leaf count:", num)
+
+	if visLeafs > num-1 {
+		visLeafs = num - 1
+	}
+	if visLeafs < 0 {
+		visLeafs = 0
+	}
+
+	allVisibleOffset := uint32(len(visibilityData))
+	allVisibleRow := make([]uint8, (visLeafs+7)/8)
+	for i := range allVisibleRow {
+		allVisibleRow[i] = 0xff
+	}
+	visibilityData = append(visibilityData, allVisibleRow...)
+
+	data := make([]BSPLeaf, num)
+	visibilityOffsets := make([]VisibilityOffset, visLeafs)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	for i := 0; i < num; i++ {
+		q1Item := q1BSPLeaf{}
+		if err := binary.Read(reader, binary.LittleEndian, &q1Item); err != nil {
+			return nil, nil, nil, err
+		}
+
+		newItem := BSPLeaf{
+			BrushOr:       q1ContentsToFlags(q1Item.Contents),
+			Cluster:       ClusterInvalid,
+			BBoxMin:       shortsToFloats(q1Item.BBoxMin),
+			BBoxMax:       shortsToFloats(q1Item.BBoxMax),
+			FirstLeafFace: uint32(q1Item.FirstMarkSurface),
+			NumLeafFaces:  uint32(q1Item.NumMarkSurfaces),
+		}
+
+		// Leaf 0 is the shared solid leaf and leaves after the world belong to brush models
+		if i >= 1 && i <= visLeafs {
+			cluster := i - 1
+			newItem.Cluster = uint32(cluster)
+			offset := allVisibleOffset
+			if q1Item.VisibilityOffset >= 0 && int(q1Item.VisibilityOffset) < int(allVisibleOffset) {
+				offset = uint32(q1Item.VisibilityOffset)
+			}
+			visibilityOffsets[cluster] = VisibilityOffset{Pvs: offset, Phs: offset}
+		}
+
+		data[i] = newItem
+	}
+
+	return data, visibilityData, visibilityOffsets, nil
+}
+
+func q1ContentsToFlags(contents int32) uint32 {
+	switch contents {
+	case Q1ContentsSolid, Q1ContentsSky:
+		return ContentsSolid
+	case Q1ContentsWater:
+		return ContentsWater
+	case Q1ContentsSlime:
+		return ContentsSlime
+	case Q1ContentsLava:
+		return ContentsLava
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,168 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+)
+
+const (
+	// Quake 1 textures use this palette instead of pics/colormap.pcx
+	Q1PaletteFilename = "gfx/palette.lmp"
+
+	WadLumpTypePalette    = uint8(0x40)
+	WadLumpTypeStatusBar  = uint8(0x42)
+	WadLumpTypeMipTex     = uint8(0x44)
+	WadLumpTypeConsolePic = uint8(0x45)
+)
+
+type WadHeader struct {
+	Magic        [4]byte // magic number ("WAD2")
+	NumLumps     uint32
+	InfoTableOfs uint32 // offset of the lump directory
+}
+
+type WadLump struct {
+	FilePos     uint32
+	DiskSize    uint32
+	Size        uint32 // uncompressed size
+	Type        uint8
+	Compression uint8
+	Pad         uint16
+	Name        [16]byte
+}
+
+// Texture stored in a WAD2 file or embedded in a Quake 1 map
+// The offsets are relative to the start of the texture
+type MipTexHeader struct {
+	Name   [16]byte
+	Width  uint32
+	Height uint32
+	Offset [4]uint32
+}
+
+// Palette indices for every mip level, starting with the full size image
+type MipTexture struct {
+	Name   string
+	Width  uint32
+	Height uint32
+	Pixels [][]uint8
+}
+
+// Quake 1 texture archive
+type Wad struct {
+	Lumps  map[string]WadLump // lowercase lump names
+	reader io.ReaderAt
+}
+
+func LoadQ1WAD(r io.ReaderAt) (*Wad, error) {
+	header := WadHeader{}
+	headerReader := io.NewSectionReader(r, 0, int64(unsafe.Sizeof(header)))
+	if err := binary.Read(headerReader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	var magic = []byte("WAD2")
+	if !bytes.Equal(magic, header.Magic[:]) {
+		return nil, fmt.Errorf("WAD Header: Wrong magic %v", header.Magic)
+	}
+
+	// Each lump entry is 32 bytes
+	wad := &Wad{
+		Lumps:  make(map[string]WadLump),
+		reader: r,
+	}
+	lumpReader := io.NewSectionReader(r, int64(header.InfoTableOfs), int64(header.NumLumps)*32)
+	for i := 0; i < int(header.NumLumps); i++ {
+		lump := WadLump{}
+		if err := binary.Read(lumpReader, binary.LittleEndian, &lump); err != nil {
+			return nil, fmt.Errorf("WAD lump %v: %v", i, err)
+		}
+		wad.Lumps[strings.ToLower(byteToString(lump.Name[:]))] = lump
+	}
+	return wad, nil
+}
+
+// Texture names are case insensitive
+func (wad *Wad) LoadMipTexture(name string) (*MipTexture, error) {
+	lump, exists := wad.Lumps[strings.ToLower(name)]
+	if !exists {
+		return nil, fmt.Errorf("Texture %v doesn't exist in WAD", name)
+	}
+	if lump.Type != WadLumpTypeMipTex {
+		return nil, fmt.Errorf("WAD lump %v has type %v, not a texture", name, lump.Type)
+	}
+	if lump.Compression != 0 {
+		return nil, fmt.Errorf("WAD lump %v is compressed", name)
+	}
+	return LoadMipTexture(io.NewSectionReader(wad.reader, int64(lump.FilePos), int64(lump.DiskSize)))
+}
+
+// Load a texture from the start of the reader, which is a WAD lump or a texture in a map
+// Every mip level has to be inside the reader
+func LoadMipTexture(r *io.SectionReader) (*MipTexture, error) {
+	header := MipTexHeader{}
+	headerReader := io.NewSectionReader(r, 0, int64(unsafe.Sizeof(header)))
+	if err := binary.Read(headerReader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	texture := &MipTexture{
+		Name:   byteToString(header.Name[:]),
+		Width:  header.Width,
+		Height: header.Height,
+		Pixels: make([][]uint8, WalMipLevels),
+	}
+	if texture.Width == 0 || texture.Height == 0 || texture.Width%16 != 0 || texture.Height%16 != 0 {
+		return nil, fmt.Errorf("Texture %v has invalid size %vx%v", texture.Name, texture.Width, texture.Height)
+	}
+
+	// Each pixel is stored as a palette index
+	for level := 0; level < WalMipLevels; level++ {
+		width, height := texture.MipSize(level)
+		size := int64(width) * int64(height)
+		if int64(header.Offset[level])+size > r.Size() {
+			return nil, fmt.Errorf("Texture %v: mip level %v is outside of the texture data", texture.Name, level)
+		}
+		pixels := make([]uint8, size)
+		if _, err := r.ReadAt(pixels, int64(header.Offset[level])); err != nil {
+			return nil, fmt.Errorf("Texture %v: failed to load mip level %v", texture.Name, level)
+		}
+		texture.Pixels[level] = pixels
+	}
+	return texture, nil
+}
+
+// Each mip level is half the width and height of the previous level
+func (texture *MipTexture) MipSize(level int) (uint32, uint32) {
+	return texture.Width >> uint32(level), texture.Height >> uint32(level)
+}
+
+// Convert each palette index to rgb for every mip level
+func (texture *MipTexture) ToRGB(palette Palette) [][]uint8 {
+	mipLevels := make([][]uint8, len(texture.Pixels))
+	for level, paletteIndices := range texture.Pixels {
+		newImage := make([]uint8, len(paletteIndices)*3)
+		for i, paletteIndex := range paletteIndices {
+			rgbColor := palette[paletteIndex]
+			newImage[i*3+0] = rgbColor[0]
+			newImage[i*3+1] = rgbColor[1]
+			newImage[i*3+2] = rgbColor[2]
+		}
+		mipLevels[level] = newImage
+	}
+	return mipLevels
+}
+
+// The palette lump is 256 rgb colors without a header
+func LoadPaletteFromLMP(r io.ReaderAt) (Palette, error) {
+	palette := Palette{}
+	reader := io.NewSectionReader(r, 0, int64(unsafe.Sizeof(palette)))
+	if err := binary.Read(reader, binary.LittleEndian, &palette); err != nil {
+		return Palette{}, err
+	}
+	return palette, nil
+}
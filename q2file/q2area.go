@@ -0,0 +1,120 @@
+package q2file
+
+import (
+	"fmt"
+)
+
+// Tracks which area portals are open and which areas can see each other
+// Area 0 is never used by leaves in the world
+type AreaConnectivity struct {
+	mapData    *MapData
+	portalOpen []bool
+	floodNums  []int // areas with the same flood number are connected
+}
+
+// All portals start closed, the same as when a map is loaded in game
+func NewAreaConnectivity(mapData *MapData) *AreaConnectivity {
+	numPortals := 0
+	for _, portal := range mapData.AreaPortals {
+		if int(portal.PortalNum) >= numPortals {
+			numPortals = int(portal.PortalNum) + 1
+		}
+	}
+
+	areaConnectivity := &AreaConnectivity{
+		mapData:    mapData,
+		portalOpen: make([]bool, numPortals),
+		floodNums:  make([]int, len(mapData.Areas)),
+	}
+	areaConnectivity.floodAreaConnections()
+	return areaConnectivity
+}
+
+func (areaConnectivity *AreaConnectivity) NumPortals() int {
+	return len(areaConnectivity.portalOpen)
+}
+
+// Open or close a portal, which is the "style" key of a func_areaportal entity
+func (areaConnectivity *AreaConnectivity) SetPortalState(portalNum int, open bool) error {
+	if portalNum < 0 || portalNum >= len(areaConnectivity.portalOpen) {
+		return fmt.Errorf("Area portal number %v is out of range", portalNum)
+	}
+
+	if areaConnectivity.portalOpen[portalNum] == open {
+		return nil
+	}
+	areaConnectivity.portalOpen[portalNum] = open
+	areaConnectivity.floodAreaConnections()
+	return nil
+}
+
+func (areaConnectivity *AreaConnectivity) SetAllPortalStates(open bool) {
+	for i := range areaConnectivity.portalOpen {
+		areaConnectivity.portalOpen[i] = open
+	}
+	areaConnectivity.floodAreaConnections()
+}
+
+func (areaConnectivity *AreaConnectivity) IsPortalOpen(portalNum int) bool {
+	if portalNum < 0 || portalNum >= len(areaConnectivity.portalOpen) {
+		return false
+	}
+	return areaConnectivity.portalOpen[portalNum]
+}
+
+// Check if there is a path of open portals between two areas
+func (areaConnectivity *AreaConnectivity) AreasConnected(area1 int, area2 int) bool {
+	// Maps without areas are always fully connected
+	if len(areaConnectivity.floodNums) <= 1 {
+		return true
+	}
+
+	numAreas := len(areaConnectivity.floodNums)
+	if area1 <= 0 || area2 <= 0 || area1 >= numAreas || area2 >= numAreas {
+		return false
+	}
+	return areaConnectivity.floodNums[area1] == areaConnectivity.floodNums[area2]
+}
+
+// Give every group of connected areas its own flood number
+func (areaConnectivity *AreaConnectivity) floodAreaConnections() {
+	for i := range areaConnectivity.floodNums {
+		areaConnectivity.floodNums[i] = 0
+	}
+
+	floodNum := 0
+	for areaIndex := 1; areaIndex < len(areaConnectivity.floodNums); areaIndex++ {
+		// Already reached from another area
+		if areaConnectivity.floodNums[areaIndex] != 0 {
+			continue
+		}
+		floodNum++
+		areaConnectivity.floodArea(areaIndex, floodNum)
+	}
+}
+
+func (areaConnectivity *AreaConnectivity) floodArea(startArea int, floodNum int) {
+	mapData := areaConnectivity.mapData
+
+	// Use a stack instead of recursion so large maps can't overflow
+	stack := []int{startArea}
+	areaConnectivity.floodNums[startArea] = floodNum
+	for len(stack) > 0 {
+		areaIndex := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		area := mapData.Areas[areaIndex]
+		for i := 0; i < int(area.NumAreaPortals); i++ {
+			portal := mapData.AreaPortals[int(area.FirstAreaPortal)+i]
+			if !areaConnectivity.portalOpen[portal.PortalNum] {
+				continue
+			}
+
+			otherArea := int(portal.OtherArea)
+			if areaConnectivity.floodNums[otherArea] == 0 {
+				areaConnectivity.floodNums[otherArea] = floodNum
+				stack = append(stack, otherArea)
+			}
+		}
+	}
+}
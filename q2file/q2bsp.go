@@ -5,25 +5,66 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sort"
 	"unsafe"
 )
 
 const (
-	LumpPlanes     = 1
-	LumpVertices   = 2
-	LumpVisibility = 3
-	LumpBSPNodes   = 4
-	LumpTexInfos   = 5
-	LumpFaces      = 6
-	LumpLightmaps  = 7
-	LumpBSPLeaves  = 8
-	LumpLeafFaces  = 9
-	LumpEdges      = 11
-	LumpFaceEdges  = 12
+	LumpEntities    = 0
+	LumpPlanes      = 1
+	LumpVertices    = 2
+	LumpVisibility  = 3
+	LumpBSPNodes    = 4
+	LumpTexInfos    = 5
+	LumpFaces       = 6
+	LumpLightmaps   = 7
+	LumpBSPLeaves   = 8
+	LumpLeafFaces   = 9
+	LumpLeafBrushes = 10
+	LumpEdges       = 11
+	LumpFaceEdges   = 12
+	LumpModels      = 13
+	LumpBrushes     = 14
+	LumpBrushSides  = 15
+	LumpPop         = 16
+	LumpAreas       = 17
+	LumpAreaPortals = 18
+)
+
+// Contents flags for brushes and leaves
+const (
+	ContentsSolid       = uint32(1)
+	ContentsWindow      = uint32(2)
+	ContentsAux         = uint32(4)
+	ContentsLava        = uint32(8)
+	ContentsSlime       = uint32(16)
+	ContentsWater       = uint32(32)
+	ContentsMist        = uint32(64)
+	ContentsAreaPortal  = uint32(0x8000)
+	ContentsPlayerClip  = uint32(0x10000)
+	ContentsMonsterClip = uint32(0x20000)
+	ContentsOrigin      = uint32(0x1000000)
+	ContentsMonster     = uint32(0x2000000)
+	ContentsDeadMonster = uint32(0x4000000)
+	ContentsDetail      = uint32(0x8000000)
+	ContentsTranslucent = uint32(0x10000000)
+	ContentsLadder      = uint32(0x20000000)
+)
+
+// Surface flags for texture info
+const (
+	SurfaceLight   = uint32(0x1)
+	SurfaceSlick   = uint32(0x2)
+	SurfaceSky     = uint32(0x4)
+	SurfaceWarp    = uint32(0x8)
+	SurfaceTrans33 = uint32(0x10)
+	SurfaceTrans66 = uint32(0x20)
+	SurfaceFlowing = uint32(0x40)
+	SurfaceNoDraw  = uint32(0x80)
 )
 
 type Header struct {
-	Magic   [4]byte  // magic number ("IBSP")
+	Magic   [4]byte  // magic number ("IBSP", or "QBSP" for 32-bit indices)
 	Version uint32   // version of the BSP format (38)
 	Lumps   [19]Lump // directory of the lumps
 }
@@ -41,18 +82,18 @@ type Vertex struct {
 
 // Each edge is stored as a pair of indices into the vertex array
 type Edge struct {
-	V1 uint16
-	V2 uint16
+	V1 uint32
+	V2 uint32
 }
 
 type Face struct {
-	Plane     uint16 // index of the plane the face is parallel to
-	PlaneSide uint16 // set if the normal is parallel to the plane normal
+	Plane     uint32 // index of the plane the face is parallel to
+	PlaneSide uint32 // set if the normal is parallel to the plane normal
 
 	FirstEdge uint32 // index of the first edge (in the face edge array)
-	NumEdges  uint16 // number of consecutive edges (in the face edge array)
+	NumEdges  uint32 // number of consecutive edges (in the face edge array)
 
-	TextureInfo uint16 // index of the texture info structure
+	TextureInfo uint32 // index of the texture info structure
 
 	LightmapSyles  [4]uint8 // styles (bit flags) for the lightmaps
 	LightmapOffset uint32   // offset of the lightmap (in bytes) in the lightmap lump
@@ -79,11 +120,11 @@ type BSPNode struct {
 	FrontChild int32 // index of the front child node or leaf
 	BackChild  int32 // index of the back child node or leaf
 
-	BBoxMin [3]int16 // minimum x, y and z of the bounding box
-	BBoxMax [3]int16 // maximum x, y and z of the bounding box
+	BBoxMin [3]float32 // minimum x, y and z of the bounding box
+	BBoxMax [3]float32 // maximum x, y and z of the bounding box
 
-	FirstFace uint16 // index of the first face (in the face array)
-	NumFaces  uint16 // number of consecutive edges (in the face array)
+	FirstFace uint32 // index of the first face (in the face array)
+	NumFaces  uint32 // number of consecutive edges (in the face array)
 }
 
 type Plane struct {
@@ -95,27 +136,69 @@ type Plane struct {
 type BSPLeaf struct {
 	BrushOr uint32
 
-	Cluster uint16 // -1 for cluster indicates no visibility information
-	Area    uint16
+	Cluster uint32 // ClusterInvalid indicates no visibility information
+	Area    uint32
 
-	BBoxMin [3]int16 // bounding box minimums
-	BBoxMax [3]int16 // bounding box maximums
+	BBoxMin [3]float32 // bounding box minimums
+	BBoxMax [3]float32 // bounding box maximums
 
-	FirstLeafFace uint16 // index of the first face (in the face leaf array)
-	NumLeafFaces  uint16 // number of consecutive edges (in the face leaf array)
+	FirstLeafFace uint32 // index of the first face (in the face leaf array)
+	NumLeafFaces  uint32 // number of consecutive edges (in the face leaf array)
 
-	FirstLeafBrush uint16
-	NumLeafBrushes uint16
+	FirstLeafBrush uint32
+	NumLeafBrushes uint32
 }
 
-type LeafFace int16
+// Cluster of leaves outside the map, stored as -1 in the file
+const ClusterInvalid = uint32(0xFFFFFFFF)
+
+type LeafFace uint32
 
 type VisibilityOffset struct {
 	Pvs uint32 // visibility set offset
 	Phs uint32 // hearability set offset
 }
 
+// Model 0 is the world, models 1..N are brush entities referenced as "*N"
+type Model struct {
+	BBoxMin [3]float32 // bounding box minimums
+	BBoxMax [3]float32 // bounding box maximums
+	Origin  [3]float32 // origin used for rotating models
+
+	HeadNode  int32  // index of the root node (in the node array)
+	FirstFace uint32 // index of the first face (in the face array)
+	NumFaces  uint32 // number of consecutive faces (in the face array)
+}
+
+// Convex volume bounded by the planes of its sides
+type Brush struct {
+	FirstSide int32  // index of the first side (in the brush side array)
+	NumSides  int32  // number of consecutive sides (in the brush side array)
+	Contents  uint32 // contents flags (solid, water, etc.)
+}
+
+type BrushSide struct {
+	Plane   uint32 // index of the plane facing out of the brush
+	TexInfo int32  // index of the texture info structure, -1 if there is none
+}
+
+type LeafBrush uint32
+
+// Areas are regions of the map separated by area portals (usually doors)
+type Area struct {
+	NumAreaPortals  int32 // number of consecutive portals (in the area portal array)
+	FirstAreaPortal int32 // index of the first portal (in the area portal array)
+}
+
+type AreaPortal struct {
+	PortalNum int32 // portal number that is opened or closed by func_areaportal
+	OtherArea int32 // index of the area on the other side of the portal
+}
+
 type MapData struct {
+	Version           uint32 // 38 for Quake 2, 29 for Quake 1
+	IsQBSP            bool   // the file uses 32-bit indices
+	Entities          []Entity
 	Vertices          []Vertex
 	Edges             []Edge
 	Faces             []Face
@@ -129,6 +212,54 @@ type MapData struct {
 	LeafFaces         []LeafFace
 	VisibilityData    []uint8
 	VisibilityOffsets []VisibilityOffset
+	Models            []Model
+	Brushes           []Brush
+	BrushSides        []BrushSide
+	LeafBrushes       []LeafBrush
+	Areas             []Area
+	AreaPortals       []AreaPortal
+	PopData           []uint8
+
+	// Quake 1 maps embed their textures, keyed by texture name
+	// Textures that are only in a WAD file are missing
+	MipTextures map[string]*MipTexture
+	// Quake 1 collision hulls, which replace brushes
+	ClipNodes []ClipNode
+
+	// Extension lumps stored after the regular lumps, keyed by name
+	BSPXLumps map[string][]uint8
+	// Per face lightmap size and projection from the DECOUPLED_LM lump, nil if there is none
+	DecoupledLightmaps []DecoupledLightmap
+	// Light sampled in the empty space of the map from the LIGHTGRID_OCTREE lump, nil if there is none
+	LightGrid *LightGridOctree
+
+	// Kept so the writer can reproduce the original file
+	entityLump []uint8
+	lumpOrder  []int
+}
+
+// Load a Quake 2 or Quake 1 map, depending on the version in the header
+func LoadBSP(r io.ReaderAt) (*MapData, error) {
+	version, err := GetBSPVersion(r)
+	if err != nil {
+		return nil, err
+	}
+	if version == Q1BSPVersion {
+		return LoadQ1BSP(r)
+	}
+	return LoadQ2BSP(r)
+}
+
+// Quake 1 maps start with the version, the other formats start with a magic number followed by the version
+func GetBSPVersion(r io.ReaderAt) (uint32, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) == Q1BSPVersion {
+		return Q1BSPVersion, nil
+	}
+	return binary.LittleEndian.Uint32(header[4:8]), nil
 }
 
 // Read header to verify the file is valid
@@ -143,8 +274,8 @@ func LoadQ2BSP(r io.ReaderAt) (*MapData, error) {
 	}
 
 	// Verify format
-	var magic = []byte("IBSP")
-	if !bytes.Equal(magic, header.Magic[:]) {
+	isQBSP := bytes.Equal([]byte("QBSP"), header.Magic[:])
+	if !isQBSP && !bytes.Equal([]byte("IBSP"), header.Magic[:]) {
 		return nil, fmt.Errorf("BSP Header: Wrong magic %v", header.Magic)
 	}
 
@@ -155,15 +286,19 @@ func LoadQ2BSP(r io.ReaderAt) (*MapData, error) {
 	// Load map data
 	fmt.Println("Header total lumps:", len(header.Lumps))
 
+	entities, err := loadEntities(header.Lumps[LumpEntities], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load entities: %v", err)
+	}
 	vertices, err := loadVertices(header.Lumps[LumpVertices], r)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load vertices")
 	}
-	edges, err := loadEdges(header.Lumps[LumpEdges], r)
+	edges, err := loadEdges(header.Lumps[LumpEdges], r, isQBSP)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load edges")
 	}
-	faces, err := loadFaces(header.Lumps[LumpFaces], r)
+	faces, err := loadFaces(header.Lumps[LumpFaces], r, isQBSP)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load faces")
 	}
@@ -183,7 +318,7 @@ func LoadQ2BSP(r io.ReaderAt) (*MapData, error) {
 		return nil, fmt.Errorf("Failed to load lightmap data")
 	}
 
-	bspNodes, err := loadBSPNodes(header.Lumps[LumpBSPNodes], r)
+	bspNodes, err := loadBSPNodes(header.Lumps[LumpBSPNodes], r, isQBSP)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load BSP nodes")
 	}
@@ -191,11 +326,11 @@ func LoadQ2BSP(r io.ReaderAt) (*MapData, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load BSP planes")
 	}
-	bspLeaves, err := loadBSPLeaves(header.Lumps[LumpBSPLeaves], r)
+	bspLeaves, err := loadBSPLeaves(header.Lumps[LumpBSPLeaves], r, isQBSP)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load BSP leaves")
 	}
-	leafFaces, err := loadLeafFaces(header.Lumps[LumpLeafFaces], r)
+	leafFaces, err := loadLeafFaces(header.Lumps[LumpLeafFaces], r, isQBSP)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load leaf faces")
 	}
@@ -207,22 +342,103 @@ func LoadQ2BSP(r io.ReaderAt) (*MapData, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load visibility offsets")
 	}
+	models, err := loadModels(header.Lumps[LumpModels], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load models")
+	}
+	brushes, err := loadBrushes(header.Lumps[LumpBrushes], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load brushes")
+	}
+	brushSides, err := loadBrushSides(header.Lumps[LumpBrushSides], r, isQBSP)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load brush sides")
+	}
+	leafBrushes, err := loadLeafBrushes(header.Lumps[LumpLeafBrushes], r, isQBSP)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load leaf brushes")
+	}
+	if err := validateBrushes(brushes, brushSides, leafBrushes, bspLeaves, planes, texInfos); err != nil {
+		return nil, err
+	}
+
+	areas, err := loadAreas(header.Lumps[LumpAreas], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load areas")
+	}
+	areaPortals, err := loadAreaPortals(header.Lumps[LumpAreaPortals], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load area portals")
+	}
+	if err := validateAreas(areas, areaPortals); err != nil {
+		return nil, err
+	}
+
+	popData, err := loadRawLump(header.Lumps[LumpPop], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load pop data")
+	}
+	entityLump, err := loadRawLump(header.Lumps[LumpEntities], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load entities")
+	}
+
+	for i, model := range models {
+		if int(model.FirstFace)+int(model.NumFaces) > len(faces) {
+			return nil, fmt.Errorf("Model %v has invalid face range %v-%v", i, model.FirstFace, model.FirstFace+model.NumFaces)
+		}
+	}
+
+	// Extension lumps from newer compilers
+	bspxLumps, err := loadBSPXLumps(header.Lumps[:], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load BSPX lumps: %v", err)
+	}
+	var decoupledLightmaps []DecoupledLightmap
+	if data, exists := bspxLumps[BSPXLumpDecoupledLightmap]; exists {
+		decoupledLightmaps, err = parseDecoupledLightmaps(data, len(faces))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load decoupled lightmaps: %v", err)
+		}
+	}
+	var lightGrid *LightGridOctree
+	if data, exists := bspxLumps[BSPXLumpLightGridOctree]; exists {
+		lightGrid, err = parseLightGridOctree(data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load light grid: %v", err)
+		}
+	}
 
 	// Combine into map data
 	mapData := &MapData{
-		Vertices:          vertices,
-		Edges:             edges,
-		Faces:             faces,
-		FaceEdges:         faceEdges,
-		TexInfos:          texInfos,
-		TextureIds:        textureIds,
-		LightmapData:      lightmapData,
-		Nodes:             bspNodes,
-		Planes:            planes,
-		BSPLeaves:         bspLeaves,
-		LeafFaces:         leafFaces,
-		VisibilityData:    visibilityData,
-		VisibilityOffsets: visibilityOffsets,
+		Version:            header.Version,
+		IsQBSP:             isQBSP,
+		Entities:           entities,
+		Vertices:           vertices,
+		Edges:              edges,
+		Faces:              faces,
+		FaceEdges:          faceEdges,
+		TexInfos:           texInfos,
+		TextureIds:         textureIds,
+		LightmapData:       lightmapData,
+		Nodes:              bspNodes,
+		Planes:             planes,
+		BSPLeaves:          bspLeaves,
+		LeafFaces:          leafFaces,
+		VisibilityData:     visibilityData,
+		VisibilityOffsets:  visibilityOffsets,
+		Models:             models,
+		Brushes:            brushes,
+		BrushSides:         brushSides,
+		LeafBrushes:        leafBrushes,
+		Areas:              areas,
+		AreaPortals:        areaPortals,
+		PopData:            popData,
+		BSPXLumps:          bspxLumps,
+		DecoupledLightmaps: decoupledLightmaps,
+		LightGrid:          lightGrid,
+		entityLump:         entityLump,
+		lumpOrder:          getLumpOrder(header),
 	}
 
 	return mapData, nil
@@ -254,10 +470,13 @@ func loadVertices(lump Lump, r io.ReaderAt) ([]Vertex, error) {
 }
 
 // Load all edges
-func loadEdges(lump Lump, r io.ReaderAt) ([]Edge, error) {
+func loadEdges(lump Lump, r io.ReaderAt, isQBSP bool) ([]Edge, error) {
 	// Each edge is 2 unsigned shorts, one for each vertex
-	// 4 bytes per edge
+	// 4 bytes per edge, or 8 bytes in QBSP
 	numEdges := int(lump.Length / 4)
+	if isQBSP {
+		numEdges = int(lump.Length / 8)
+	}
 
 	fmt.Println("Edge count:", numEdges)
 
@@ -266,10 +485,14 @@ func loadEdges(lump Lump, r io.ReaderAt) ([]Edge, error) {
 	// Read each edge
 	edgeReader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
 	for i := 0; i < numEdges; i++ {
-		edge := Edge{}
-		if err := binary.Read(edgeReader, binary.LittleEndian, &edge); err != nil {
+		ibspEdge, qbspEdge := edgeIBSP{}, edgeQBSP{}
+		if err := readLumpItem(edgeReader, isQBSP, &ibspEdge, &qbspEdge); err != nil {
 			return nil, err
 		}
+		edge := ibspEdge.toEdge()
+		if isQBSP {
+			edge = qbspEdge.toEdge()
+		}
 		// Add to array
 		edgeData = append(edgeData, edge)
 	}
@@ -277,9 +500,12 @@ func loadEdges(lump Lump, r io.ReaderAt) ([]Edge, error) {
 	return edgeData, nil
 }
 
-func loadFaces(lump Lump, r io.ReaderAt) ([]Face, error) {
-	// A face is 20 bytes
+func loadFaces(lump Lump, r io.ReaderAt, isQBSP bool) ([]Face, error) {
+	// A face is 20 bytes, or 28 bytes in QBSP
 	numFaces := int(lump.Length / 20)
+	if isQBSP {
+		numFaces = int(lump.Length / 28)
+	}
 
 	fmt.Println("Face count:", numFaces)
 
@@ -288,10 +514,14 @@ func loadFaces(lump Lump, r io.ReaderAt) ([]Face, error) {
 	// Read each face
 	faceReader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
 	for i := 0; i < numFaces; i++ {
-		face := Face{}
-		if err := binary.Read(faceReader, binary.LittleEndian, &face); err != nil {
+		ibspFace, qbspFace := faceIBSP{}, faceQBSP{}
+		if err := readLumpItem(faceReader, isQBSP, &ibspFace, &qbspFace); err != nil {
 			return nil, err
 		}
+		face := ibspFace.toFace()
+		if isQBSP {
+			face = qbspFace.toFace()
+		}
 		// Add to array
 		faceData = append(faceData, face)
 	}
@@ -363,9 +593,12 @@ func loadLightmapData(lump Lump, r io.ReaderAt) ([]uint8, error) {
 	return data, nil
 }
 
-func loadBSPNodes(lump Lump, r io.ReaderAt) ([]BSPNode, error) {
-	// A BSP node is 28 bytes
+func loadBSPNodes(lump Lump, r io.ReaderAt, isQBSP bool) ([]BSPNode, error) {
+	// A BSP node is 28 bytes, or 44 bytes in QBSP
 	num := int(lump.Length / 28)
+	if isQBSP {
+		num = int(lump.Length / 44)
+	}
 
 	fmt.Println("BSP Node count:", num)
 
@@ -373,13 +606,16 @@ func loadBSPNodes(lump Lump, r io.ReaderAt) ([]BSPNode, error) {
 
 	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
 	for i := 0; i < num; i++ {
-		newItem := BSPNode{}
-		if err := binary.Read(reader, binary.LittleEndian, &newItem); err != nil {
+		ibspNode, qbspNode := nodeIBSP{}, nodeQBSP{}
+		if err := readLumpItem(reader, isQBSP, &ibspNode, &qbspNode); err != nil {
 			return nil, err
 		}
 
 		// Add to array
-		data[i] = newItem
+		data[i] = ibspNode.toNode()
+		if isQBSP {
+			data[i] = qbspNode.toNode()
+		}
 	}
 
 	return data, nil
@@ -406,9 +642,12 @@ func loadPlanes(lump Lump, r io.ReaderAt) ([]Plane, error) {
 	return data, nil
 }
 
-func loadBSPLeaves(lump Lump, r io.ReaderAt) ([]BSPLeaf, error) {
-	// A BSP leaf is 28 bytes
+func loadBSPLeaves(lump Lump, r io.ReaderAt, isQBSP bool) ([]BSPLeaf, error) {
+	// A BSP leaf is 28 bytes, or 52 bytes in QBSP
 	num := int(lump.Length / 28)
+	if isQBSP {
+		num = int(lump.Length / 52)
+	}
 
 	fmt.Println("BSP Leaf count:", num)
 
@@ -416,21 +655,27 @@ func loadBSPLeaves(lump Lump, r io.ReaderAt) ([]BSPLeaf, error) {
 
 	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
 	for i := 0; i < num; i++ {
-		newItem := BSPLeaf{}
-		if err := binary.Read(reader, binary.LittleEndian, &newItem); err != nil {
+		ibspLeaf, qbspLeaf := leafIBSP{}, leafQBSP{}
+		if err := readLumpItem(reader, isQBSP, &ibspLeaf, &qbspLeaf); err != nil {
 			return nil, err
 		}
 
 		// Add to array
-		data[i] = newItem
+		data[i] = ibspLeaf.toLeaf()
+		if isQBSP {
+			data[i] = qbspLeaf.toLeaf()
+		}
 	}
 
 	return data, nil
 }
 
-func loadLeafFaces(lump Lump, r io.ReaderAt) ([]LeafFace, error) {
-	// A leaf face is 2 bytes
+func loadLeafFaces(lump Lump, r io.ReaderAt, isQBSP bool) ([]LeafFace, error) {
+	// A leaf face is 2 bytes, or 4 bytes in QBSP
 	num := int(lump.Length / 2)
+	if isQBSP {
+		num = int(lump.Length / 4)
+	}
 
 	fmt.Println("Leaf face count:", num)
 
@@ -438,13 +683,16 @@ func loadLeafFaces(lump Lump, r io.ReaderAt) ([]LeafFace, error) {
 
 	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
 	for i := 0; i < num; i++ {
-		newItem := LeafFace(0)
-		if err := binary.Read(reader, binary.LittleEndian, &newItem); err != nil {
+		ibspItem, qbspItem := uint16(0), uint32(0)
+		if err := readLumpItem(reader, isQBSP, &ibspItem, &qbspItem); err != nil {
 			return nil, err
 		}
 
 		// Add to array
-		data[i] = newItem
+		data[i] = LeafFace(ibspItem)
+		if isQBSP {
+			data[i] = LeafFace(qbspItem)
+		}
 	}
 
 	return data, nil
@@ -498,6 +746,237 @@ func loadVisibilityOffsets(lump Lump, r io.ReaderAt) ([]VisibilityOffset, error)
 	return data, nil
 }
 
+func loadModels(lump Lump, r io.ReaderAt) ([]Model, error) {
+	// A model is 48 bytes
+	num := int(lump.Length / 48)
+
+	fmt.Println("Model count:", num)
+
+	data := make([]Model, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	for i := 0; i < num; i++ {
+		newItem := Model{}
+		if err := binary.Read(reader, binary.LittleEndian, &newItem); err != nil {
+			return nil, err
+		}
+
+		// Add to array
+		data[i] = newItem
+	}
+
+	return data, nil
+}
+
+func loadBrushes(lump Lump, r io.ReaderAt) ([]Brush, error) {
+	// A brush is 12 bytes
+	num := int(lump.Length / 12)
+
+	fmt.Println("Brush count:", num)
+
+	data := make([]Brush, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	for i := 0; i < num; i++ {
+		newItem := Brush{}
+		if err := binary.Read(reader, binary.LittleEndian, &newItem); err != nil {
+			return nil, err
+		}
+
+		// Add to array
+		data[i] = newItem
+	}
+
+	return data, nil
+}
+
+func loadBrushSides(lump Lump, r io.ReaderAt, isQBSP bool) ([]BrushSide, error) {
+	// A brush side is 4 bytes, or 8 bytes in QBSP
+	num := int(lump.Length / 4)
+	if isQBSP {
+		num = int(lump.Length / 8)
+	}
+
+	fmt.Println("Brush side count:", num)
+
+	data := make([]BrushSide, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	for i := 0; i < num; i++ {
+		ibspSide, qbspSide := brushSideIBSP{}, brushSideQBSP{}
+		if err := readLumpItem(reader, isQBSP, &ibspSide, &qbspSide); err != nil {
+			return nil, err
+		}
+
+		// Add to array
+		data[i] = ibspSide.toBrushSide()
+		if isQBSP {
+			data[i] = qbspSide.toBrushSide()
+		}
+	}
+
+	return data, nil
+}
+
+func loadLeafBrushes(lump Lump, r io.ReaderAt, isQBSP bool) ([]LeafBrush, error) {
+	// A leaf brush is 2 bytes, or 4 bytes in QBSP
+	num := int(lump.Length / 2)
+	if isQBSP {
+		num = int(lump.Length / 4)
+	}
+
+	fmt.Println("Leaf brush count:", num)
+
+	data := make([]LeafBrush, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	for i := 0; i < num; i++ {
+		ibspItem, qbspItem := uint16(0), uint32(0)
+		if err := readLumpItem(reader, isQBSP, &ibspItem, &qbspItem); err != nil {
+			return nil, err
+		}
+
+		// Add to array
+		data[i] = LeafBrush(ibspItem)
+		if isQBSP {
+			data[i] = LeafBrush(qbspItem)
+		}
+	}
+
+	return data, nil
+}
+
+// Read an item with the layout used by the file
+func readLumpItem(reader io.Reader, isQBSP bool, ibspItem interface{}, qbspItem interface{}) error {
+	if isQBSP {
+		return binary.Read(reader, binary.LittleEndian, qbspItem)
+	}
+	return binary.Read(reader, binary.LittleEndian, ibspItem)
+}
+
+// Make sure every brush index points to valid data before it is used for collision
+func validateBrushes(
+	brushes []Brush,
+	brushSides []BrushSide,
+	leafBrushes []LeafBrush,
+	bspLeaves []BSPLeaf,
+	planes []Plane,
+	texInfos []TexInfo,
+) error {
+	for i, brush := range brushes {
+		if brush.FirstSide < 0 || brush.NumSides < 0 || int(brush.FirstSide)+int(brush.NumSides) > len(brushSides) {
+			return fmt.Errorf("Brush %v has invalid side range %v-%v", i, brush.FirstSide, brush.FirstSide+brush.NumSides)
+		}
+	}
+	for i, side := range brushSides {
+		if int(side.Plane) >= len(planes) {
+			return fmt.Errorf("Brush side %v has invalid plane %v", i, side.Plane)
+		}
+		if side.TexInfo < -1 || int(side.TexInfo) >= len(texInfos) {
+			return fmt.Errorf("Brush side %v has invalid texture info %v", i, side.TexInfo)
+		}
+	}
+	for i, leafBrush := range leafBrushes {
+		if int(leafBrush) >= len(brushes) {
+			return fmt.Errorf("Leaf brush %v has invalid brush %v", i, leafBrush)
+		}
+	}
+	for i, leaf := range bspLeaves {
+		if int(leaf.FirstLeafBrush)+int(leaf.NumLeafBrushes) > len(leafBrushes) {
+			return fmt.Errorf("BSP leaf %v has invalid leaf brush range %v-%v", i, leaf.FirstLeafBrush, leaf.FirstLeafBrush+leaf.NumLeafBrushes)
+		}
+	}
+	return nil
+}
+
+func loadAreas(lump Lump, r io.ReaderAt) ([]Area, error) {
+	// An area is 8 bytes
+	num := int(lump.Length / 8)
+
+	fmt.Println("Area count:", num)
+
+	data := make([]Area, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	for i := 0; i < num; i++ {
+		newItem := Area{}
+		if err := binary.Read(reader, binary.LittleEndian, &newItem); err != nil {
+			return nil, err
+		}
+
+		// Add to array
+		data[i] = newItem
+	}
+
+	return data, nil
+}
+
+func loadAreaPortals(lump Lump, r io.ReaderAt) ([]AreaPortal, error) {
+	// An area portal is 8 bytes
+	num := int(lump.Length / 8)
+
+	fmt.Println("Area portal count:", num)
+
+	data := make([]AreaPortal, num)
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	for i := 0; i < num; i++ {
+		newItem := AreaPortal{}
+		if err := binary.Read(reader, binary.LittleEndian, &newItem); err != nil {
+			return nil, err
+		}
+
+		// Add to array
+		data[i] = newItem
+	}
+
+	return data, nil
+}
+
+func validateAreas(areas []Area, areaPortals []AreaPortal) error {
+	for i, area := range areas {
+		if area.FirstAreaPortal < 0 || area.NumAreaPortals < 0 || int(area.FirstAreaPortal)+int(area.NumAreaPortals) > len(areaPortals) {
+			return fmt.Errorf("Area %v has invalid portal range %v-%v", i, area.FirstAreaPortal, area.FirstAreaPortal+area.NumAreaPortals)
+		}
+	}
+	for i, portal := range areaPortals {
+		if portal.PortalNum < 0 {
+			return fmt.Errorf("Area portal %v has invalid portal number %v", i, portal.PortalNum)
+		}
+		if portal.OtherArea < 0 || int(portal.OtherArea) >= len(areas) {
+			return fmt.Errorf("Area portal %v has invalid area %v", i, portal.OtherArea)
+		}
+	}
+	return nil
+}
+
+// Copy the lump without parsing it
+func loadRawLump(lump Lump, r io.ReaderAt) ([]uint8, error) {
+	data := make([]uint8, lump.Length)
+	if _, err := r.ReadAt(data, int64(lump.Offset)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Get the lump indices in the order they are stored in the file
+func getLumpOrder(header Header) []int {
+	lumpOrder := make([]int, len(header.Lumps))
+	for i := range lumpOrder {
+		lumpOrder[i] = i
+	}
+	sort.SliceStable(lumpOrder, func(i, j int) bool {
+		lumpI := header.Lumps[lumpOrder[i]]
+		lumpJ := header.Lumps[lumpOrder[j]]
+		if lumpI.Offset == lumpJ.Offset {
+			// empty lumps share the offset of the next lump
+			return lumpI.Length == 0 && lumpJ.Length != 0
+		}
+		return lumpI.Offset < lumpJ.Offset
+	})
+	return lumpOrder
+}
+
 // Map each texture name to an id
 // There could be multiple textures with the same name.
 func getTextureIds(texInfos []TexInfo) map[string]int {
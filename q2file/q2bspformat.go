@@ -0,0 +1,348 @@
+package q2file
+
+import (
+	"fmt"
+	"math"
+)
+
+// The in-memory map data uses 32-bit indices, so it can hold both IBSP and QBSP maps
+// These are the layouts in the file, which are converted when loading and writing
+
+// IBSP stores these indices as 16-bit values and bounding boxes as shorts
+type edgeIBSP struct {
+	V1 uint16
+	V2 uint16
+}
+
+type faceIBSP struct {
+	Plane          uint16
+	PlaneSide      uint16
+	FirstEdge      uint32
+	NumEdges       uint16
+	TextureInfo    uint16
+	LightmapSyles  [4]uint8
+	LightmapOffset uint32
+}
+
+type nodeIBSP struct {
+	Plane      uint32
+	FrontChild int32
+	BackChild  int32
+	BBoxMin    [3]int16
+	BBoxMax    [3]int16
+	FirstFace  uint16
+	NumFaces   uint16
+}
+
+type leafIBSP struct {
+	BrushOr        uint32
+	Cluster        uint16
+	Area           uint16
+	BBoxMin        [3]int16
+	BBoxMax        [3]int16
+	FirstLeafFace  uint16
+	NumLeafFaces   uint16
+	FirstLeafBrush uint16
+	NumLeafBrushes uint16
+}
+
+type brushSideIBSP struct {
+	Plane   uint16
+	TexInfo int16
+}
+
+// QBSP widens them to 32-bit values and bounding boxes to floats
+type edgeQBSP struct {
+	V1 uint32
+	V2 uint32
+}
+
+type faceQBSP struct {
+	Plane          uint32
+	PlaneSide      int32
+	FirstEdge      int32
+	NumEdges       int32
+	TextureInfo    int32
+	LightmapSyles  [4]uint8
+	LightmapOffset uint32
+}
+
+type nodeQBSP struct {
+	Plane      uint32
+	FrontChild int32
+	BackChild  int32
+	BBoxMin    [3]float32
+	BBoxMax    [3]float32
+	FirstFace  uint32
+	NumFaces   uint32
+}
+
+type leafQBSP struct {
+	BrushOr        uint32
+	Cluster        int32
+	Area           int32
+	BBoxMin        [3]float32
+	BBoxMax        [3]float32
+	FirstLeafFace  uint32
+	NumLeafFaces   uint32
+	FirstLeafBrush uint32
+	NumLeafBrushes uint32
+}
+
+type brushSideQBSP struct {
+	Plane   uint32
+	TexInfo int32
+}
+
+func (item edgeIBSP) toEdge() Edge {
+	return Edge{V1: uint32(item.V1), V2: uint32(item.V2)}
+}
+
+func (item edgeQBSP) toEdge() Edge {
+	return Edge{V1: item.V1, V2: item.V2}
+}
+
+func (item faceIBSP) toFace() Face {
+	return Face{
+		Plane:          uint32(item.Plane),
+		PlaneSide:      uint32(item.PlaneSide),
+		FirstEdge:      item.FirstEdge,
+		NumEdges:       uint32(item.NumEdges),
+		TextureInfo:    uint32(item.TextureInfo),
+		LightmapSyles:  item.LightmapSyles,
+		LightmapOffset: item.LightmapOffset,
+	}
+}
+
+func (item faceQBSP) toFace() Face {
+	return Face{
+		Plane:          item.Plane,
+		PlaneSide:      uint32(item.PlaneSide),
+		FirstEdge:      uint32(item.FirstEdge),
+		NumEdges:       uint32(item.NumEdges),
+		TextureInfo:    uint32(item.TextureInfo),
+		LightmapSyles:  item.LightmapSyles,
+		LightmapOffset: item.LightmapOffset,
+	}
+}
+
+func (item nodeIBSP) toNode() BSPNode {
+	return BSPNode{
+		Plane:      item.Plane,
+		FrontChild: item.FrontChild,
+		BackChild:  item.BackChild,
+		BBoxMin:    shortsToFloats(item.BBoxMin),
+		BBoxMax:    shortsToFloats(item.BBoxMax),
+		FirstFace:  uint32(item.FirstFace),
+		NumFaces:   uint32(item.NumFaces),
+	}
+}
+
+func (item nodeQBSP) toNode() BSPNode {
+	return BSPNode(item)
+}
+
+func (item leafIBSP) toLeaf() BSPLeaf {
+	cluster := uint32(item.Cluster)
+	if item.Cluster == 0xFFFF {
+		cluster = ClusterInvalid
+	}
+	return BSPLeaf{
+		BrushOr:        item.BrushOr,
+		Cluster:        cluster,
+		Area:           uint32(item.Area),
+		BBoxMin:        shortsToFloats(item.BBoxMin),
+		BBoxMax:        shortsToFloats(item.BBoxMax),
+		FirstLeafFace:  uint32(item.FirstLeafFace),
+		NumLeafFaces:   uint32(item.NumLeafFaces),
+		FirstLeafBrush: uint32(item.FirstLeafBrush),
+		NumLeafBrushes: uint32(item.NumLeafBrushes),
+	}
+}
+
+func (item leafQBSP) toLeaf() BSPLeaf {
+	return BSPLeaf{
+		BrushOr:        item.BrushOr,
+		Cluster:        uint32(item.Cluster),
+		Area:           uint32(item.Area),
+		BBoxMin:        item.BBoxMin,
+		BBoxMax:        item.BBoxMax,
+		FirstLeafFace:  item.FirstLeafFace,
+		NumLeafFaces:   item.NumLeafFaces,
+		FirstLeafBrush: item.FirstLeafBrush,
+		NumLeafBrushes: item.NumLeafBrushes,
+	}
+}
+
+func (item brushSideIBSP) toBrushSide() BrushSide {
+	return BrushSide{Plane: uint32(item.Plane), TexInfo: int32(item.TexInfo)}
+}
+
+func (item brushSideQBSP) toBrushSide() BrushSide {
+	return BrushSide(item)
+}
+
+func shortsToFloats(values [3]int16) [3]float32 {
+	return [3]float32{float32(values[0]), float32(values[1]), float32(values[2])}
+}
+
+// Bounding boxes are rounded outwards so they still contain everything
+func floatsToShorts(values [3]float32, roundUp bool) [3]int16 {
+	shorts := [3]int16{}
+	for i, value := range values {
+		rounded := math.Floor(float64(value))
+		if roundUp {
+			rounded = math.Ceil(float64(value))
+		}
+		shorts[i] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, rounded)))
+	}
+	return shorts
+}
+
+// Convert the widened lumps back to the layout of the file
+// IBSP maps that grew past the 16-bit limits are refused, the caller has to set IsQBSP to write them
+func serializeIndexLumps(mapData *MapData) (map[int]interface{}, error) {
+	lumps := make(map[int]interface{})
+	if mapData.IsQBSP {
+		edges := make([]edgeQBSP, len(mapData.Edges))
+		for i, edge := range mapData.Edges {
+			edges[i] = edgeQBSP{V1: edge.V1, V2: edge.V2}
+		}
+		faces := make([]faceQBSP, len(mapData.Faces))
+		for i, face := range mapData.Faces {
+			faces[i] = faceQBSP{
+				Plane:          face.Plane,
+				PlaneSide:      int32(face.PlaneSide),
+				FirstEdge:      int32(face.FirstEdge),
+				NumEdges:       int32(face.NumEdges),
+				TextureInfo:    int32(face.TextureInfo),
+				LightmapSyles:  face.LightmapSyles,
+				LightmapOffset: face.LightmapOffset,
+			}
+		}
+		nodes := make([]nodeQBSP, len(mapData.Nodes))
+		for i, node := range mapData.Nodes {
+			nodes[i] = nodeQBSP(node)
+		}
+		leaves := make([]leafQBSP, len(mapData.BSPLeaves))
+		for i, leaf := range mapData.BSPLeaves {
+			leaves[i] = leafQBSP{
+				BrushOr:        leaf.BrushOr,
+				Cluster:        int32(leaf.Cluster),
+				Area:           int32(leaf.Area),
+				BBoxMin:        leaf.BBoxMin,
+				BBoxMax:        leaf.BBoxMax,
+				FirstLeafFace:  leaf.FirstLeafFace,
+				NumLeafFaces:   leaf.NumLeafFaces,
+				FirstLeafBrush: leaf.FirstLeafBrush,
+				NumLeafBrushes: leaf.NumLeafBrushes,
+			}
+		}
+		brushSides := make([]brushSideQBSP, len(mapData.BrushSides))
+		for i, side := range mapData.BrushSides {
+			brushSides[i] = brushSideQBSP(side)
+		}
+
+		lumps[LumpEdges] = edges
+		lumps[LumpFaces] = faces
+		lumps[LumpBSPNodes] = nodes
+		lumps[LumpBSPLeaves] = leaves
+		lumps[LumpLeafFaces] = mapData.LeafFaces
+		lumps[LumpLeafBrushes] = mapData.LeafBrushes
+		lumps[LumpBrushSides] = brushSides
+		return lumps, nil
+	}
+
+	edges := make([]edgeIBSP, len(mapData.Edges))
+	for i, edge := range mapData.Edges {
+		if edge.V1 > math.MaxUint16 || edge.V2 > math.MaxUint16 {
+			return nil, fmt.Errorf("Edge %v has a vertex index that doesn't fit in IBSP", i)
+		}
+		edges[i] = edgeIBSP{V1: uint16(edge.V1), V2: uint16(edge.V2)}
+	}
+	faces := make([]faceIBSP, len(mapData.Faces))
+	for i, face := range mapData.Faces {
+		if face.Plane > math.MaxUint16 || face.NumEdges > math.MaxUint16 || face.TextureInfo > math.MaxUint16 {
+			return nil, fmt.Errorf("Face %v has an index that doesn't fit in IBSP", i)
+		}
+		faces[i] = faceIBSP{
+			Plane:          uint16(face.Plane),
+			PlaneSide:      uint16(face.PlaneSide),
+			FirstEdge:      face.FirstEdge,
+			NumEdges:       uint16(face.NumEdges),
+			TextureInfo:    uint16(face.TextureInfo),
+			LightmapSyles:  face.LightmapSyles,
+			LightmapOffset: face.LightmapOffset,
+		}
+	}
+	nodes := make([]nodeIBSP, len(mapData.Nodes))
+	for i, node := range mapData.Nodes {
+		if node.FirstFace > math.MaxUint16 || node.NumFaces > math.MaxUint16 {
+			return nil, fmt.Errorf("BSP node %v has a face range that doesn't fit in IBSP", i)
+		}
+		nodes[i] = nodeIBSP{
+			Plane:      node.Plane,
+			FrontChild: node.FrontChild,
+			BackChild:  node.BackChild,
+			BBoxMin:    floatsToShorts(node.BBoxMin, false),
+			BBoxMax:    floatsToShorts(node.BBoxMax, true),
+			FirstFace:  uint16(node.FirstFace),
+			NumFaces:   uint16(node.NumFaces),
+		}
+	}
+	leaves := make([]leafIBSP, len(mapData.BSPLeaves))
+	for i, leaf := range mapData.BSPLeaves {
+		cluster := uint16(0xFFFF)
+		if leaf.Cluster != ClusterInvalid {
+			if leaf.Cluster >= 0xFFFF {
+				return nil, fmt.Errorf("BSP leaf %v has a cluster that doesn't fit in IBSP", i)
+			}
+			cluster = uint16(leaf.Cluster)
+		}
+		if leaf.Area > math.MaxUint16 || leaf.FirstLeafFace > math.MaxUint16 || leaf.NumLeafFaces > math.MaxUint16 ||
+			leaf.FirstLeafBrush > math.MaxUint16 || leaf.NumLeafBrushes > math.MaxUint16 {
+			return nil, fmt.Errorf("BSP leaf %v has an index that doesn't fit in IBSP", i)
+		}
+		leaves[i] = leafIBSP{
+			BrushOr:        leaf.BrushOr,
+			Cluster:        cluster,
+			Area:           uint16(leaf.Area),
+			BBoxMin:        floatsToShorts(leaf.BBoxMin, false),
+			BBoxMax:        floatsToShorts(leaf.BBoxMax, true),
+			FirstLeafFace:  uint16(leaf.FirstLeafFace),
+			NumLeafFaces:   uint16(leaf.NumLeafFaces),
+			FirstLeafBrush: uint16(leaf.FirstLeafBrush),
+			NumLeafBrushes: uint16(leaf.NumLeafBrushes),
+		}
+	}
+	leafFaces := make([]uint16, len(mapData.LeafFaces))
+	for i, leafFace := range mapData.LeafFaces {
+		if leafFace > math.MaxUint16 {
+			return nil, fmt.Errorf("Leaf face %v doesn't fit in IBSP", i)
+		}
+		leafFaces[i] = uint16(leafFace)
+	}
+	leafBrushes := make([]uint16, len(mapData.LeafBrushes))
+	for i, leafBrush := range mapData.LeafBrushes {
+		if leafBrush > math.MaxUint16 {
+			return nil, fmt.Errorf("Leaf brush %v doesn't fit in IBSP", i)
+		}
+		leafBrushes[i] = uint16(leafBrush)
+	}
+	brushSides := make([]brushSideIBSP, len(mapData.BrushSides))
+	for i, side := range mapData.BrushSides {
+		if side.Plane > math.MaxUint16 || side.TexInfo > math.MaxInt16 {
+			return nil, fmt.Errorf("Brush side %v has an index that doesn't fit in IBSP", i)
+		}
+		brushSides[i] = brushSideIBSP{Plane: uint16(side.Plane), TexInfo: int16(side.TexInfo)}
+	}
+
+	lumps[LumpEdges] = edges
+	lumps[LumpFaces] = faces
+	lumps[LumpBSPNodes] = nodes
+	lumps[LumpBSPLeaves] = leaves
+	lumps[LumpLeafFaces] = leafFaces
+	lumps[LumpLeafBrushes] = leafBrushes
+	lumps[LumpBrushSides] = brushSides
+	return lumps, nil
+}
@@ -0,0 +1,161 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"unsafe"
+)
+
+// Same lump order used by the Quake 2 map compiler
+var defaultLumpOrder = []int{
+	LumpPlanes, LumpBSPLeaves, LumpVertices, LumpBSPNodes, LumpTexInfos, LumpFaces,
+	LumpBrushes, LumpBrushSides, LumpLeafFaces, LumpLeafBrushes, LumpFaceEdges, LumpEdges,
+	LumpModels, LumpAreas, LumpAreaPortals, LumpLightmaps, LumpVisibility, LumpEntities, LumpPop,
+}
+
+// Write the map data as an IBSP or QBSP version 38 file
+// Lumps are written in the same order as the file they were loaded from, followed by any BSPX lumps
+func WriteQ2BSP(w io.WriteSeeker, mapData *MapData) error {
+	if mapData.Version != 0 && mapData.Version != 38 {
+		return fmt.Errorf("BSP version %v can't be written as a Quake 2 map", mapData.Version)
+	}
+
+	lumpData, err := serializeLumps(mapData)
+	if err != nil {
+		return err
+	}
+
+	header := Header{}
+	if mapData.IsQBSP {
+		copy(header.Magic[:], "QBSP")
+	} else {
+		copy(header.Magic[:], "IBSP")
+	}
+	header.Version = 38
+
+	lumpOrder := mapData.lumpOrder
+	if len(lumpOrder) != len(header.Lumps) {
+		lumpOrder = defaultLumpOrder
+	}
+
+	// Leave space for the header, which is written once the offsets are known
+	headerSize := int64(unsafe.Sizeof(header))
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.Write(make([]byte, headerSize)); err != nil {
+		return err
+	}
+
+	offset := headerSize
+	for _, lumpIndex := range lumpOrder {
+		// Each lump starts on a 4 byte boundary
+		padding := (4 - offset%4) % 4
+		if _, err := w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+		offset += padding
+
+		data := lumpData[lumpIndex]
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		header.Lumps[lumpIndex] = Lump{
+			Offset: uint32(offset),
+			Length: uint32(len(data)),
+		}
+		offset += int64(len(data))
+	}
+
+	// The file length is also padded
+	padding := (4 - offset%4) % 4
+	if _, err := w.Write(make([]byte, padding)); err != nil {
+		return err
+	}
+	offset += padding
+
+	bspxLumps, err := serializeBSPXLumps(mapData)
+	if err != nil {
+		return err
+	}
+	if _, err := writeBSPXLumps(w, offset, bspxLumps); err != nil {
+		return fmt.Errorf("Failed to write BSPX lumps: %v", err)
+	}
+
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	_, err = w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// Convert each lump back to its binary format
+func serializeLumps(mapData *MapData) ([19][]byte, error) {
+	var lumpData [19][]byte
+
+	indexLumps, err := serializeIndexLumps(mapData)
+	if err != nil {
+		return lumpData, err
+	}
+
+	// The visibility lump stores the cluster offsets in front of the data,
+	// so VisibilityData is already the whole lump
+	lumps := []struct {
+		index int
+		name  string
+		data  interface{}
+	}{
+		{LumpPlanes, "planes", mapData.Planes},
+		{LumpVertices, "vertices", mapData.Vertices},
+		{LumpVisibility, "visibility data", mapData.VisibilityData},
+		{LumpBSPNodes, "BSP nodes", indexLumps[LumpBSPNodes]},
+		{LumpTexInfos, "texture info", mapData.TexInfos},
+		{LumpFaces, "faces", indexLumps[LumpFaces]},
+		{LumpLightmaps, "lightmap data", mapData.LightmapData},
+		{LumpBSPLeaves, "BSP leaves", indexLumps[LumpBSPLeaves]},
+		{LumpLeafFaces, "leaf faces", indexLumps[LumpLeafFaces]},
+		{LumpLeafBrushes, "leaf brushes", indexLumps[LumpLeafBrushes]},
+		{LumpEdges, "edges", indexLumps[LumpEdges]},
+		{LumpFaceEdges, "face edges", mapData.FaceEdges},
+		{LumpModels, "models", mapData.Models},
+		{LumpBrushes, "brushes", mapData.Brushes},
+		{LumpBrushSides, "brush sides", indexLumps[LumpBrushSides]},
+		{LumpPop, "pop data", mapData.PopData},
+		{LumpAreas, "areas", mapData.Areas},
+		{LumpAreaPortals, "area portals", mapData.AreaPortals},
+	}
+	for _, lump := range lumps {
+		buffer := new(bytes.Buffer)
+		if err := binary.Write(buffer, binary.LittleEndian, lump.data); err != nil {
+			return lumpData, fmt.Errorf("Failed to write %v: %v", lump.name, err)
+		}
+		lumpData[lump.index] = buffer.Bytes()
+	}
+
+	lumpData[LumpEntities] = serializeEntityLump(mapData)
+	return lumpData, nil
+}
+
+// Reuse the original entity text if the entities haven't changed,
+// since the formatting in the file might be different
+func serializeEntityLump(mapData *MapData) []byte {
+	if mapData.entityLump != nil {
+		text := mapData.entityLump
+		if end := bytes.IndexByte(text, 0); end >= 0 {
+			text = text[:end]
+		}
+		originalEntities, err := ParseEntities(string(text))
+		if err == nil && reflect.DeepEqual(originalEntities, mapData.Entities) {
+			return mapData.entityLump
+		}
+	}
+
+	// The entity string is null terminated
+	return append([]byte(SerializeEntities(mapData.Entities)), 0)
+}
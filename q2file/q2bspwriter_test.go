@@ -0,0 +1,344 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// A single floor face over one leaf, with something in every lump
+func newTestMapData(isQBSP bool) *MapData {
+	texInfo := TexInfo{
+		UAxis: [3]float32{1, 0, 0},
+		VAxis: [3]float32{0, 1, 0},
+	}
+	copy(texInfo.TextureName[:], "e1u1/floor1_1")
+
+	// The face covers 64x64 units, which is 5x5 lightmap texels
+	lightmapData := make([]uint8, 5*5*3)
+	for i := range lightmapData {
+		lightmapData[i] = uint8(i)
+	}
+
+	// One cluster that can see and hear itself
+	visibilityData := []uint8{1, 0, 0, 0, 12, 0, 0, 0, 13, 0, 0, 0, 1, 1}
+
+	return &MapData{
+		Version: 38,
+		IsQBSP:  isQBSP,
+		Entities: []Entity{
+			{Fields: []EntityField{{Key: "classname", Value: "worldspawn"}, {Key: "sky", Value: "unit1_"}}},
+			{Fields: []EntityField{{Key: "classname", Value: "info_player_start"}, {Key: "origin", Value: "32 32 24"}}},
+		},
+		Vertices: []Vertex{{0, 0, 0}, {64, 0, 0}, {64, 64, 0}, {0, 64, 0}},
+		Edges:    []Edge{{0, 0}, {0, 1}, {1, 2}, {2, 3}, {3, 0}},
+		Faces: []Face{
+			{Plane: 0, FirstEdge: 0, NumEdges: 4, TextureInfo: 0, LightmapSyles: [4]uint8{0, 255, 255, 255}},
+		},
+		FaceEdges:      []FaceEdge{{1}, {2}, {3}, {4}},
+		TexInfos:       []TexInfo{texInfo},
+		LightmapData:   lightmapData,
+		Nodes:          []BSPNode{{Plane: 0, FrontChild: -2, BackChild: -1, BBoxMax: [3]float32{64, 64, 64}, NumFaces: 1}},
+		Planes:         []Plane{{Normal: [3]float32{0, 0, 1}, Type: 2}},
+		VisibilityData: visibilityData,
+		BSPLeaves: []BSPLeaf{
+			{BrushOr: ContentsSolid, Cluster: ClusterInvalid, BBoxMin: [3]float32{0, 0, -16}, BBoxMax: [3]float32{64, 64, 0}, NumLeafBrushes: 1},
+			{Cluster: 0, Area: 1, BBoxMax: [3]float32{64, 64, 64}, NumLeafFaces: 1},
+		},
+		VisibilityOffsets: []VisibilityOffset{{Pvs: 12, Phs: 13}},
+		LeafFaces:         []LeafFace{0},
+		Models:            []Model{{BBoxMin: [3]float32{0, 0, -16}, BBoxMax: [3]float32{64, 64, 64}, NumFaces: 1}},
+		Brushes:           []Brush{{FirstSide: 0, NumSides: 1, Contents: ContentsSolid}},
+		BrushSides:        []BrushSide{{Plane: 0, TexInfo: 0}},
+		LeafBrushes:       []LeafBrush{0},
+		Areas:             []Area{{}, {}},
+		AreaPortals:       []AreaPortal{},
+		PopData:           []uint8{},
+	}
+}
+
+// The same 5x5 texels as the texture axes would give
+func newTestDecoupledLightmaps() []DecoupledLightmap {
+	return []DecoupledLightmap{
+		{Width: 5, Height: 5, WorldToLightmap: [2][4]float32{{1.0 / 16, 0, 0, 0}, {0, 1.0 / 16, 0, 0}}},
+	}
+}
+
+func writeTestFile(t *testing.T, name string, write func(file *os.File) error) []byte {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := write(file); err != nil {
+		t.Fatalf("Failed to write %v: %v", name, err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func writeTestBSP(t *testing.T, mapData *MapData) []byte {
+	t.Helper()
+	return writeTestFile(t, "test.bsp", func(file *os.File) error {
+		return WriteQ2BSP(file, mapData)
+	})
+}
+
+func readTestHeader(t *testing.T, data []byte) Header {
+	t.Helper()
+	header := Header{}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func lumpBytes(data []byte, lump Lump) []byte {
+	return data[lump.Offset : lump.Offset+lump.Length]
+}
+
+// Every field that is stored in the file, ignoring what the loader derives from them
+func checkMapData(t *testing.T, got *MapData, want *MapData) {
+	t.Helper()
+	fields := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"IsQBSP", got.IsQBSP, want.IsQBSP},
+		{"Entities", got.Entities, want.Entities},
+		{"Vertices", got.Vertices, want.Vertices},
+		{"Edges", got.Edges, want.Edges},
+		{"Faces", got.Faces, want.Faces},
+		{"FaceEdges", got.FaceEdges, want.FaceEdges},
+		{"TexInfos", got.TexInfos, want.TexInfos},
+		{"LightmapData", got.LightmapData, want.LightmapData},
+		{"Nodes", got.Nodes, want.Nodes},
+		{"Planes", got.Planes, want.Planes},
+		{"BSPLeaves", got.BSPLeaves, want.BSPLeaves},
+		{"LeafFaces", got.LeafFaces, want.LeafFaces},
+		{"VisibilityData", got.VisibilityData, want.VisibilityData},
+		{"VisibilityOffsets", got.VisibilityOffsets, want.VisibilityOffsets},
+		{"Models", got.Models, want.Models},
+		{"Brushes", got.Brushes, want.Brushes},
+		{"BrushSides", got.BrushSides, want.BrushSides},
+		{"LeafBrushes", got.LeafBrushes, want.LeafBrushes},
+		{"Areas", got.Areas, want.Areas},
+		{"DecoupledLightmaps", got.DecoupledLightmaps, want.DecoupledLightmaps},
+	}
+	for _, field := range fields {
+		if !reflect.DeepEqual(field.got, field.want) {
+			t.Errorf("%v = %+v, want %+v", field.name, field.got, field.want)
+		}
+	}
+	if len(got.AreaPortals) != len(want.AreaPortals) || len(got.PopData) != len(want.PopData) {
+		t.Errorf("Got %v area portals and %v bytes of pop data, want %v and %v",
+			len(got.AreaPortals), len(got.PopData), len(want.AreaPortals), len(want.PopData))
+	}
+}
+
+func TestWriteQ2BSPRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		isQBSP bool
+		modify func(mapData *MapData)
+	}{
+		{"IBSP", false, func(mapData *MapData) {}},
+		{"IBSP without visibility", false, func(mapData *MapData) {
+			mapData.VisibilityData = []uint8{0, 0, 0, 0}
+			mapData.VisibilityOffsets = []VisibilityOffset{}
+			mapData.BSPLeaves[1].Cluster = ClusterInvalid
+		}},
+		{"IBSP with an area portal", false, func(mapData *MapData) {
+			mapData.Areas = []Area{{}, {NumAreaPortals: 1}}
+			mapData.AreaPortals = []AreaPortal{{PortalNum: 1, OtherArea: 1}}
+		}},
+		{"QBSP", true, func(mapData *MapData) {}},
+		{"QBSP with BSPX lumps", true, func(mapData *MapData) {
+			mapData.BSPXLumps = map[string][]uint8{"OTHER": {1, 2, 3}, "EMPTY": {}}
+		}},
+		{"IBSP with decoupled lightmaps", false, func(mapData *MapData) {
+			mapData.DecoupledLightmaps = newTestDecoupledLightmaps()
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fixture := newTestMapData(test.isQBSP)
+			test.modify(fixture)
+			fixtureData := writeTestBSP(t, fixture)
+
+			header := readTestHeader(t, fixtureData)
+			magic, faceSize, edgeSize, leafSize, indexSize := "IBSP", unsafe.Sizeof(faceIBSP{}), unsafe.Sizeof(edgeIBSP{}), unsafe.Sizeof(leafIBSP{}), 2
+			if test.isQBSP {
+				magic, faceSize, edgeSize, leafSize, indexSize = "QBSP", unsafe.Sizeof(faceQBSP{}), unsafe.Sizeof(edgeQBSP{}), unsafe.Sizeof(leafQBSP{}), 4
+			}
+			if string(header.Magic[:]) != magic || header.Version != 38 {
+				t.Errorf("Header is %q version %v, want %v version 38", header.Magic[:], header.Version, magic)
+			}
+			lumpSizes := []struct {
+				lump int
+				size int
+			}{
+				{LumpFaces, len(fixture.Faces) * int(faceSize)},
+				{LumpEdges, len(fixture.Edges) * int(edgeSize)},
+				{LumpBSPLeaves, len(fixture.BSPLeaves) * int(leafSize)},
+				{LumpLeafFaces, len(fixture.LeafFaces) * indexSize},
+				{LumpLeafBrushes, len(fixture.LeafBrushes) * indexSize},
+				{LumpAreaPortals, len(fixture.AreaPortals) * int(unsafe.Sizeof(AreaPortal{}))},
+				{LumpVisibility, len(fixture.VisibilityData)},
+				{LumpLightmaps, len(fixture.LightmapData)},
+			}
+			for _, lumpSize := range lumpSizes {
+				if int(header.Lumps[lumpSize.lump].Length) != lumpSize.size {
+					t.Errorf("Lump %v is %v bytes, want %v", lumpSize.lump, header.Lumps[lumpSize.lump].Length, lumpSize.size)
+				}
+			}
+
+			loaded, err := LoadQ2BSP(bytes.NewReader(fixtureData))
+			if err != nil {
+				t.Fatalf("Failed to load written map: %v", err)
+			}
+			checkMapData(t, loaded, fixture)
+			for name, data := range fixture.BSPXLumps {
+				if !bytes.Equal(loaded.BSPXLumps[name], data) {
+					t.Errorf("BSPX lump %v = %v, want %v", name, loaded.BSPXLumps[name], data)
+				}
+			}
+
+			// Writing the loaded map again gives back the same lumps
+			rewrittenData := writeTestBSP(t, loaded)
+			if !bytes.Equal(rewrittenData, fixtureData) {
+				rewrittenHeader := readTestHeader(t, rewrittenData)
+				for i := range header.Lumps {
+					if !bytes.Equal(lumpBytes(rewrittenData, rewrittenHeader.Lumps[i]), lumpBytes(fixtureData, header.Lumps[i])) {
+						t.Errorf("Lump %v changed after writing the loaded map", i)
+					}
+				}
+				t.Errorf("Rewritten map is %v bytes, want the same %v bytes", len(rewrittenData), len(fixtureData))
+			}
+		})
+	}
+}
+
+// Converting between 16 and 32-bit indices keeps every value
+func TestWriteQ2BSPConvertIndices(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   bool
+		to     bool
+		toSize int
+	}{
+		{"IBSP to QBSP", false, true, int(unsafe.Sizeof(faceQBSP{}))},
+		{"QBSP to IBSP", true, false, int(unsafe.Sizeof(faceIBSP{}))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loaded, err := LoadQ2BSP(bytes.NewReader(writeTestBSP(t, newTestMapData(test.from))))
+			if err != nil {
+				t.Fatalf("Failed to load fixture: %v", err)
+			}
+
+			loaded.IsQBSP = test.to
+			convertedData := writeTestBSP(t, loaded)
+			header := readTestHeader(t, convertedData)
+			if int(header.Lumps[LumpFaces].Length) != len(loaded.Faces)*test.toSize {
+				t.Errorf("Face lump is %v bytes, want %v", header.Lumps[LumpFaces].Length, len(loaded.Faces)*test.toSize)
+			}
+
+			converted, err := LoadQ2BSP(bytes.NewReader(convertedData))
+			if err != nil {
+				t.Fatalf("Failed to load converted map: %v", err)
+			}
+			checkMapData(t, converted, newTestMapData(test.to))
+
+			// The other lumps don't depend on the index size
+			fixtureData := writeTestBSP(t, newTestMapData(test.to))
+			if !bytes.Equal(convertedData, fixtureData) {
+				t.Errorf("Converted map doesn't match the map written as %v", string(header.Magic[:]))
+			}
+		})
+	}
+}
+
+func TestWriteQ2BSPIndexLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(mapData *MapData)
+	}{
+		{"edge vertex", func(mapData *MapData) { mapData.Edges[1].V2 = 0x10000 }},
+		{"face texture info", func(mapData *MapData) { mapData.Faces[0].TextureInfo = 0x10000 }},
+		{"leaf face", func(mapData *MapData) { mapData.LeafFaces[0] = 0x10000 }},
+		{"leaf cluster", func(mapData *MapData) { mapData.BSPLeaves[1].Cluster = 0xFFFF }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mapData := newTestMapData(false)
+			test.modify(mapData)
+			file, err := os.Create(filepath.Join(t.TempDir(), "test.bsp"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if err := WriteQ2BSP(file, mapData); err == nil {
+				t.Errorf("Wrote IBSP with an index that doesn't fit in 16 bits")
+			}
+
+			// The same map can be written with 32-bit indices
+			mapData.IsQBSP = true
+			if err := WriteQ2BSP(file, mapData); err != nil {
+				t.Errorf("Failed to write QBSP: %v", err)
+			}
+		})
+	}
+}
+
+// The decoupled lightmaps have one entry per face, so they are dropped once the faces no longer match
+func TestWriteQ2BSPDecoupledLightmaps(t *testing.T) {
+	tests := []struct {
+		name        string
+		numFaces    int
+		wantWritten bool
+	}{
+		{"same faces", 1, true},
+		{"face added", 2, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mapData := newTestMapData(false)
+			mapData.BSPXLumps = map[string][]uint8{"OTHER": {1, 2, 3}}
+			mapData.DecoupledLightmaps = newTestDecoupledLightmaps()
+			for len(mapData.Faces) < test.numFaces {
+				mapData.Faces = append(mapData.Faces, mapData.Faces[0])
+			}
+
+			loaded, err := LoadQ2BSP(bytes.NewReader(writeTestBSP(t, mapData)))
+			if err != nil {
+				t.Fatalf("Failed to load written map: %v", err)
+			}
+			if _, written := loaded.BSPXLumps[BSPXLumpDecoupledLightmap]; written != test.wantWritten {
+				t.Errorf("%v lump written = %v for %v faces, want %v", BSPXLumpDecoupledLightmap, written,
+					len(mapData.Faces), test.wantWritten)
+			}
+			if test.wantWritten && !reflect.DeepEqual(loaded.DecoupledLightmaps, mapData.DecoupledLightmaps) {
+				t.Errorf("DecoupledLightmaps = %+v, want %+v", loaded.DecoupledLightmaps, mapData.DecoupledLightmaps)
+			}
+			if !bytes.Equal(loaded.BSPXLumps["OTHER"], []uint8{1, 2, 3}) {
+				t.Errorf("Other BSPX lump = %v, want [1 2 3]", loaded.BSPXLumps["OTHER"])
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	BSPXLumpDecoupledLightmap = "DECOUPLED_LM"
+	BSPXLumpLightGridOctree   = "LIGHTGRID_OCTREE"
+
+	// Light grid node children with these bits set aren't nodes
+	LightGridChildLeaf  = uint32(0x80000000)
+	LightGridChildEmpty = uint32(0x40000000)
+	// Cells outside the map have this style count and no samples
+	lightGridCellOccluded = uint8(0xff)
+)
+
+// The BSPX block starts on the first 4 byte boundary after the regular lumps
+type BSPXHeader struct {
+	Magic    [4]byte // magic number ("BSPX")
+	NumLumps uint32
+}
+
+type BSPXLump struct {
+	Name   [24]byte
+	Offset uint32 // offset from the start of the file
+	Length uint32
+}
+
+// Lightmap of a face that isn't tied to the texture axes
+type DecoupledLightmap struct {
+	Width  uint16
+	Height uint16
+	Offset int32 // offset in the lightmap lump, -1 if there is none
+	// Converts a world position to lightmap coordinates, in the same form as the texture axes
+	WorldToLightmap [2][4]float32
+}
+
+// Light grid stored as an octree, so empty space and space outside the map take little room
+type LightGridOctree struct {
+	Step      [3]float32 // world size of a cell
+	Size      [3]int32   // number of cells along each axis
+	Mins      [3]float32 // world position of the first cell
+	NumStyles uint8      // maximum light styles in a cell
+	RootNode  uint32
+	Nodes     []LightGridNode
+	Leaves    []LightGridLeaf
+}
+
+// Splits its cells into 8 children at a point
+type LightGridNode struct {
+	Point    [3]uint32
+	Children [8]uint32 // node index, or a leaf index with LightGridChildLeaf set
+}
+
+// Box of cells with light samples
+type LightGridLeaf struct {
+	Mins  [3]int32
+	Size  [3]int32
+	Cells []LightGridCell // x varies fastest, then y, then z
+}
+
+type LightGridCell struct {
+	Occluded bool // cell is inside a wall
+	Samples  []LightGridSample
+}
+
+type LightGridSample struct {
+	Style uint8
+	Color [3]uint8
+}
+
+// Read the extension lumps after the end of the regular lumps, if there are any
+func loadBSPXLumps(lumps []Lump, r io.ReaderAt) (map[string][]uint8, error) {
+	end := int64(0)
+	for _, lump := range lumps {
+		if lumpEnd := int64(lump.Offset) + int64(lump.Length); lumpEnd > end {
+			end = lumpEnd
+		}
+	}
+	end = (end + 3) &^ 3
+
+	header := BSPXHeader{}
+	headerReader := io.NewSectionReader(r, end, 8)
+	if err := binary.Read(headerReader, binary.LittleEndian, &header); err != nil {
+		// No extension block
+		return nil, nil
+	}
+	if !bytes.Equal([]byte("BSPX"), header.Magic[:]) {
+		return nil, nil
+	}
+
+	bspxLumps := make(map[string][]uint8)
+	lumpReader := io.NewSectionReader(r, end+8, int64(header.NumLumps)*32)
+	for i := 0; i < int(header.NumLumps); i++ {
+		lump := BSPXLump{}
+		if err := binary.Read(lumpReader, binary.LittleEndian, &lump); err != nil {
+			return nil, fmt.Errorf("BSPX lump %v: %v", i, err)
+		}
+
+		name := byteToString(lump.Name[:])
+		data, err := loadRawLump(Lump{Offset: lump.Offset, Length: lump.Length}, r)
+		if err != nil {
+			return nil, fmt.Errorf("BSPX lump %v: %v", name, err)
+		}
+		bspxLumps[name] = data
+	}
+
+	fmt.Println("BSPX lump count:", len(bspxLumps))
+	return bspxLumps, nil
+}
+
+func parseDecoupledLightmaps(data []uint8, numFaces int) ([]DecoupledLightmap, error) {
+	// Each face has a 40 byte entry
+	if len(data) != numFaces*40 {
+		return nil, fmt.Errorf("%v has %v bytes, expected %v for %v faces", BSPXLumpDecoupledLightmap, len(data), numFaces*40, numFaces)
+	}
+
+	lightmaps := make([]DecoupledLightmap, numFaces)
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, lightmaps); err != nil {
+		return nil, err
+	}
+	return lightmaps, nil
+}
+
+func parseLightGridOctree(data []uint8) (*LightGridOctree, error) {
+	reader := bytes.NewReader(data)
+	grid := &LightGridOctree{}
+
+	header := struct {
+		Step      [3]float32
+		Size      [3]int32
+		Mins      [3]float32
+		NumStyles uint8
+		RootNode  uint32
+		NumNodes  uint32
+	}{}
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	grid.Step = header.Step
+	grid.Size = header.Size
+	grid.Mins = header.Mins
+	grid.NumStyles = header.NumStyles
+	grid.RootNode = header.RootNode
+
+	// Each node is 44 bytes
+	if int64(header.NumNodes)*44 > int64(reader.Len()) {
+		return nil, fmt.Errorf("light grid has invalid node count %v", header.NumNodes)
+	}
+	grid.Nodes = make([]LightGridNode, header.NumNodes)
+	if err := binary.Read(reader, binary.LittleEndian, grid.Nodes); err != nil {
+		return nil, err
+	}
+
+	numLeaves := uint32(0)
+	if err := binary.Read(reader, binary.LittleEndian, &numLeaves); err != nil {
+		return nil, err
+	}
+	// Each leaf is at least 24 bytes
+	if int64(numLeaves)*24 > int64(reader.Len()) {
+		return nil, fmt.Errorf("light grid has invalid leaf count %v", numLeaves)
+	}
+	grid.Leaves = make([]LightGridLeaf, numLeaves)
+	for i := range grid.Leaves {
+		leaf := &grid.Leaves[i]
+		if err := binary.Read(reader, binary.LittleEndian, &leaf.Mins); err != nil {
+			return nil, fmt.Errorf("light grid leaf %v: %v", i, err)
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &leaf.Size); err != nil {
+			return nil, fmt.Errorf("light grid leaf %v: %v", i, err)
+		}
+
+		numCells := int64(leaf.Size[0]) * int64(leaf.Size[1]) * int64(leaf.Size[2])
+		if leaf.Size[0] < 0 || leaf.Size[1] < 0 || leaf.Size[2] < 0 || numCells > int64(reader.Len()) {
+			return nil, fmt.Errorf("light grid leaf %v has invalid size %v", i, leaf.Size)
+		}
+		leaf.Cells = make([]LightGridCell, numCells)
+		for j := range leaf.Cells {
+			numSamples, err := reader.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("light grid leaf %v: %v", i, err)
+			}
+			if numSamples == lightGridCellOccluded {
+				leaf.Cells[j].Occluded = true
+				continue
+			}
+			leaf.Cells[j].Samples = make([]LightGridSample, numSamples)
+			if err := binary.Read(reader, binary.LittleEndian, leaf.Cells[j].Samples); err != nil {
+				return nil, fmt.Errorf("light grid leaf %v: %v", i, err)
+			}
+		}
+	}
+
+	for i, node := range grid.Nodes {
+		for _, child := range node.Children {
+			if child&LightGridChildEmpty != 0 {
+				continue
+			}
+			if child&LightGridChildLeaf != 0 {
+				if int(child&^LightGridChildLeaf) >= len(grid.Leaves) {
+					return nil, fmt.Errorf("light grid node %v has invalid leaf %v", i, child&^LightGridChildLeaf)
+				}
+			} else if int(child) >= len(grid.Nodes) {
+				return nil, fmt.Errorf("light grid node %v has invalid child %v", i, child)
+			}
+		}
+	}
+	return grid, nil
+}
+
+// Find the light samples at a world position, nil if the position is outside the grid or occluded
+func (grid *LightGridOctree) SampleAt(position [3]float32) []LightGridSample {
+	point := [3]uint32{}
+	for axis := 0; axis < 3; axis++ {
+		if grid.Step[axis] <= 0 {
+			return nil
+		}
+		cell := int((position[axis] - grid.Mins[axis]) / grid.Step[axis])
+		if position[axis] < grid.Mins[axis] || cell >= int(grid.Size[axis]) {
+			return nil
+		}
+		point[axis] = uint32(cell)
+	}
+
+	// Walk down the octree, the child index has one bit for each axis
+	child := grid.RootNode
+	for steps := 0; child&(LightGridChildLeaf|LightGridChildEmpty) == 0; steps++ {
+		if int(child) >= len(grid.Nodes) || steps > len(grid.Nodes) {
+			return nil
+		}
+		node := grid.Nodes[child]
+		index := 0
+		for axis := 0; axis < 3; axis++ {
+			if point[axis] >= node.Point[axis] {
+				index |= 4 >> uint(axis)
+			}
+		}
+		child = node.Children[index]
+	}
+	if child&LightGridChildEmpty != 0 {
+		return nil
+	}
+
+	leaf := grid.Leaves[child&^LightGridChildLeaf]
+	local := [3]int{}
+	for axis := 0; axis < 3; axis++ {
+		local[axis] = int(point[axis]) - int(leaf.Mins[axis])
+		if local[axis] < 0 || local[axis] >= int(leaf.Size[axis]) {
+			return nil
+		}
+	}
+	cell := leaf.Cells[(local[2]*int(leaf.Size[1])+local[1])*int(leaf.Size[0])+local[0]]
+	if cell.Occluded {
+		return nil
+	}
+	return cell.Samples
+}
+
+// The decoupled lightmaps have an entry for each face, so they are written again from
+// DecoupledLightmaps in case the faces were edited, and left out if they no longer match
+func serializeBSPXLumps(mapData *MapData) (map[string][]uint8, error) {
+	_, hasDecoupledLump := mapData.BSPXLumps[BSPXLumpDecoupledLightmap]
+	if !hasDecoupledLump && mapData.DecoupledLightmaps == nil {
+		return mapData.BSPXLumps, nil
+	}
+
+	bspxLumps := make(map[string][]uint8)
+	for name, data := range mapData.BSPXLumps {
+		bspxLumps[name] = data
+	}
+	delete(bspxLumps, BSPXLumpDecoupledLightmap)
+	if len(mapData.DecoupledLightmaps) != len(mapData.Faces) {
+		fmt.Println("Dropping", BSPXLumpDecoupledLightmap, "since it has", len(mapData.DecoupledLightmaps),
+			"entries for", len(mapData.Faces), "faces")
+		return bspxLumps, nil
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := binary.Write(buffer, binary.LittleEndian, mapData.DecoupledLightmaps); err != nil {
+		return nil, fmt.Errorf("Failed to write %v: %v", BSPXLumpDecoupledLightmap, err)
+	}
+	bspxLumps[BSPXLumpDecoupledLightmap] = buffer.Bytes()
+	return bspxLumps, nil
+}
+
+// Write the extension block at the current position, which must be after the regular lumps
+// Lumps are sorted by name so the output is the same every time
+func writeBSPXLumps(w io.Writer, offset int64, bspxLumps map[string][]uint8) (int64, error) {
+	if len(bspxLumps) == 0 {
+		return offset, nil
+	}
+
+	names := make([]string, 0, len(bspxLumps))
+	for name := range bspxLumps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	header := BSPXHeader{NumLumps: uint32(len(names))}
+	copy(header.Magic[:], "BSPX")
+	entries := make([]BSPXLump, len(names))
+
+	// Lump data follows the directory, each lump on a 4 byte boundary
+	dataOffset := offset + 8 + int64(len(names))*32
+	for i, name := range names {
+		copy(entries[i].Name[:], name)
+		entries[i].Offset = uint32(dataOffset)
+		entries[i].Length = uint32(len(bspxLumps[name]))
+		dataOffset += (int64(len(bspxLumps[name])) + 3) &^ 3
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return offset, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, entries); err != nil {
+		return offset, err
+	}
+	for _, name := range names {
+		data := bspxLumps[name]
+		padding := (4 - len(data)%4) % 4
+		if _, err := w.Write(append(append([]uint8{}, data...), make([]uint8, padding)...)); err != nil {
+			return offset, err
+		}
+	}
+	return dataOffset, nil
+}
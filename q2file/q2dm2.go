@@ -0,0 +1,1073 @@
+package q2file
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	DM2Protocol = 34
+
+	// Larger than any message a protocol 34 server sends
+	DM2MaxMessageLength = 0x10000
+	// A length of -1 marks the end of the demo
+	dm2EndOfDemo = 0xFFFFFFFF
+
+	DM2MaxEntities = 1024
+	DM2MaxStats    = 32
+	DM2MaxItems    = 256
+)
+
+// Server commands
+const (
+	SvcBad = iota
+	SvcMuzzleFlash
+	SvcMuzzleFlash2
+	SvcTempEntity
+	SvcLayout
+	SvcInventory
+	SvcNop
+	SvcDisconnect
+	SvcReconnect
+	SvcSound
+	SvcPrint
+	SvcStuffText
+	SvcServerData
+	SvcConfigString
+	SvcSpawnBaseline
+	SvcCenterPrint
+	SvcDownload
+	SvcPlayerInfo
+	SvcPacketEntities
+	SvcDeltaPacketEntities
+	SvcFrame
+)
+
+// Config string indices
+const (
+	ConfigStringName        = 0
+	ConfigStringCDTrack     = 1
+	ConfigStringSky         = 2
+	ConfigStringSkyAxis     = 3
+	ConfigStringSkyRotate   = 4
+	ConfigStringStatusBar   = 5
+	ConfigStringAirAccel    = 29
+	ConfigStringMaxClients  = 30
+	ConfigStringMapChecksum = 31
+	ConfigStringModels      = 32
+	ConfigStringSounds      = ConfigStringModels + 256
+	ConfigStringImages      = ConfigStringSounds + 256
+	ConfigStringLights      = ConfigStringImages + 256
+	ConfigStringItems       = ConfigStringLights + 256
+	ConfigStringPlayerSkins = ConfigStringItems + 256
+	ConfigStringGeneral     = ConfigStringPlayerSkins + 256
+	MaxConfigStrings        = ConfigStringGeneral + 512
+)
+
+// Bits set in an entity update for each field that changed
+const (
+	EntityBitOrigin1    = 1 << 0
+	EntityBitOrigin2    = 1 << 1
+	EntityBitAngle2     = 1 << 2
+	EntityBitAngle3     = 1 << 3
+	EntityBitFrame8     = 1 << 4
+	EntityBitEvent      = 1 << 5
+	EntityBitRemove     = 1 << 6
+	EntityBitMoreBits1  = 1 << 7
+	EntityBitNumber16   = 1 << 8
+	EntityBitOrigin3    = 1 << 9
+	EntityBitAngle1     = 1 << 10
+	EntityBitModel      = 1 << 11
+	EntityBitRenderFx8  = 1 << 12
+	EntityBitEffects8   = 1 << 14
+	EntityBitMoreBits2  = 1 << 15
+	EntityBitSkin8      = 1 << 16
+	EntityBitFrame16    = 1 << 17
+	EntityBitRenderFx16 = 1 << 18
+	EntityBitEffects16  = 1 << 19
+	EntityBitModel2     = 1 << 20
+	EntityBitModel3     = 1 << 21
+	EntityBitModel4     = 1 << 22
+	EntityBitMoreBits3  = 1 << 23
+	EntityBitOldOrigin  = 1 << 24
+	EntityBitSkin16     = 1 << 25
+	EntityBitSound      = 1 << 26
+	EntityBitSolid      = 1 << 27
+)
+
+// Bits set in a player state update for each field that changed
+const (
+	PlayerBitType        = 1 << 0
+	PlayerBitOrigin      = 1 << 1
+	PlayerBitVelocity    = 1 << 2
+	PlayerBitTime        = 1 << 3
+	PlayerBitFlags       = 1 << 4
+	PlayerBitGravity     = 1 << 5
+	PlayerBitDeltaAngles = 1 << 6
+	PlayerBitViewOffset  = 1 << 7
+	PlayerBitViewAngles  = 1 << 8
+	PlayerBitKickAngles  = 1 << 9
+	PlayerBitBlend       = 1 << 10
+	PlayerBitFOV         = 1 << 11
+	PlayerBitWeaponIndex = 1 << 12
+	PlayerBitWeaponFrame = 1 << 13
+	PlayerBitRDFlags     = 1 << 14
+)
+
+// Flags at the start of a sound message
+const (
+	SoundBitVolume      = 1 << 0
+	SoundBitAttenuation = 1 << 1
+	SoundBitPosition    = 1 << 2
+	SoundBitEntity      = 1 << 3
+	SoundBitOffset      = 1 << 4
+)
+
+// Temporary entity types
+const (
+	TEGunshot = iota
+	TEBlood
+	TEBlaster
+	TERailTrail
+	TEShotgun
+	TEExplosion1
+	TEExplosion2
+	TERocketExplosion
+	TEGrenadeExplosion
+	TESparks
+	TESplash
+	TEBubbleTrail
+	TEScreenSparks
+	TEShieldSparks
+	TEBulletSparks
+	TELaserSparks
+	TEParasiteAttack
+	TERocketExplosionWater
+	TEGrenadeExplosionWater
+	TEMedicCableAttack
+	TEBFGExplosion
+	TEBFGBigExplosion
+	TEBossTeleport
+	TEBFGLaser
+	TEGrappleCable
+	TEWeldingSparks
+	TEGreenBlood
+	TEBlueHyperblaster
+	TEPlasmaExplosion
+	TETunnelSparks
+	TEBlaster2
+	TERailTrail2
+	TEFlame
+	TELightning
+	TEDebugTrail
+	TEPlainExplosion
+	TEFlashlight
+	TEForceWall
+	TEHeatBeam
+	TEMonsterHeatBeam
+	TESteam
+	TEBubbleTrail2
+	TEMoreBlood
+	TEHeatBeamSparks
+	TEHeatBeamSteam
+	TEChainfistSmoke
+	TEElectricSparks
+	TETrackerExplosion
+	TETeleportEffect
+	TEDBallGoal
+	TEWidowBeamOut
+	TENukeBlast
+	TEWidowSplash
+	TEExplosion1Big
+	TEExplosion1NP
+	TEFlechette
+)
+
+// Every message in a block has its own type
+// Use a type switch to handle the ones you need
+type DM2Message interface {
+	Command() uint8
+}
+
+type DM2ServerData struct {
+	Protocol    int32
+	ServerCount int32
+	AttractLoop uint8 // 1 for demos recorded by the server
+	GameDir     string
+	PlayerNum   int16
+	LevelName   string
+}
+
+type DM2ConfigString struct {
+	Index int16
+	Value string
+}
+
+type DM2EntityState struct {
+	Number     int
+	Origin     [3]float32
+	Angles     [3]float32
+	OldOrigin  [3]float32
+	ModelIndex [4]uint8
+	Frame      int16
+	Skin       int32
+	Effects    uint32
+	RenderFx   uint32
+	Solid      int16
+	Sound      uint8
+	Event      uint8
+}
+
+// Only the fields with a bit set were sent, the others are zero
+type DM2EntityDelta struct {
+	Bits  uint32
+	State DM2EntityState
+}
+
+type DM2SpawnBaseline struct {
+	Delta DM2EntityDelta
+}
+
+type DM2PlayerState struct {
+	MoveType    uint8
+	Origin      [3]float32
+	Velocity    [3]float32
+	MoveTime    uint8
+	MoveFlags   uint8
+	Gravity     int16
+	DeltaAngles [3]float32
+	ViewOffset  [3]float32
+	ViewAngles  [3]float32
+	KickAngles  [3]float32
+	GunIndex    uint8
+	GunFrame    uint8
+	GunOffset   [3]float32
+	GunAngles   [3]float32
+	Blend       [4]float32
+	FOV         float32
+	RDFlags     uint8
+	Stats       [DM2MaxStats]int16
+}
+
+// Only the fields with a bit set were sent, the others are zero
+type DM2PlayerStateDelta struct {
+	Bits     uint16
+	StatBits uint32
+	State    DM2PlayerState
+}
+
+// A snapshot of the world, delta compressed against an earlier frame
+type DM2Frame struct {
+	ServerFrame   int32
+	DeltaFrame    int32 // -1 if the frame isn't delta compressed
+	SuppressCount uint8
+	AreaBits      []uint8 // one bit per area, set if the area is visible
+	PlayerState   DM2PlayerStateDelta
+	Entities      []DM2EntityDelta
+}
+
+// Unused fields are zero, the type decides which ones are sent
+type DM2TempEntity struct {
+	Type        uint8
+	Position    [3]float32
+	EndPosition [3]float32
+	Offset      [3]float32
+	Direction   [3]float32
+	Count       uint8
+	Color       uint8
+	Entity      int16
+	DestEntity  int16
+	Magnitude   int16
+	Interval    int32
+}
+
+type DM2Sound struct {
+	Flags       uint8
+	SoundIndex  uint8
+	Volume      float32
+	Attenuation float32
+	TimeOffset  float32
+	Entity      int16
+	Channel     int16
+	Position    [3]float32
+}
+
+type DM2MuzzleFlash struct {
+	Monster bool // sent as svc_muzzleflash2
+	Entity  int16
+	Weapon  uint8
+}
+
+type DM2Print struct {
+	Level uint8
+	Text  string
+}
+
+type DM2CenterPrint struct {
+	Text string
+}
+
+type DM2StuffText struct {
+	Text string
+}
+
+type DM2Layout struct {
+	Text string
+}
+
+type DM2Inventory struct {
+	Items [DM2MaxItems]int16
+}
+
+type DM2Download struct {
+	Size    int16
+	Percent uint8
+	Data    []uint8
+}
+
+type DM2Disconnect struct{}
+
+type DM2Reconnect struct{}
+
+func (DM2ServerData) Command() uint8    { return SvcServerData }
+func (DM2ConfigString) Command() uint8  { return SvcConfigString }
+func (DM2SpawnBaseline) Command() uint8 { return SvcSpawnBaseline }
+func (DM2Frame) Command() uint8         { return SvcFrame }
+func (DM2TempEntity) Command() uint8    { return SvcTempEntity }
+func (DM2Sound) Command() uint8         { return SvcSound }
+func (DM2Print) Command() uint8         { return SvcPrint }
+func (DM2CenterPrint) Command() uint8   { return SvcCenterPrint }
+func (DM2StuffText) Command() uint8     { return SvcStuffText }
+func (DM2Layout) Command() uint8        { return SvcLayout }
+func (DM2Inventory) Command() uint8     { return SvcInventory }
+func (DM2Download) Command() uint8      { return SvcDownload }
+func (DM2Disconnect) Command() uint8    { return SvcDisconnect }
+func (DM2Reconnect) Command() uint8     { return SvcReconnect }
+
+func (message DM2MuzzleFlash) Command() uint8 {
+	if message.Monster {
+		return SvcMuzzleFlash2
+	}
+	return SvcMuzzleFlash
+}
+
+// The messages sent by the server in one network packet
+type DM2Block struct {
+	Offset   int64 // position of the block in the demo file
+	Messages []DM2Message
+}
+
+// A new server data message means the demo moved on to the next level
+func (block *DM2Block) HasServerData() bool {
+	for _, message := range block.Messages {
+		if _, ok := message.(DM2ServerData); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type DM2Demo struct {
+	Blocks []DM2Block
+}
+
+// Reads a demo one block at a time, so large demos don't need to be loaded at once
+type DM2Reader struct {
+	r          io.Reader
+	offset     int64
+	blockIndex int
+}
+
+func NewDM2Reader(r io.Reader) *DM2Reader {
+	return &DM2Reader{r: r}
+}
+
+// Returns io.EOF after the last block
+func (reader *DM2Reader) NextBlock() (*DM2Block, error) {
+	blockOffset := reader.offset
+
+	var length uint32
+	if err := binary.Read(reader.r, binary.LittleEndian, &length); err != nil {
+		// Some demos end without the end marker
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("DM2 block %v at offset %v: truncated block length", reader.blockIndex, blockOffset)
+	}
+	if length == dm2EndOfDemo {
+		return nil, io.EOF
+	}
+	if length > DM2MaxMessageLength {
+		return nil, fmt.Errorf("DM2 block %v at offset %v: invalid length %v", reader.blockIndex, blockOffset, length)
+	}
+
+	data := make([]uint8, length)
+	if _, err := io.ReadFull(reader.r, data); err != nil {
+		return nil, fmt.Errorf("DM2 block %v at offset %v: expected %v bytes, demo is truncated", reader.blockIndex, blockOffset, length)
+	}
+	reader.offset += 4 + int64(length)
+
+	messages, err := parseDM2Messages(data)
+	if err != nil {
+		return nil, fmt.Errorf("DM2 block %v at offset %v: %v", reader.blockIndex, blockOffset, err)
+	}
+	reader.blockIndex++
+
+	return &DM2Block{
+		Offset:   blockOffset,
+		Messages: messages,
+	}, nil
+}
+
+func LoadQ2DM2(r io.Reader) (*DM2Demo, error) {
+	reader := NewDM2Reader(r)
+	demo := &DM2Demo{}
+	for {
+		block, err := reader.NextBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		demo.Blocks = append(demo.Blocks, *block)
+	}
+	return demo, nil
+}
+
+func parseDM2Messages(data []uint8) ([]DM2Message, error) {
+	msg := &dm2MessageReader{data: data}
+	messages := make([]DM2Message, 0)
+
+	for msg.remaining() > 0 {
+		commandOffset := msg.pos
+		command := msg.readByte()
+
+		message, err := parseDM2Command(msg, command)
+		if err == nil {
+			err = msg.err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("command %v at byte %v: %v", command, commandOffset, err)
+		}
+		if message != nil {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func parseDM2Command(msg *dm2MessageReader, command uint8) (DM2Message, error) {
+	switch command {
+	case SvcNop:
+		return nil, nil
+	case SvcMuzzleFlash, SvcMuzzleFlash2:
+		return DM2MuzzleFlash{
+			Monster: command == SvcMuzzleFlash2,
+			Entity:  msg.readShort(),
+			Weapon:  msg.readByte(),
+		}, nil
+	case SvcTempEntity:
+		return parseDM2TempEntity(msg)
+	case SvcLayout:
+		return DM2Layout{Text: msg.readString()}, nil
+	case SvcInventory:
+		inventory := DM2Inventory{}
+		for i := range inventory.Items {
+			inventory.Items[i] = msg.readShort()
+		}
+		return inventory, nil
+	case SvcDisconnect:
+		return DM2Disconnect{}, nil
+	case SvcReconnect:
+		return DM2Reconnect{}, nil
+	case SvcSound:
+		return parseDM2Sound(msg)
+	case SvcPrint:
+		return DM2Print{Level: msg.readByte(), Text: msg.readString()}, nil
+	case SvcStuffText:
+		return DM2StuffText{Text: msg.readString()}, nil
+	case SvcServerData:
+		serverData := DM2ServerData{
+			Protocol:    msg.readLong(),
+			ServerCount: msg.readLong(),
+			AttractLoop: msg.readByte(),
+			GameDir:     msg.readString(),
+			PlayerNum:   msg.readShort(),
+			LevelName:   msg.readString(),
+		}
+		if msg.err == nil && serverData.Protocol != DM2Protocol {
+			return nil, fmt.Errorf("unsupported protocol %v, expected %v", serverData.Protocol, DM2Protocol)
+		}
+		return serverData, nil
+	case SvcConfigString:
+		configString := DM2ConfigString{Index: msg.readShort(), Value: msg.readString()}
+		if configString.Index < 0 || configString.Index >= MaxConfigStrings {
+			return nil, fmt.Errorf("config string index %v out of range", configString.Index)
+		}
+		return configString, nil
+	case SvcSpawnBaseline:
+		delta, err := parseDM2EntityDelta(msg)
+		if err != nil {
+			return nil, err
+		}
+		return DM2SpawnBaseline{Delta: delta}, nil
+	case SvcCenterPrint:
+		return DM2CenterPrint{Text: msg.readString()}, nil
+	case SvcDownload:
+		download := DM2Download{Size: msg.readShort(), Percent: msg.readByte()}
+		if download.Size > 0 {
+			download.Data = msg.readBytes(int(download.Size))
+		}
+		return download, nil
+	case SvcFrame:
+		return parseDM2Frame(msg)
+	case SvcPlayerInfo, SvcPacketEntities:
+		return nil, fmt.Errorf("command can only be sent after a frame")
+	}
+	return nil, fmt.Errorf("unknown server command")
+}
+
+// The player state and packet entities are always sent right after the frame header
+func parseDM2Frame(msg *dm2MessageReader) (DM2Message, error) {
+	frame := DM2Frame{
+		ServerFrame:   msg.readLong(),
+		DeltaFrame:    msg.readLong(),
+		SuppressCount: msg.readByte(),
+	}
+	areaBitsLength := msg.readByte()
+	frame.AreaBits = msg.readBytes(int(areaBitsLength))
+
+	if command := msg.readByte(); msg.err == nil && command != SvcPlayerInfo {
+		return nil, fmt.Errorf("frame %v: expected player info, got command %v", frame.ServerFrame, command)
+	}
+	frame.PlayerState = parseDM2PlayerState(msg)
+
+	if command := msg.readByte(); msg.err == nil && command != SvcPacketEntities {
+		return nil, fmt.Errorf("frame %v: expected packet entities, got command %v", frame.ServerFrame, command)
+	}
+	for msg.err == nil {
+		delta, err := parseDM2EntityDelta(msg)
+		if err != nil {
+			return nil, fmt.Errorf("frame %v: %v", frame.ServerFrame, err)
+		}
+		// Entity 0 is the end of the list
+		if delta.State.Number == 0 {
+			break
+		}
+		frame.Entities = append(frame.Entities, delta)
+	}
+
+	return frame, nil
+}
+
+func parseDM2PlayerState(msg *dm2MessageReader) DM2PlayerStateDelta {
+	delta := DM2PlayerStateDelta{Bits: uint16(msg.readShort())}
+	state := &delta.State
+	bits := delta.Bits
+
+	if bits&PlayerBitType != 0 {
+		state.MoveType = msg.readByte()
+	}
+	if bits&PlayerBitOrigin != 0 {
+		state.Origin = msg.readPosition()
+	}
+	if bits&PlayerBitVelocity != 0 {
+		state.Velocity = msg.readPosition()
+	}
+	if bits&PlayerBitTime != 0 {
+		state.MoveTime = msg.readByte()
+	}
+	if bits&PlayerBitFlags != 0 {
+		state.MoveFlags = msg.readByte()
+	}
+	if bits&PlayerBitGravity != 0 {
+		state.Gravity = msg.readShort()
+	}
+	if bits&PlayerBitDeltaAngles != 0 {
+		for i := 0; i < 3; i++ {
+			state.DeltaAngles[i] = msg.readAngle16()
+		}
+	}
+	if bits&PlayerBitViewOffset != 0 {
+		state.ViewOffset = msg.readQuarterVector()
+	}
+	if bits&PlayerBitViewAngles != 0 {
+		for i := 0; i < 3; i++ {
+			state.ViewAngles[i] = msg.readAngle16()
+		}
+	}
+	if bits&PlayerBitKickAngles != 0 {
+		state.KickAngles = msg.readQuarterVector()
+	}
+	if bits&PlayerBitWeaponIndex != 0 {
+		state.GunIndex = msg.readByte()
+	}
+	if bits&PlayerBitWeaponFrame != 0 {
+		state.GunFrame = msg.readByte()
+		state.GunOffset = msg.readQuarterVector()
+		state.GunAngles = msg.readQuarterVector()
+	}
+	if bits&PlayerBitBlend != 0 {
+		for i := 0; i < 4; i++ {
+			state.Blend[i] = float32(msg.readByte()) / 255.0
+		}
+	}
+	if bits&PlayerBitFOV != 0 {
+		state.FOV = float32(msg.readByte())
+	}
+	if bits&PlayerBitRDFlags != 0 {
+		state.RDFlags = msg.readByte()
+	}
+
+	delta.StatBits = uint32(msg.readLong())
+	for i := 0; i < DM2MaxStats; i++ {
+		if delta.StatBits&(1<<uint(i)) != 0 {
+			state.Stats[i] = msg.readShort()
+		}
+	}
+	return delta
+}
+
+// Copy the fields that were sent over an earlier player state
+func (delta DM2PlayerStateDelta) Apply(from DM2PlayerState) DM2PlayerState {
+	to := from
+	state := delta.State
+	bits := delta.Bits
+
+	if bits&PlayerBitType != 0 {
+		to.MoveType = state.MoveType
+	}
+	if bits&PlayerBitOrigin != 0 {
+		to.Origin = state.Origin
+	}
+	if bits&PlayerBitVelocity != 0 {
+		to.Velocity = state.Velocity
+	}
+	if bits&PlayerBitTime != 0 {
+		to.MoveTime = state.MoveTime
+	}
+	if bits&PlayerBitFlags != 0 {
+		to.MoveFlags = state.MoveFlags
+	}
+	if bits&PlayerBitGravity != 0 {
+		to.Gravity = state.Gravity
+	}
+	if bits&PlayerBitDeltaAngles != 0 {
+		to.DeltaAngles = state.DeltaAngles
+	}
+	if bits&PlayerBitViewOffset != 0 {
+		to.ViewOffset = state.ViewOffset
+	}
+	if bits&PlayerBitViewAngles != 0 {
+		to.ViewAngles = state.ViewAngles
+	}
+	if bits&PlayerBitKickAngles != 0 {
+		to.KickAngles = state.KickAngles
+	}
+	if bits&PlayerBitWeaponIndex != 0 {
+		to.GunIndex = state.GunIndex
+	}
+	if bits&PlayerBitWeaponFrame != 0 {
+		to.GunFrame = state.GunFrame
+		to.GunOffset = state.GunOffset
+		to.GunAngles = state.GunAngles
+	}
+	if bits&PlayerBitBlend != 0 {
+		to.Blend = state.Blend
+	}
+	if bits&PlayerBitFOV != 0 {
+		to.FOV = state.FOV
+	}
+	if bits&PlayerBitRDFlags != 0 {
+		to.RDFlags = state.RDFlags
+	}
+	for i := 0; i < DM2MaxStats; i++ {
+		if delta.StatBits&(1<<uint(i)) != 0 {
+			to.Stats[i] = state.Stats[i]
+		}
+	}
+	return to
+}
+
+func parseDM2EntityDelta(msg *dm2MessageReader) (DM2EntityDelta, error) {
+	bits := uint32(msg.readByte())
+	if bits&EntityBitMoreBits1 != 0 {
+		bits |= uint32(msg.readByte()) << 8
+	}
+	if bits&EntityBitMoreBits2 != 0 {
+		bits |= uint32(msg.readByte()) << 16
+	}
+	if bits&EntityBitMoreBits3 != 0 {
+		bits |= uint32(msg.readByte()) << 24
+	}
+
+	delta := DM2EntityDelta{Bits: bits}
+	state := &delta.State
+	if bits&EntityBitNumber16 != 0 {
+		state.Number = int(msg.readShort())
+	} else {
+		state.Number = int(msg.readByte())
+	}
+	if msg.err == nil && (state.Number < 0 || state.Number >= DM2MaxEntities) {
+		return delta, fmt.Errorf("entity number %v out of range", state.Number)
+	}
+
+	if bits&EntityBitModel != 0 {
+		state.ModelIndex[0] = msg.readByte()
+	}
+	if bits&EntityBitModel2 != 0 {
+		state.ModelIndex[1] = msg.readByte()
+	}
+	if bits&EntityBitModel3 != 0 {
+		state.ModelIndex[2] = msg.readByte()
+	}
+	if bits&EntityBitModel4 != 0 {
+		state.ModelIndex[3] = msg.readByte()
+	}
+
+	if bits&EntityBitFrame8 != 0 {
+		state.Frame = int16(msg.readByte())
+	}
+	if bits&EntityBitFrame16 != 0 {
+		state.Frame = msg.readShort()
+	}
+
+	// Setting both bits sends the full 32-bit value
+	if bits&EntityBitSkin8 != 0 && bits&EntityBitSkin16 != 0 {
+		state.Skin = msg.readLong()
+	} else if bits&EntityBitSkin8 != 0 {
+		state.Skin = int32(msg.readByte())
+	} else if bits&EntityBitSkin16 != 0 {
+		state.Skin = int32(uint16(msg.readShort()))
+	}
+
+	if bits&EntityBitEffects8 != 0 && bits&EntityBitEffects16 != 0 {
+		state.Effects = uint32(msg.readLong())
+	} else if bits&EntityBitEffects8 != 0 {
+		state.Effects = uint32(msg.readByte())
+	} else if bits&EntityBitEffects16 != 0 {
+		state.Effects = uint32(uint16(msg.readShort()))
+	}
+
+	if bits&EntityBitRenderFx8 != 0 && bits&EntityBitRenderFx16 != 0 {
+		state.RenderFx = uint32(msg.readLong())
+	} else if bits&EntityBitRenderFx8 != 0 {
+		state.RenderFx = uint32(msg.readByte())
+	} else if bits&EntityBitRenderFx16 != 0 {
+		state.RenderFx = uint32(uint16(msg.readShort()))
+	}
+
+	if bits&EntityBitOrigin1 != 0 {
+		state.Origin[0] = msg.readCoord()
+	}
+	if bits&EntityBitOrigin2 != 0 {
+		state.Origin[1] = msg.readCoord()
+	}
+	if bits&EntityBitOrigin3 != 0 {
+		state.Origin[2] = msg.readCoord()
+	}
+
+	if bits&EntityBitAngle1 != 0 {
+		state.Angles[0] = msg.readAngle()
+	}
+	if bits&EntityBitAngle2 != 0 {
+		state.Angles[1] = msg.readAngle()
+	}
+	if bits&EntityBitAngle3 != 0 {
+		state.Angles[2] = msg.readAngle()
+	}
+
+	if bits&EntityBitOldOrigin != 0 {
+		state.OldOrigin = msg.readPosition()
+	}
+	if bits&EntityBitSound != 0 {
+		state.Sound = msg.readByte()
+	}
+	if bits&EntityBitEvent != 0 {
+		state.Event = msg.readByte()
+	}
+	if bits&EntityBitSolid != 0 {
+		state.Solid = msg.readShort()
+	}
+
+	return delta, nil
+}
+
+// Entities marked as removed should be dropped instead of applied
+func (delta DM2EntityDelta) IsRemoved() bool {
+	return delta.Bits&EntityBitRemove != 0
+}
+
+// Copy the fields that were sent over an earlier entity state
+// The old origin defaults to the previous origin and events only last one frame
+func (delta DM2EntityDelta) Apply(from DM2EntityState) DM2EntityState {
+	to := from
+	state := delta.State
+	bits := delta.Bits
+
+	to.Number = state.Number
+	to.OldOrigin = from.Origin
+	to.Event = state.Event
+
+	if bits&EntityBitModel != 0 {
+		to.ModelIndex[0] = state.ModelIndex[0]
+	}
+	if bits&EntityBitModel2 != 0 {
+		to.ModelIndex[1] = state.ModelIndex[1]
+	}
+	if bits&EntityBitModel3 != 0 {
+		to.ModelIndex[2] = state.ModelIndex[2]
+	}
+	if bits&EntityBitModel4 != 0 {
+		to.ModelIndex[3] = state.ModelIndex[3]
+	}
+	if bits&(EntityBitFrame8|EntityBitFrame16) != 0 {
+		to.Frame = state.Frame
+	}
+	if bits&(EntityBitSkin8|EntityBitSkin16) != 0 {
+		to.Skin = state.Skin
+	}
+	if bits&(EntityBitEffects8|EntityBitEffects16) != 0 {
+		to.Effects = state.Effects
+	}
+	if bits&(EntityBitRenderFx8|EntityBitRenderFx16) != 0 {
+		to.RenderFx = state.RenderFx
+	}
+	if bits&EntityBitOrigin1 != 0 {
+		to.Origin[0] = state.Origin[0]
+	}
+	if bits&EntityBitOrigin2 != 0 {
+		to.Origin[1] = state.Origin[1]
+	}
+	if bits&EntityBitOrigin3 != 0 {
+		to.Origin[2] = state.Origin[2]
+	}
+	if bits&EntityBitAngle1 != 0 {
+		to.Angles[0] = state.Angles[0]
+	}
+	if bits&EntityBitAngle2 != 0 {
+		to.Angles[1] = state.Angles[1]
+	}
+	if bits&EntityBitAngle3 != 0 {
+		to.Angles[2] = state.Angles[2]
+	}
+	if bits&EntityBitOldOrigin != 0 {
+		to.OldOrigin = state.OldOrigin
+	}
+	if bits&EntityBitSound != 0 {
+		to.Sound = state.Sound
+	}
+	if bits&EntityBitSolid != 0 {
+		to.Solid = state.Solid
+	}
+	return to
+}
+
+func parseDM2Sound(msg *dm2MessageReader) (DM2Message, error) {
+	sound := DM2Sound{
+		Flags:       msg.readByte(),
+		SoundIndex:  msg.readByte(),
+		Volume:      1.0,
+		Attenuation: 1.0,
+	}
+
+	if sound.Flags&SoundBitVolume != 0 {
+		sound.Volume = float32(msg.readByte()) / 255.0
+	}
+	if sound.Flags&SoundBitAttenuation != 0 {
+		sound.Attenuation = float32(msg.readByte()) / 64.0
+	}
+	if sound.Flags&SoundBitOffset != 0 {
+		sound.TimeOffset = float32(msg.readByte()) / 1000.0
+	}
+	// The entity and channel are packed together
+	if sound.Flags&SoundBitEntity != 0 {
+		entityChannel := uint16(msg.readShort())
+		sound.Entity = int16(entityChannel >> 3)
+		sound.Channel = int16(entityChannel & 7)
+		if sound.Entity >= DM2MaxEntities {
+			return nil, fmt.Errorf("sound entity %v out of range", sound.Entity)
+		}
+	}
+	if sound.Flags&SoundBitPosition != 0 {
+		sound.Position = msg.readPosition()
+	}
+	return sound, nil
+}
+
+func parseDM2TempEntity(msg *dm2MessageReader) (DM2Message, error) {
+	tempEntity := DM2TempEntity{Type: msg.readByte()}
+	var err error
+
+	switch tempEntity.Type {
+	case TEBlood, TEGunshot, TESparks, TEBulletSparks, TEScreenSparks, TEShieldSparks,
+		TEShotgun, TEBlaster, TEGreenBlood, TEBlaster2, TEFlechette,
+		TEHeatBeamSparks, TEHeatBeamSteam, TEMoreBlood, TEElectricSparks:
+		tempEntity.Position = msg.readPosition()
+		tempEntity.Direction, err = msg.readDirection()
+	case TESplash, TELaserSparks, TEWeldingSparks, TETunnelSparks:
+		tempEntity.Count = msg.readByte()
+		tempEntity.Position = msg.readPosition()
+		tempEntity.Direction, err = msg.readDirection()
+		tempEntity.Color = msg.readByte()
+	case TERailTrail, TEBubbleTrail, TEBFGLaser, TEDebugTrail, TEBubbleTrail2:
+		tempEntity.Position = msg.readPosition()
+		tempEntity.EndPosition = msg.readPosition()
+	case TEExplosion1, TEExplosion2, TEExplosion1Big, TEExplosion1NP,
+		TERocketExplosion, TERocketExplosionWater, TEGrenadeExplosion, TEGrenadeExplosionWater,
+		TEPlasmaExplosion, TEBFGExplosion, TEBFGBigExplosion, TEBossTeleport,
+		TEPlainExplosion, TETrackerExplosion, TETeleportEffect, TEDBallGoal,
+		TEChainfistSmoke, TENukeBlast, TEWidowSplash:
+		tempEntity.Position = msg.readPosition()
+	case TEParasiteAttack, TEMedicCableAttack, TEHeatBeam, TEMonsterHeatBeam:
+		tempEntity.Entity = msg.readShort()
+		tempEntity.Position = msg.readPosition()
+		tempEntity.EndPosition = msg.readPosition()
+	case TEGrappleCable:
+		tempEntity.Entity = msg.readShort()
+		tempEntity.Position = msg.readPosition()
+		tempEntity.EndPosition = msg.readPosition()
+		tempEntity.Offset = msg.readPosition()
+	case TELightning:
+		tempEntity.Entity = msg.readShort()
+		tempEntity.DestEntity = msg.readShort()
+		tempEntity.Position = msg.readPosition()
+		tempEntity.EndPosition = msg.readPosition()
+	case TEFlashlight:
+		tempEntity.Position = msg.readPosition()
+		tempEntity.Entity = msg.readShort()
+	case TEForceWall:
+		tempEntity.Position = msg.readPosition()
+		tempEntity.EndPosition = msg.readPosition()
+		tempEntity.Color = msg.readByte()
+	case TESteam:
+		// Entity is -1 for a one time puff, otherwise the steam repeats
+		tempEntity.Entity = msg.readShort()
+		tempEntity.Count = msg.readByte()
+		tempEntity.Position = msg.readPosition()
+		tempEntity.Direction, err = msg.readDirection()
+		tempEntity.Color = msg.readByte()
+		tempEntity.Magnitude = msg.readShort()
+		if tempEntity.Entity != -1 {
+			tempEntity.Interval = msg.readLong()
+		}
+	case TEWidowBeamOut:
+		tempEntity.Entity = msg.readShort()
+		tempEntity.Position = msg.readPosition()
+	default:
+		return nil, fmt.Errorf("unknown temp entity type %v", tempEntity.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return tempEntity, nil
+}
+
+// Reads values from a server message
+// Reading past the end sets err and returns zero, so it only needs to be checked once per command
+type dm2MessageReader struct {
+	data []uint8
+	pos  int
+	err  error
+}
+
+func (msg *dm2MessageReader) remaining() int {
+	return len(msg.data) - msg.pos
+}
+
+func (msg *dm2MessageReader) readBytes(count int) []uint8 {
+	if msg.err != nil {
+		return nil
+	}
+	if count > msg.remaining() {
+		msg.err = fmt.Errorf("unexpected end of message, needed %v bytes but only %v left", count, msg.remaining())
+		msg.pos = len(msg.data)
+		return nil
+	}
+	values := make([]uint8, count)
+	copy(values, msg.data[msg.pos:msg.pos+count])
+	msg.pos += count
+	return values
+}
+
+func (msg *dm2MessageReader) readByte() uint8 {
+	values := msg.readBytes(1)
+	if values == nil {
+		return 0
+	}
+	return values[0]
+}
+
+func (msg *dm2MessageReader) readChar() int8 {
+	return int8(msg.readByte())
+}
+
+func (msg *dm2MessageReader) readShort() int16 {
+	values := msg.readBytes(2)
+	if values == nil {
+		return 0
+	}
+	return int16(binary.LittleEndian.Uint16(values))
+}
+
+func (msg *dm2MessageReader) readLong() int32 {
+	values := msg.readBytes(4)
+	if values == nil {
+		return 0
+	}
+	return int32(binary.LittleEndian.Uint32(values))
+}
+
+// Strings end with a null character or at the end of the message
+func (msg *dm2MessageReader) readString() string {
+	if msg.err != nil {
+		return ""
+	}
+	start := msg.pos
+	for msg.pos < len(msg.data) && msg.data[msg.pos] != 0 {
+		msg.pos++
+	}
+	value := string(msg.data[start:msg.pos])
+	if msg.pos < len(msg.data) {
+		msg.pos++
+	}
+	return value
+}
+
+// Coordinates are sent in 1/8 units
+func (msg *dm2MessageReader) readCoord() float32 {
+	return float32(msg.readShort()) * 0.125
+}
+
+func (msg *dm2MessageReader) readPosition() [3]float32 {
+	return [3]float32{msg.readCoord(), msg.readCoord(), msg.readCoord()}
+}
+
+func (msg *dm2MessageReader) readAngle() float32 {
+	return float32(msg.readChar()) * (360.0 / 256.0)
+}
+
+func (msg *dm2MessageReader) readAngle16() float32 {
+	return float32(msg.readShort()) * (360.0 / 65536.0)
+}
+
+// View offsets and gun angles are sent in 1/4 units
+func (msg *dm2MessageReader) readQuarterVector() [3]float32 {
+	return [3]float32{
+		float32(msg.readChar()) * 0.25,
+		float32(msg.readChar()) * 0.25,
+		float32(msg.readChar()) * 0.25,
+	}
+}
+
+// Directions are sent as an index in the normal table
+func (msg *dm2MessageReader) readDirection() ([3]float32, error) {
+	index := int(msg.readByte())
+	if index >= len(MD2Normals) {
+		return [3]float32{}, fmt.Errorf("direction index %v out of range", index)
+	}
+	return MD2Normals[index], nil
+}
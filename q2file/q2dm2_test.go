@@ -0,0 +1,211 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// Writes messages the same way the server does, so they can be parsed back
+type dm2TestMessage struct {
+	bytes.Buffer
+}
+
+func (msg *dm2TestMessage) writeByte(value uint8) {
+	msg.WriteByte(value)
+}
+
+func (msg *dm2TestMessage) writeShort(value int16) {
+	binary.Write(msg, binary.LittleEndian, value)
+}
+
+func (msg *dm2TestMessage) writeLong(value int32) {
+	binary.Write(msg, binary.LittleEndian, value)
+}
+
+func (msg *dm2TestMessage) writeString(value string) {
+	msg.WriteString(value)
+	msg.WriteByte(0)
+}
+
+func (msg *dm2TestMessage) writeCoord(value float32) {
+	msg.writeShort(int16(value * 8))
+}
+
+func writeTestDM2(blocks ...*dm2TestMessage) []byte {
+	demo := &bytes.Buffer{}
+	for _, block := range blocks {
+		binary.Write(demo, binary.LittleEndian, uint32(block.Len()))
+		demo.Write(block.Bytes())
+	}
+	binary.Write(demo, binary.LittleEndian, uint32(dm2EndOfDemo))
+	return demo.Bytes()
+}
+
+func TestDM2ClientParseBlock(t *testing.T) {
+	// The first block starts the level and sends a full frame
+	first := &dm2TestMessage{}
+	first.writeByte(SvcServerData)
+	first.writeLong(DM2Protocol)
+	first.writeLong(1)
+	first.writeByte(1)
+	first.writeString("")
+	first.writeShort(0)
+	first.writeString("Outer Base")
+
+	first.writeByte(SvcConfigString)
+	first.writeShort(ConfigStringLights)
+	first.writeString("m")
+
+	// Baseline for entity 5 with a model and an origin
+	first.writeByte(SvcSpawnBaseline)
+	first.writeByte(EntityBitOrigin1 | EntityBitMoreBits1)
+	first.writeByte(EntityBitModel >> 8)
+	first.writeByte(5)
+	first.writeByte(1)
+	first.writeCoord(16)
+
+	first.writeByte(SvcFrame)
+	first.writeLong(1)
+	first.writeLong(-1)
+	first.writeByte(0)
+	first.writeByte(1)
+	first.writeByte(0x02)
+	first.writeByte(SvcPlayerInfo)
+	first.writeShort(PlayerBitOrigin)
+	first.writeCoord(8)
+	first.writeCoord(-8)
+	first.writeCoord(24)
+	first.writeLong(0)
+	first.writeByte(SvcPacketEntities)
+	// Entity 5 moves from its baseline
+	first.writeByte(EntityBitOrigin1)
+	first.writeByte(5)
+	first.writeCoord(32)
+	// Entity 7 is new and only sends its model
+	first.writeByte(EntityBitMoreBits1)
+	first.writeByte(EntityBitModel >> 8)
+	first.writeByte(7)
+	first.writeByte(2)
+	// End of the entity list
+	first.writeByte(0)
+	first.writeByte(0)
+
+	// The second block turns off a light and is delta compressed against frame 1
+	second := &dm2TestMessage{}
+	second.writeByte(SvcConfigString)
+	second.writeShort(ConfigStringLights)
+	second.writeString("a")
+
+	second.writeByte(SvcFrame)
+	second.writeLong(2)
+	second.writeLong(1)
+	second.writeByte(0)
+	second.writeByte(1)
+	second.writeByte(0x06)
+	second.writeByte(SvcPlayerInfo)
+	second.writeShort(0)
+	second.writeLong(1 << 1)
+	second.writeShort(100)
+	second.writeByte(SvcPacketEntities)
+	// Entity 5 isn't sent so it stays the same, entity 7 is removed
+	second.writeByte(EntityBitRemove)
+	second.writeByte(7)
+	second.writeByte(0)
+	second.writeByte(0)
+
+	reader := NewDM2Reader(bytes.NewReader(writeTestDM2(first, second)))
+	client := NewDM2Client()
+
+	firstBlock, err := reader.NextBlock()
+	if err != nil {
+		t.Fatalf("Failed to read first block: %v", err)
+	}
+	if !firstBlock.HasServerData() {
+		t.Errorf("First block has no server data")
+	}
+	snapshots, err := client.ParseBlock(firstBlock)
+	if err != nil {
+		t.Fatalf("Failed to parse first block: %v", err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("First block has %v snapshots, want 1", len(snapshots))
+	}
+	firstSnapshot := snapshots[0]
+
+	if client.ServerData.LevelName != "Outer Base" {
+		t.Errorf("Level name is %q, want %q", client.ServerData.LevelName, "Outer Base")
+	}
+	if firstSnapshot.ServerFrame != 1 {
+		t.Errorf("Server frame is %v, want 1", firstSnapshot.ServerFrame)
+	}
+	if !firstSnapshot.IsAreaVisible(1) || firstSnapshot.IsAreaVisible(2) {
+		t.Errorf("Area bits are %v, want only area 1 visible", firstSnapshot.AreaBits)
+	}
+	if origin := firstSnapshot.PlayerState.Origin; origin != [3]float32{8, -8, 24} {
+		t.Errorf("Player origin is %v, want [8 -8 24]", origin)
+	}
+	if len(firstSnapshot.Entities) != 2 {
+		t.Fatalf("First snapshot has %v entities, want 2", len(firstSnapshot.Entities))
+	}
+	entity := firstSnapshot.FindEntity(5)
+	if entity == nil {
+		t.Fatalf("Entity 5 is missing from the first snapshot")
+	}
+	if entity.ModelIndex[0] != 1 {
+		t.Errorf("Entity 5 model is %v, want 1 from the baseline", entity.ModelIndex[0])
+	}
+	if entity.Origin != [3]float32{32, 0, 0} || entity.OldOrigin != [3]float32{16, 0, 0} {
+		t.Errorf("Entity 5 moved from %v to %v, want [16 0 0] to [32 0 0]", entity.OldOrigin, entity.Origin)
+	}
+	if entity := firstSnapshot.FindEntity(7); entity == nil || entity.ModelIndex[0] != 2 {
+		t.Errorf("Entity 7 is %v, want model 2", entity)
+	}
+	if firstSnapshot.LightStyles[0] != "m" {
+		t.Errorf("Light style 0 is %q, want %q", firstSnapshot.LightStyles[0], "m")
+	}
+
+	secondBlock, err := reader.NextBlock()
+	if err != nil {
+		t.Fatalf("Failed to read second block: %v", err)
+	}
+	if secondBlock.HasServerData() {
+		t.Errorf("Second block has server data")
+	}
+	snapshots, err = client.ParseBlock(secondBlock)
+	if err != nil {
+		t.Fatalf("Failed to parse second block: %v", err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("Second block has %v snapshots, want 1", len(snapshots))
+	}
+	secondSnapshot := snapshots[0]
+
+	if origin := secondSnapshot.PlayerState.Origin; origin != [3]float32{8, -8, 24} {
+		t.Errorf("Player origin is %v, want [8 -8 24] from the delta frame", origin)
+	}
+	if secondSnapshot.PlayerState.Stats[1] != 100 {
+		t.Errorf("Player stat 1 is %v, want 100", secondSnapshot.PlayerState.Stats[1])
+	}
+	if len(secondSnapshot.Entities) != 1 || secondSnapshot.FindEntity(7) != nil {
+		t.Fatalf("Second snapshot has entities %v, want only entity 5", secondSnapshot.Entities)
+	}
+	entity = secondSnapshot.FindEntity(5)
+	if entity == nil {
+		t.Fatalf("Entity 5 is missing from the second snapshot")
+	}
+	if entity.Origin != [3]float32{32, 0, 0} || entity.OldOrigin != [3]float32{32, 0, 0} {
+		t.Errorf("Entity 5 moved from %v to %v, want it to stay at [32 0 0]", entity.OldOrigin, entity.Origin)
+	}
+	if secondSnapshot.LightStyles[0] != "a" {
+		t.Errorf("Light style 0 is %q, want %q", secondSnapshot.LightStyles[0], "a")
+	}
+	if firstSnapshot.LightStyles[0] != "m" {
+		t.Errorf("Light style 0 of the first snapshot changed to %q", firstSnapshot.LightStyles[0])
+	}
+
+	if _, err := reader.NextBlock(); err != io.EOF {
+		t.Errorf("Expected end of demo, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package q2file
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// The client only keeps this many frames to delta compress against
+	DM2UpdateBackup = 16
+	// Server frames are sent 10 times per second
+	DM2FrameTime = 0.1
+)
+
+// The full world state after a frame has been decompressed
+type DM2Snapshot struct {
+	ServerFrame int32
+	AreaBits    []uint8
+	PlayerState DM2PlayerState
+	Entities    []DM2EntityState // sorted by entity number
+	// Light style patterns when the frame was received, shared between frames until one changes
+	LightStyles *[MaxLightStyles]string
+}
+
+// Decodes the delta compressed frames in a demo, like the game client does
+type DM2Client struct {
+	ServerData    DM2ServerData
+	ConfigStrings [MaxConfigStrings]string
+	Baselines     [DM2MaxEntities]DM2EntityState
+
+	frames      map[int32]*DM2Snapshot
+	lightStyles *[MaxLightStyles]string
+}
+
+func NewDM2Client() *DM2Client {
+	return &DM2Client{
+		frames:      make(map[int32]*DM2Snapshot),
+		lightStyles: &[MaxLightStyles]string{},
+	}
+}
+
+// Update the client state with all messages in the block
+// Returns a snapshot for each frame in the block
+func (client *DM2Client) ParseBlock(block *DM2Block) ([]DM2Snapshot, error) {
+	snapshots := make([]DM2Snapshot, 0)
+	for _, message := range block.Messages {
+		switch message := message.(type) {
+		case DM2ServerData:
+			// A new level starts from scratch
+			client.ServerData = message
+			client.ConfigStrings = [MaxConfigStrings]string{}
+			client.Baselines = [DM2MaxEntities]DM2EntityState{}
+			client.frames = make(map[int32]*DM2Snapshot)
+			client.lightStyles = &[MaxLightStyles]string{}
+		case DM2ConfigString:
+			client.ConfigStrings[message.Index] = message.Value
+			client.updateLightStyle(int(message.Index), message.Value)
+		case DM2SpawnBaseline:
+			number := message.Delta.State.Number
+			client.Baselines[number] = message.Delta.Apply(DM2EntityState{})
+		case DM2Frame:
+			snapshot, err := client.applyFrame(message)
+			if err != nil {
+				return nil, fmt.Errorf("DM2 block at offset %v: %v", block.Offset, err)
+			}
+			if snapshot != nil {
+				snapshots = append(snapshots, *snapshot)
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+// Switchable lights are turned on and off by changing their style during the demo
+// Earlier snapshots keep the old patterns, so the patterns are copied before changing one
+func (client *DM2Client) updateLightStyle(index int, value string) {
+	style := index - ConfigStringLights
+	if style < 0 || style >= MaxLightStyles || client.lightStyles[style] == value {
+		return
+	}
+	lightStyles := *client.lightStyles
+	lightStyles[style] = value
+	client.lightStyles = &lightStyles
+}
+
+// Frames compressed against a frame that was never received are dropped
+func (client *DM2Client) applyFrame(frame DM2Frame) (*DM2Snapshot, error) {
+	var oldSnapshot *DM2Snapshot
+	if frame.DeltaFrame > 0 {
+		oldFrame, exists := client.frames[frame.DeltaFrame]
+		if !exists || frame.ServerFrame-frame.DeltaFrame >= DM2UpdateBackup {
+			return nil, nil
+		}
+		oldSnapshot = oldFrame
+	}
+
+	snapshot := &DM2Snapshot{
+		ServerFrame: frame.ServerFrame,
+		AreaBits:    frame.AreaBits,
+		LightStyles: client.lightStyles,
+	}
+
+	oldEntities := make([]DM2EntityState, 0)
+	if oldSnapshot != nil {
+		snapshot.PlayerState = frame.PlayerState.Apply(oldSnapshot.PlayerState)
+		oldEntities = oldSnapshot.Entities
+	} else {
+		snapshot.PlayerState = frame.PlayerState.Apply(DM2PlayerState{})
+	}
+
+	for i := 1; i < len(frame.Entities); i++ {
+		if frame.Entities[i].State.Number <= frame.Entities[i-1].State.Number {
+			return nil, fmt.Errorf("frame %v: packet entities are out of order", frame.ServerFrame)
+		}
+	}
+
+	// Both lists are sorted by entity number, so merge them
+	// Old entities not in the update are unchanged, new entities start from their baseline
+	entities := make([]DM2EntityState, 0, len(oldEntities)+len(frame.Entities))
+	oldIndex := 0
+	for _, delta := range frame.Entities {
+		number := delta.State.Number
+		for oldIndex < len(oldEntities) && oldEntities[oldIndex].Number < number {
+			entities = append(entities, unchangedEntity(oldEntities[oldIndex]))
+			oldIndex++
+		}
+
+		from := client.Baselines[number]
+		if oldIndex < len(oldEntities) && oldEntities[oldIndex].Number == number {
+			from = oldEntities[oldIndex]
+			oldIndex++
+		}
+		if delta.IsRemoved() {
+			continue
+		}
+		entities = append(entities, delta.Apply(from))
+	}
+	for ; oldIndex < len(oldEntities); oldIndex++ {
+		entities = append(entities, unchangedEntity(oldEntities[oldIndex]))
+	}
+	snapshot.Entities = entities
+
+	client.frames[frame.ServerFrame] = snapshot
+	for serverFrame := range client.frames {
+		if serverFrame <= frame.ServerFrame-DM2UpdateBackup {
+			delete(client.frames, serverFrame)
+		}
+	}
+	return snapshot, nil
+}
+
+// An entity that didn't change still moves its old origin forward and clears its event
+func unchangedEntity(from DM2EntityState) DM2EntityState {
+	return DM2EntityDelta{State: DM2EntityState{Number: from.Number}}.Apply(from)
+}
+
+// Returns the entity with the given number, or nil if it isn't in the snapshot
+func (snapshot *DM2Snapshot) FindEntity(number int) *DM2EntityState {
+	index := sort.Search(len(snapshot.Entities), func(i int) bool {
+		return snapshot.Entities[i].Number >= number
+	})
+	if index < len(snapshot.Entities) && snapshot.Entities[index].Number == number {
+		return &snapshot.Entities[index]
+	}
+	return nil
+}
+
+// Check if an area was visible to the player in this frame
+func (snapshot *DM2Snapshot) IsAreaVisible(area int) bool {
+	if area < 0 || area/8 >= len(snapshot.AreaBits) {
+		return false
+	}
+	return snapshot.AreaBits[area/8]&(1<<uint(area%8)) != 0
+}
@@ -0,0 +1,268 @@
+package q2file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A single key/value pair inside an entity block
+type EntityField struct {
+	Key   string
+	Value string
+}
+
+// Entities keep their fields in the order they appear in the map
+type Entity struct {
+	Fields []EntityField
+}
+
+// Get the value for a key (the last one wins if the key is repeated)
+func (entity Entity) Get(key string) (string, bool) {
+	value := ""
+	found := false
+	for _, field := range entity.Fields {
+		if field.Key == key {
+			value = field.Value
+			found = true
+		}
+	}
+	return value, found
+}
+
+func (entity Entity) ClassName() string {
+	className, _ := entity.Get("classname")
+	return className
+}
+
+// Parse a vector stored as three space separated numbers (e.g. "origin" "64 -128 24")
+func (entity Entity) GetVector(key string) ([3]float32, error) {
+	value, exists := entity.Get(key)
+	if !exists {
+		return [3]float32{}, fmt.Errorf("Entity key %v doesn't exist", key)
+	}
+
+	parts := strings.Fields(value)
+	if len(parts) != 3 {
+		return [3]float32{}, fmt.Errorf("Entity key %v has invalid vector %q", key, value)
+	}
+
+	var vector [3]float32
+	for i := 0; i < 3; i++ {
+		component, err := strconv.ParseFloat(parts[i], 32)
+		if err != nil {
+			return [3]float32{}, fmt.Errorf("Entity key %v has invalid vector %q", key, value)
+		}
+		vector[i] = float32(component)
+	}
+	return vector, nil
+}
+
+func (entity Entity) GetInt(key string) (int, error) {
+	value, exists := entity.Get(key)
+	if !exists {
+		return 0, fmt.Errorf("Entity key %v doesn't exist", key)
+	}
+
+	number, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("Entity key %v has invalid integer %q", key, value)
+	}
+	return number, nil
+}
+
+func (entity Entity) GetFloat(key string) (float32, error) {
+	value, exists := entity.Get(key)
+	if !exists {
+		return 0, fmt.Errorf("Entity key %v doesn't exist", key)
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 32)
+	if err != nil {
+		return 0, fmt.Errorf("Entity key %v has invalid float %q", key, value)
+	}
+	return float32(number), nil
+}
+
+// Find all entities with the given classname
+func FindEntitiesByClass(entities []Entity, className string) []Entity {
+	found := make([]Entity, 0)
+	for _, entity := range entities {
+		if entity.ClassName() == className {
+			found = append(found, entity)
+		}
+	}
+	return found
+}
+
+type entityToken struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+type entityTokenizer struct {
+	text string
+	pos  int
+	line int
+}
+
+// Read the next token, either a brace or a quoted string
+// Returns nil at the end of the text
+func (tokenizer *entityTokenizer) next() (*entityToken, error) {
+	text := tokenizer.text
+
+	// Skip whitespace and // comments
+	for tokenizer.pos < len(text) {
+		c := text[tokenizer.pos]
+		if c == '\n' {
+			tokenizer.line++
+			tokenizer.pos++
+		} else if c == ' ' || c == '\t' || c == '\r' {
+			tokenizer.pos++
+		} else if c == '/' && tokenizer.pos+1 < len(text) && text[tokenizer.pos+1] == '/' {
+			for tokenizer.pos < len(text) && text[tokenizer.pos] != '\n' {
+				tokenizer.pos++
+			}
+		} else {
+			break
+		}
+	}
+
+	if tokenizer.pos >= len(text) {
+		return nil, nil
+	}
+
+	startLine := tokenizer.line
+	c := text[tokenizer.pos]
+	if c == '{' || c == '}' {
+		tokenizer.pos++
+		return &entityToken{text: string(c), line: startLine}, nil
+	}
+
+	if c != '"' {
+		return nil, fmt.Errorf("Entities: line %v: unexpected character %q", startLine, c)
+	}
+
+	// Quoted string, which can span multiple lines like in COM_Parse
+	tokenizer.pos++
+	start := tokenizer.pos
+	for tokenizer.pos < len(text) && text[tokenizer.pos] != '"' {
+		if text[tokenizer.pos] == '\n' {
+			tokenizer.line++
+		}
+		tokenizer.pos++
+	}
+	if tokenizer.pos >= len(text) {
+		return nil, fmt.Errorf("Entities: line %v: unterminated string", startLine)
+	}
+	value := text[start:tokenizer.pos]
+	tokenizer.pos++
+
+	return &entityToken{text: value, quoted: true, line: startLine}, nil
+}
+
+// Parse the entity string stored in the entities lump
+//
+//	{
+//	"classname" "worldspawn"
+//	"message" "Outer Base"
+//	}
+func ParseEntities(text string) ([]Entity, error) {
+	tokenizer := &entityTokenizer{text: text, line: 1}
+	entities := make([]Entity, 0)
+
+	for {
+		token, err := tokenizer.next()
+		if err != nil {
+			return nil, err
+		}
+		// end of text
+		if token == nil {
+			break
+		}
+		if token.quoted || token.text != "{" {
+			return nil, fmt.Errorf("Entities: line %v: expected '{', got %q", token.line, token.text)
+		}
+
+		entity := Entity{Fields: make([]EntityField, 0)}
+		for {
+			keyToken, err := tokenizer.next()
+			if err != nil {
+				return nil, err
+			}
+			if keyToken == nil {
+				return nil, fmt.Errorf("Entities: line %v: missing '}' for entity starting at line %v", tokenizer.line, token.line)
+			}
+			if !keyToken.quoted {
+				if keyToken.text == "}" {
+					break
+				}
+				return nil, fmt.Errorf("Entities: line %v: expected key, got %q", keyToken.line, keyToken.text)
+			}
+
+			valueToken, err := tokenizer.next()
+			if err != nil {
+				return nil, err
+			}
+			if valueToken == nil || !valueToken.quoted {
+				return nil, fmt.Errorf("Entities: line %v: missing value for key %q", keyToken.line, keyToken.text)
+			}
+
+			entity.Fields = append(entity.Fields, EntityField{Key: keyToken.text, Value: valueToken.text})
+		}
+
+		entities = append(entities, entity)
+	}
+
+	return entities, nil
+}
+
+// Convert entities back to the text stored in the entities lump
+func SerializeEntities(entities []Entity) string {
+	var builder strings.Builder
+	for _, entity := range entities {
+		builder.WriteString("{\n")
+		for _, field := range entity.Fields {
+			builder.WriteString(fmt.Sprintf("\"%v\" \"%v\"\n", field.Key, field.Value))
+		}
+		builder.WriteString("}\n")
+	}
+	return builder.String()
+}
+
+func loadEntities(lump Lump, r io.ReaderAt) ([]Entity, error) {
+	data, err := loadRawLump(lump, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The entity string is null terminated
+	if end := bytes.IndexByte(data, 0); end >= 0 {
+		data = data[:end]
+	}
+	entities, err := ParseEntities(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Entity count:", len(entities))
+	return entities, nil
+}
+
+// Get the inline model index from the "model" key (e.g. "*3")
+// Returns false if the entity doesn't use a brush model
+func (entity Entity) GetInlineModel() (int, bool) {
+	value, exists := entity.Get("model")
+	if !exists || !strings.HasPrefix(value, "*") {
+		return 0, false
+	}
+
+	index, err := strconv.Atoi(value[1:])
+	if err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
@@ -0,0 +1,69 @@
+package q2file
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseEntities(t *testing.T) {
+	text := "// comment before the first entity\n" +
+		"{\n" +
+		"\"classname\" \"worldspawn\"\n" +
+		"\"message\" \"Outer\nBase\"\n" +
+		"}\n" +
+		"{\n" +
+		"\"classname\" \"info_player_start\"\n" +
+		"\"origin\" \"0 -64 24\"\n" +
+		"}\n"
+
+	entities, err := ParseEntities(text)
+	if err != nil {
+		t.Fatalf("Failed to parse entities: %v", err)
+	}
+	if len(entities) != 2 {
+		t.Fatalf("Parsed %v entities, want 2", len(entities))
+	}
+	if message, _ := entities[0].Get("message"); message != "Outer\nBase" {
+		t.Errorf("message is %q, want %q", message, "Outer\nBase")
+	}
+	origin, err := entities[1].GetVector("origin")
+	if err != nil || origin != [3]float32{0, -64, 24} {
+		t.Errorf("origin is %v (%v), want [0 -64 24]", origin, err)
+	}
+
+	reparsed, err := ParseEntities(SerializeEntities(entities))
+	if err != nil {
+		t.Fatalf("Failed to parse serialized entities: %v", err)
+	}
+	if SerializeEntities(reparsed) != SerializeEntities(entities) {
+		t.Errorf("Serialized entities changed after parsing them again")
+	}
+}
+
+func TestParseEntitiesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		line int
+	}{
+		{"unterminated brace", "{\n\"classname\" \"worldspawn\"\n", 3},
+		{"unquoted key", "{\n\"classname\" \"worldspawn\"\n}\n{\nclassname \"light\"\n}\n", 5},
+		{"missing value", "{\n\"classname\"\n}\n", 2},
+		{"unterminated string", "{\n\"message\" \"Outer\nBase\n}\n", 2},
+		{"line after multi-line value", "{\n\"message\" \"Outer\nBase\"\n\"light\"\n}\n", 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseEntities(test.text)
+			if err == nil {
+				t.Fatalf("Parsed invalid entities without an error")
+			}
+			prefix := fmt.Sprintf("Entities: line %v:", test.line)
+			if !strings.HasPrefix(err.Error(), prefix) {
+				t.Errorf("Error %q doesn't start with %q", err, prefix)
+			}
+		})
+	}
+}
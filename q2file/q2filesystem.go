@@ -0,0 +1,282 @@
+package q2file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Layered search path over game directories and their PAK files, like the Quake 2 filesystem
+// Files in later game directories override files in earlier ones (e.g. a mod over baseq2)
+type FileSystem struct {
+	searchPaths []searchPath // highest priority first
+}
+
+// Either a directory of loose files or a single PAK file
+type searchPath struct {
+	directory string
+	pakName   string
+	pakReader *os.File
+	pakFiles  map[string]PakFile // lowercase filename to PAK entry
+}
+
+func NewFileSystem() *FileSystem {
+	return &FileSystem{
+		searchPaths: make([]searchPath, 0),
+	}
+}
+
+// Add a game directory on top of the existing search paths
+// The PAK files are searched from the highest number to pak0.pak and loose files override all of them
+func (fileSystem *FileSystem) AddGameDirectory(directory string) error {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Game directory %v is not a directory", directory)
+	}
+
+	pakFilenames, err := findPakFilenames(directory)
+	if err != nil {
+		return err
+	}
+
+	newSearchPaths := make([]searchPath, 0)
+	newSearchPaths = append(newSearchPaths, searchPath{directory: directory})
+	for i := len(pakFilenames) - 1; i >= 0; i-- {
+		pakPath, err := loadSearchPathPAK(pakFilenames[i])
+		if err != nil {
+			for _, path := range newSearchPaths {
+				path.close()
+			}
+			return err
+		}
+		newSearchPaths = append(newSearchPaths, pakPath)
+	}
+
+	fileSystem.searchPaths = append(newSearchPaths, fileSystem.searchPaths...)
+	return nil
+}
+
+// Add a single PAK file on top of the existing search paths
+func (fileSystem *FileSystem) AddPAK(pakFilename string) error {
+	pakPath, err := loadSearchPathPAK(pakFilename)
+	if err != nil {
+		return err
+	}
+	fileSystem.searchPaths = append([]searchPath{pakPath}, fileSystem.searchPaths...)
+	return nil
+}
+
+// Find a file in the search paths, ignoring case
+func (fileSystem *FileSystem) Open(filename string) (*io.SectionReader, error) {
+	normalized, valid := normalizeFilename(filename)
+	if !valid {
+		return nil, fmt.Errorf("File %v is outside the search path", filename)
+	}
+	filename = normalized
+	for _, path := range fileSystem.searchPaths {
+		if path.pakReader != nil {
+			pakFile, exists := path.pakFiles[filename]
+			if !exists {
+				continue
+			}
+			return io.NewSectionReader(path.pakReader, int64(pakFile.Offset), int64(pakFile.Length)), nil
+		}
+
+		diskFilename, exists := findFileIgnoreCase(path.directory, filename)
+		if !exists {
+			continue
+		}
+		// Read loose files into memory so there is no file handle to close
+		data, err := os.ReadFile(diskFilename)
+		if err != nil {
+			return nil, err
+		}
+		return io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))), nil
+	}
+	return nil, fmt.Errorf("File %v doesn't exist in the search path", filename)
+}
+
+func (fileSystem *FileSystem) ReadFile(filename string) ([]byte, error) {
+	reader, err := fileSystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, reader.Size())
+	if _, err := reader.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (fileSystem *FileSystem) Exists(filename string) bool {
+	filename, valid := normalizeFilename(filename)
+	if !valid {
+		return false
+	}
+	for _, path := range fileSystem.searchPaths {
+		if path.pakReader != nil {
+			if _, exists := path.pakFiles[filename]; exists {
+				return true
+			}
+		} else if _, exists := findFileIgnoreCase(path.directory, filename); exists {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the name of the PAK file or directory a file would be loaded from
+func (fileSystem *FileSystem) FindSource(filename string) (string, bool) {
+	filename, valid := normalizeFilename(filename)
+	if !valid {
+		return "", false
+	}
+	for _, path := range fileSystem.searchPaths {
+		if path.pakReader != nil {
+			if _, exists := path.pakFiles[filename]; exists {
+				return path.pakName, true
+			}
+		} else if _, exists := findFileIgnoreCase(path.directory, filename); exists {
+			return path.directory, true
+		}
+	}
+	return "", false
+}
+
+// Close all open PAK files
+func (fileSystem *FileSystem) Close() error {
+	var firstErr error
+	for _, path := range fileSystem.searchPaths {
+		if err := path.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	fileSystem.searchPaths = nil
+	return firstErr
+}
+
+func (path searchPath) close() error {
+	if path.pakReader == nil {
+		return nil
+	}
+	return path.pakReader.Close()
+}
+
+func loadSearchPathPAK(pakFilename string) (searchPath, error) {
+	pakReader, err := os.Open(pakFilename)
+	if err != nil {
+		return searchPath{}, err
+	}
+
+	pakFileMap, err := LoadQ2PAK(pakReader)
+	if err != nil {
+		pakReader.Close()
+		return searchPath{}, fmt.Errorf("Failed to load PAK %v: %v", pakFilename, err)
+	}
+
+	pakFiles := make(map[string]PakFile)
+	for filename, pakFile := range pakFileMap {
+		if normalized, valid := normalizeFilename(filename); valid {
+			pakFiles[normalized] = pakFile
+		}
+	}
+
+	return searchPath{
+		pakName:   pakFilename,
+		pakReader: pakReader,
+		pakFiles:  pakFiles,
+	}, nil
+}
+
+// Find pak0.pak to pakN.pak, sorted by number
+func findPakFilenames(directory string) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	pakNumbers := make(map[int]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := strings.ToLower(entry.Name())
+		if !strings.HasPrefix(name, "pak") || !strings.HasSuffix(name, ".pak") {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "pak"), ".pak"))
+		if err != nil || number < 0 {
+			continue
+		}
+		pakNumbers[number] = filepath.Join(directory, entry.Name())
+	}
+
+	var numbers []int
+	for number := range pakNumbers {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	pakFilenames := make([]string, len(numbers))
+	for i, number := range numbers {
+		pakFilenames[i] = pakNumbers[number]
+	}
+	return pakFilenames, nil
+}
+
+// Walk the path one component at a time, matching each part without case
+func findFileIgnoreCase(directory string, filename string) (string, bool) {
+	// Try the exact name first since it is the most common
+	exactFilename := filepath.Join(directory, filepath.FromSlash(filename))
+	if info, err := os.Stat(exactFilename); err == nil && !info.IsDir() {
+		return exactFilename, true
+	}
+
+	currentPath := directory
+	parts := strings.Split(filename, "/")
+	for i, part := range parts {
+		entries, err := os.ReadDir(currentPath)
+		if err != nil {
+			return "", false
+		}
+
+		found := false
+		for _, entry := range entries {
+			if !strings.EqualFold(entry.Name(), part) {
+				continue
+			}
+			// Only the last part is a file
+			isLast := i == len(parts)-1
+			if entry.IsDir() == isLast {
+				continue
+			}
+			currentPath = filepath.Join(currentPath, entry.Name())
+			found = true
+			break
+		}
+		if !found {
+			return "", false
+		}
+	}
+	return currentPath, true
+}
+
+// Paths that go up a directory could reach files outside the game directory, so they are rejected
+func normalizeFilename(filename string) (string, bool) {
+	filename = strings.ReplaceAll(filename, "\\", "/")
+	filename = strings.TrimPrefix(filename, "/")
+	for _, part := range strings.Split(filename, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+	return strings.ToLower(filename), true
+}
@@ -0,0 +1,84 @@
+package q2file
+
+import (
+	"strings"
+)
+
+const (
+	MaxLightStyles = 256
+	// Each face can blend up to 4 lightmaps, unused slots are set to LightStyleNone
+	MaxFaceLightStyles = 4
+	LightStyleNone     = uint8(255)
+	// Style strings advance one character every 0.1 seconds
+	LightStyleFrameRate = 10
+	// Styles from this number up are used by lights that can be switched on and off
+	LightStyleSwitchable = 32
+	// Light entity spawnflag for switchable lights that start off
+	lightSpawnFlagStartOff = 1
+)
+
+// Brightness patterns where 'a' is dark, 'm' is normal and 'z' is double brightness
+// Styles 0-11 are set by the game for every map
+var DefaultLightStyles = map[int]string{
+	0:  "m",                                                   // normal
+	1:  "mmnmmommommnonmmonqnmmo",                             // flicker
+	2:  "abcdefghijklmnopqrstuvwxyzyxwvutsrqponmlkjihgfedcba", // slow strong pulse
+	3:  "mmmmmaaaaammmmmaaaaaabcdefgabcdefg",                  // candle
+	4:  "mamamamamama",                                        // fast strobe
+	5:  "jklmnopqrstuvwxyzyxwvutsrqponmlkj",                   // gentle pulse
+	6:  "nmonqnmomnmomomno",                                   // flicker
+	7:  "mmmaaaabcdefgmmmmaaaammmaamm",                        // candle
+	8:  "mmmaaammmaaammmabcdefaaaammmmabcdefmmmaaaa",          // candle
+	9:  "aaaaaaaazzzzzzzz",                                    // slow strobe
+	10: "mmamammmmammamamaaamammma",                           // fluorescent flicker
+	11: "abcdefghijklmnopqrrqponmlkjihgfedcba",                // slow pulse, not fading to black
+	63: "a",                                                   // testing
+}
+
+// Get the style strings for a map, with switchable lights set to their starting state
+func LoadLightStyles(entities []Entity) [MaxLightStyles]string {
+	lightStyles := [MaxLightStyles]string{}
+	for style, pattern := range DefaultLightStyles {
+		lightStyles[style] = pattern
+	}
+
+	for _, entity := range entities {
+		if !strings.HasPrefix(entity.ClassName(), "light") {
+			continue
+		}
+		style, err := entity.GetInt("style")
+		if err != nil || style < LightStyleSwitchable || style >= MaxLightStyles {
+			continue
+		}
+
+		spawnFlags, _ := entity.GetInt("spawnflags")
+		if spawnFlags&lightSpawnFlagStartOff != 0 {
+			lightStyles[style] = "a"
+		} else {
+			lightStyles[style] = "m"
+		}
+	}
+	return lightStyles
+}
+
+// Get the brightness of a style pattern at a point in time, where 1.0 is normal brightness
+// Empty patterns are always normal brightness
+func GetLightStyleValue(pattern string, time float64) float32 {
+	if len(pattern) == 0 {
+		return 1.0
+	}
+	frame := int(time*LightStyleFrameRate) % len(pattern)
+	if frame < 0 {
+		frame += len(pattern)
+	}
+	return float32(pattern[frame]-'a') / float32('m'-'a')
+}
+
+// Evaluate every style at a point in time
+func GetLightStyleValues(lightStyles [MaxLightStyles]string, time float64) [MaxLightStyles]float32 {
+	values := [MaxLightStyles]float32{}
+	for i, pattern := range lightStyles {
+		values[i] = GetLightStyleValue(pattern, time)
+	}
+	return values
+}
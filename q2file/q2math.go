@@ -0,0 +1,12 @@
+package q2file
+
+// Interpolate between two angles in degrees, taking the shortest way around the circle
+func LerpAngle(from float32, to float32, lerp float32) float32 {
+	if to-from > 180 {
+		to -= 360
+	}
+	if to-from < -180 {
+		to += 360
+	}
+	return from + (to-from)*lerp
+}
@@ -0,0 +1,365 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+)
+
+const (
+	MD2MaxTriangles = 4096
+	MD2MaxVertices  = 2048
+	MD2MaxTexCoords = 2048
+	MD2MaxFrames    = 512
+	MD2MaxSkins     = 32
+)
+
+type MD2Header struct {
+	Magic   [4]byte // magic number ("IDP2")
+	Version uint32  // version of the MD2 format (8)
+
+	SkinWidth  int32
+	SkinHeight int32
+	FrameSize  int32 // size of each frame in bytes
+
+	NumSkins      int32
+	NumVertices   int32 // number of vertices in each frame
+	NumTexCoords  int32
+	NumTriangles  int32
+	NumGLCommands int32 // number of 32-bit values in the GL command list
+	NumFrames     int32
+
+	OffsetSkins      int32
+	OffsetTexCoords  int32
+	OffsetTriangles  int32
+	OffsetFrames     int32
+	OffsetGLCommands int32
+	OffsetEnd        int32
+}
+
+// Texture coordinates in pixels, divide by the skin size to get UV
+type MD2TexCoord struct {
+	S int16
+	T int16
+}
+
+type MD2Triangle struct {
+	VertexIndices   [3]uint16 // index in the frame vertex array
+	TexCoordIndices [3]uint16 // index in the texture coordinate array
+}
+
+// Each coordinate is scaled and translated by the frame values
+type MD2CompressedVertex struct {
+	Position    [3]uint8
+	NormalIndex uint8 // index in the MD2Normals table
+}
+
+type md2FrameHeader struct {
+	Scale     [3]float32
+	Translate [3]float32
+	Name      [16]byte
+}
+
+type MD2Frame struct {
+	Scale     [3]float32
+	Translate [3]float32
+	Name      string
+	Vertices  []MD2CompressedVertex
+}
+
+// A triangle strip or fan
+type MD2GLCommand struct {
+	IsFan    bool
+	Vertices []MD2GLVertex
+}
+
+type MD2GLVertex struct {
+	S           float32
+	T           float32
+	VertexIndex int32
+}
+
+type MD2Model struct {
+	Header     MD2Header
+	Skins      []string
+	TexCoords  []MD2TexCoord
+	Triangles  []MD2Triangle
+	Frames     []MD2Frame
+	GLCommands []MD2GLCommand
+}
+
+// Get the decompressed vertex position
+func (frame MD2Frame) GetVertex(index int) [3]float32 {
+	vertex := frame.Vertices[index]
+	return [3]float32{
+		float32(vertex.Position[0])*frame.Scale[0] + frame.Translate[0],
+		float32(vertex.Position[1])*frame.Scale[1] + frame.Translate[1],
+		float32(vertex.Position[2])*frame.Scale[2] + frame.Translate[2],
+	}
+}
+
+func (frame MD2Frame) GetNormal(index int) [3]float32 {
+	return MD2Normals[frame.Vertices[index].NormalIndex]
+}
+
+// Find the frame index by name (e.g. "stand01")
+func (model *MD2Model) FindFrame(name string) (int, bool) {
+	for i, frame := range model.Frames {
+		if frame.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func LoadQ2MD2(r io.ReaderAt) (*MD2Model, error) {
+	header := MD2Header{}
+
+	// Load header
+	headerReader := io.NewSectionReader(r, 0, int64(unsafe.Sizeof(header)))
+	if err := binary.Read(headerReader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	// Verify format
+	var magic = []byte("IDP2")
+	if !bytes.Equal(magic, header.Magic[:]) {
+		return nil, fmt.Errorf("MD2 Header: Wrong magic %v", header.Magic)
+	}
+	if header.Version != 8 {
+		return nil, fmt.Errorf("MD2 Header: Wrong version %v", header.Version)
+	}
+	if err := validateMD2Header(header); err != nil {
+		return nil, err
+	}
+
+	skins, err := loadMD2Skins(header, r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load MD2 skins")
+	}
+	texCoords, err := loadMD2TexCoords(header, r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load MD2 texture coordinates")
+	}
+	triangles, err := loadMD2Triangles(header, r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load MD2 triangles")
+	}
+	frames, err := loadMD2Frames(header, r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load MD2 frames")
+	}
+	glCommands, err := loadMD2GLCommands(header, r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load MD2 GL commands: %v", err)
+	}
+
+	for i, triangle := range triangles {
+		for j := 0; j < 3; j++ {
+			if int32(triangle.VertexIndices[j]) >= header.NumVertices {
+				return nil, fmt.Errorf("MD2 triangle %v has invalid vertex %v", i, triangle.VertexIndices[j])
+			}
+			if int32(triangle.TexCoordIndices[j]) >= header.NumTexCoords {
+				return nil, fmt.Errorf("MD2 triangle %v has invalid texture coordinate %v", i, triangle.TexCoordIndices[j])
+			}
+		}
+	}
+	for i, frame := range frames {
+		for j, vertex := range frame.Vertices {
+			if int(vertex.NormalIndex) >= len(MD2Normals) {
+				return nil, fmt.Errorf("MD2 frame %v vertex %v has invalid normal %v", i, j, vertex.NormalIndex)
+			}
+		}
+	}
+
+	return &MD2Model{
+		Header:     header,
+		Skins:      skins,
+		TexCoords:  texCoords,
+		Triangles:  triangles,
+		Frames:     frames,
+		GLCommands: glCommands,
+	}, nil
+}
+
+func validateMD2Header(header MD2Header) error {
+	if header.SkinWidth <= 0 || header.SkinHeight <= 0 {
+		return fmt.Errorf("MD2 Header: Invalid skin size %vx%v", header.SkinWidth, header.SkinHeight)
+	}
+	if header.NumSkins < 0 || header.NumSkins > MD2MaxSkins {
+		return fmt.Errorf("MD2 Header: Invalid skin count %v", header.NumSkins)
+	}
+	if header.NumVertices <= 0 || header.NumVertices > MD2MaxVertices {
+		return fmt.Errorf("MD2 Header: Invalid vertex count %v", header.NumVertices)
+	}
+	if header.NumTexCoords < 0 || header.NumTexCoords > MD2MaxTexCoords {
+		return fmt.Errorf("MD2 Header: Invalid texture coordinate count %v", header.NumTexCoords)
+	}
+	if header.NumTriangles <= 0 || header.NumTriangles > MD2MaxTriangles {
+		return fmt.Errorf("MD2 Header: Invalid triangle count %v", header.NumTriangles)
+	}
+	if header.NumFrames <= 0 || header.NumFrames > MD2MaxFrames {
+		return fmt.Errorf("MD2 Header: Invalid frame count %v", header.NumFrames)
+	}
+	if header.NumGLCommands < 0 {
+		return fmt.Errorf("MD2 Header: Invalid GL command count %v", header.NumGLCommands)
+	}
+
+	// Frame header is 40 bytes followed by 4 bytes per vertex
+	if header.FrameSize != 40+4*header.NumVertices {
+		return fmt.Errorf("MD2 Header: Frame size %v doesn't match vertex count %v", header.FrameSize, header.NumVertices)
+	}
+
+	offsets := []int32{
+		header.OffsetSkins, header.OffsetTexCoords, header.OffsetTriangles,
+		header.OffsetFrames, header.OffsetGLCommands, header.OffsetEnd,
+	}
+	for _, offset := range offsets {
+		if offset < 0 || offset > header.OffsetEnd {
+			return fmt.Errorf("MD2 Header: Invalid offset %v", offset)
+		}
+	}
+
+	// The GL commands have to fit before the end of the file
+	if int64(header.OffsetGLCommands)+int64(header.NumGLCommands)*4 > int64(header.OffsetEnd) {
+		return fmt.Errorf("MD2 Header: GL command count %v goes past the end of the file", header.NumGLCommands)
+	}
+	return nil
+}
+
+func loadMD2Skins(header MD2Header, r io.ReaderAt) ([]string, error) {
+	// Each skin name is 64 bytes
+	reader := io.NewSectionReader(r, int64(header.OffsetSkins), int64(header.NumSkins)*64)
+	skins := make([]string, header.NumSkins)
+	for i := 0; i < int(header.NumSkins); i++ {
+		skinName := [64]byte{}
+		if err := binary.Read(reader, binary.LittleEndian, &skinName); err != nil {
+			return nil, err
+		}
+		skins[i] = byteToString(skinName[:])
+	}
+	return skins, nil
+}
+
+func loadMD2TexCoords(header MD2Header, r io.ReaderAt) ([]MD2TexCoord, error) {
+	// Each texture coordinate is 4 bytes
+	reader := io.NewSectionReader(r, int64(header.OffsetTexCoords), int64(header.NumTexCoords)*4)
+	data := make([]MD2TexCoord, header.NumTexCoords)
+	if err := binary.Read(reader, binary.LittleEndian, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func loadMD2Triangles(header MD2Header, r io.ReaderAt) ([]MD2Triangle, error) {
+	// Each triangle is 12 bytes
+	reader := io.NewSectionReader(r, int64(header.OffsetTriangles), int64(header.NumTriangles)*12)
+	data := make([]MD2Triangle, header.NumTriangles)
+	if err := binary.Read(reader, binary.LittleEndian, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func loadMD2Frames(header MD2Header, r io.ReaderAt) ([]MD2Frame, error) {
+	frames := make([]MD2Frame, header.NumFrames)
+	for i := 0; i < int(header.NumFrames); i++ {
+		frameOffset := int64(header.OffsetFrames) + int64(i)*int64(header.FrameSize)
+		reader := io.NewSectionReader(r, frameOffset, int64(header.FrameSize))
+
+		frameHeader := md2FrameHeader{}
+		if err := binary.Read(reader, binary.LittleEndian, &frameHeader); err != nil {
+			return nil, err
+		}
+
+		vertices := make([]MD2CompressedVertex, header.NumVertices)
+		if err := binary.Read(reader, binary.LittleEndian, vertices); err != nil {
+			return nil, err
+		}
+
+		frames[i] = MD2Frame{
+			Scale:     frameHeader.Scale,
+			Translate: frameHeader.Translate,
+			Name:      byteToString(frameHeader.Name[:]),
+			Vertices:  vertices,
+		}
+	}
+	return frames, nil
+}
+
+// The command list is a sequence of strips and fans, ending with 0
+// A positive count is a strip and a negative count is a fan
+// Commands are read one at a time so a bad header count can't force a huge allocation
+func loadMD2GLCommands(header MD2Header, r io.ReaderAt) ([]MD2GLCommand, error) {
+	reader := io.NewSectionReader(r, int64(header.OffsetGLCommands), int64(header.NumGLCommands)*4)
+
+	commands := make([]MD2GLCommand, 0)
+	for {
+		var count int32
+		if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+			// The header count may include the terminator or leave it out
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		// end of list
+		if count == 0 {
+			break
+		}
+
+		isFan := count < 0
+		if isFan {
+			count = -count
+		}
+		command := MD2GLCommand{
+			IsFan:    isFan,
+			Vertices: make([]MD2GLVertex, 0),
+		}
+		for j := 0; j < int(count); j++ {
+			// Each vertex is 3 values (s, t, vertex index)
+			var values [3]int32
+			if err := binary.Read(reader, binary.LittleEndian, &values); err != nil {
+				return nil, fmt.Errorf("command %v has %v vertices past the end of the list", len(commands), count)
+			}
+			vertex := MD2GLVertex{
+				S:           math.Float32frombits(uint32(values[0])),
+				T:           math.Float32frombits(uint32(values[1])),
+				VertexIndex: values[2],
+			}
+			if vertex.VertexIndex < 0 || vertex.VertexIndex >= header.NumVertices {
+				return nil, fmt.Errorf("command %v has invalid vertex %v", len(commands), vertex.VertexIndex)
+			}
+			command.Vertices = append(command.Vertices, vertex)
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
+
+// Find the animation that contains a frame
+// Frames in the same animation share a name without the trailing number (e.g. "stand01", "stand02")
+func (model *MD2Model) FindAnimation(frameIndex int) (int, int) {
+	prefix := getFrameNamePrefix(model.Frames[frameIndex].Name)
+
+	first := frameIndex
+	for first > 0 && getFrameNamePrefix(model.Frames[first-1].Name) == prefix {
+		first--
+	}
+	last := frameIndex
+	for last+1 < len(model.Frames) && getFrameNamePrefix(model.Frames[last+1].Name) == prefix {
+		last++
+	}
+	return first, last - first + 1
+}
+
+func getFrameNamePrefix(name string) string {
+	end := len(name)
+	for end > 0 && name[end-1] >= '0' && name[end-1] <= '9' {
+		end--
+	}
+	return name[:end]
+}
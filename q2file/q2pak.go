@@ -64,14 +64,24 @@ func LoadQ2BSPFromPAK(pakReader io.ReaderAt, pakFileMap map[string]PakFile, bspF
 	return LoadQ2BSP(bspReader)
 }
 
-func LoadQ2WALFromPAK(pakReader io.ReaderAt, pakFileMap map[string]PakFile, textureFilename string) ([]uint8, WalHeader, error) {
+// Deprecated: the palette is loaded again for every texture.
+// Use FileSystem.Open with LoadPaletteFromPCX once and LoadQ2WALWithPalette for each texture instead.
+func LoadQ2WALFromPAK(pakReader io.ReaderAt, pakFileMap map[string]PakFile, textureFilename string) ([][]uint8, WalHeader, error) {
 	_, exists := pakFileMap[textureFilename]
 	if !exists {
 		return nil, WalHeader{}, fmt.Errorf("Texture filename %v doesn't exist in PAK", textureFilename)
 	}
 
+	palette := DefaultPalette()
+	if paletteFile, exists := pakFileMap[PaletteFilename]; exists {
+		pcxReader := io.NewSectionReader(pakReader, int64(paletteFile.Offset), int64(paletteFile.Length))
+		if pakPalette, err := LoadPaletteFromPCX(pcxReader); err == nil {
+			palette = pakPalette
+		}
+	}
+
 	walReader := io.NewSectionReader(pakReader, int64(pakFileMap[textureFilename].Offset), int64(pakFileMap[textureFilename].Length))
-	return LoadQ2WAL(walReader)
+	return LoadQ2WALWithPalette(walReader, palette)
 }
 
 func byteToString(byteArr []byte) string {
@@ -0,0 +1,196 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// Collects files and writes them out as a new PAK archive
+type PakBuilder struct {
+	files []pakBuilderFile
+}
+
+type pakBuilderFile struct {
+	filename string
+	reader   io.ReaderAt
+	length   int64
+}
+
+func NewPakBuilder() *PakBuilder {
+	return &PakBuilder{
+		files: make([]pakBuilderFile, 0),
+	}
+}
+
+// Start with all the files in an existing PAK, which can then be added, replaced or removed
+func NewPakBuilderFromPAK(pakReader io.ReaderAt, pakFileMap map[string]PakFile) *PakBuilder {
+	builder := NewPakBuilder()
+
+	// Keep the files in the same order as the original archive
+	var filenames []string
+	for filename := range pakFileMap {
+		filenames = append(filenames, filename)
+	}
+	// Empty files share the offset of the next file, so they go first
+	sort.Slice(filenames, func(i, j int) bool {
+		fileI := pakFileMap[filenames[i]]
+		fileJ := pakFileMap[filenames[j]]
+		if fileI.Offset != fileJ.Offset {
+			return fileI.Offset < fileJ.Offset
+		}
+		if (fileI.Length == 0) != (fileJ.Length == 0) {
+			return fileI.Length == 0
+		}
+		return filenames[i] < filenames[j]
+	})
+
+	for _, filename := range filenames {
+		pakFile := pakFileMap[filename]
+		builder.files = append(builder.files, pakBuilderFile{
+			filename: filename,
+			reader:   io.NewSectionReader(pakReader, int64(pakFile.Offset), int64(pakFile.Length)),
+			length:   int64(pakFile.Length),
+		})
+	}
+	return builder
+}
+
+// Add a file stored in memory, replacing any file with the same name
+func (builder *PakBuilder) AddFile(filename string, data []byte) error {
+	return builder.AddFileFromReader(filename, bytes.NewReader(data), int64(len(data)))
+}
+
+// Add a file from disk, replacing any file with the same name
+func (builder *PakBuilder) AddFileFromDisk(filename string, diskFilename string) error {
+	data, err := os.ReadFile(diskFilename)
+	if err != nil {
+		return err
+	}
+	return builder.AddFile(filename, data)
+}
+
+func (builder *PakBuilder) AddFileFromReader(filename string, r io.ReaderAt, length int64) error {
+	filename = strings.ReplaceAll(filename, "\\", "/")
+	if err := validatePakFilename(filename); err != nil {
+		return err
+	}
+	if length < 0 || length > int64(^uint32(0)) {
+		return fmt.Errorf("PAK file %v has invalid length %v", filename, length)
+	}
+
+	newFile := pakBuilderFile{
+		filename: filename,
+		reader:   r,
+		length:   length,
+	}
+
+	index := builder.findFile(filename)
+	if index >= 0 {
+		builder.files[index] = newFile
+	} else {
+		builder.files = append(builder.files, newFile)
+	}
+	return nil
+}
+
+func (builder *PakBuilder) RemoveFile(filename string) error {
+	index := builder.findFile(filename)
+	if index < 0 {
+		return fmt.Errorf("PAK file %v doesn't exist", filename)
+	}
+	builder.files = append(builder.files[:index], builder.files[index+1:]...)
+	return nil
+}
+
+func (builder *PakBuilder) HasFile(filename string) bool {
+	return builder.findFile(filename) >= 0
+}
+
+func (builder *PakBuilder) Filenames() []string {
+	filenames := make([]string, len(builder.files))
+	for i, file := range builder.files {
+		filenames[i] = file.filename
+	}
+	return filenames
+}
+
+// Write the header, then the contents of every file, then the directory
+func (builder *PakBuilder) Write(w io.WriteSeeker) error {
+	pakHeader := PakHeader{}
+	copy(pakHeader.Magic[:], "PACK")
+
+	headerSize := int64(unsafe.Sizeof(pakHeader))
+	if _, err := w.Seek(headerSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	directory := make([]PakFile, len(builder.files))
+	offset := headerSize
+	for i, file := range builder.files {
+		if offset+file.length > int64(^uint32(0)) {
+			return fmt.Errorf("PAK file is too large to add %v", file.filename)
+		}
+
+		written, err := io.Copy(w, io.NewSectionReader(file.reader, 0, file.length))
+		if err != nil {
+			return err
+		}
+		if written != file.length {
+			return fmt.Errorf("PAK file %v is shorter than expected", file.filename)
+		}
+
+		pakFile := PakFile{
+			Offset: uint32(offset),
+			Length: uint32(file.length),
+		}
+		copy(pakFile.Filename[:], file.filename)
+		directory[i] = pakFile
+
+		offset += file.length
+	}
+
+	// Each PakFile is 64 bytes
+	pakHeader.Offset = uint32(offset)
+	pakHeader.Length = uint32(len(directory) * 64)
+	if err := binary.Write(w, binary.LittleEndian, directory); err != nil {
+		return err
+	}
+
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, &pakHeader); err != nil {
+		return err
+	}
+	_, err := w.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (builder *PakBuilder) findFile(filename string) int {
+	for i, file := range builder.files {
+		if file.filename == filename {
+			return i
+		}
+	}
+	return -1
+}
+
+func validatePakFilename(filename string) error {
+	if len(filename) == 0 {
+		return fmt.Errorf("PAK filename can't be empty")
+	}
+	// Filename is stored in a 56 byte array
+	if len(filename) > 56 {
+		return fmt.Errorf("PAK filename %v is longer than 56 bytes", filename)
+	}
+	if strings.IndexByte(filename, 0) >= 0 {
+		return fmt.Errorf("PAK filename %q contains a null byte", filename)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package q2file
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func writeTestPAK(t *testing.T, builder *PakBuilder) []byte {
+	t.Helper()
+	return writeTestFile(t, "test.pak", func(file *os.File) error {
+		return builder.Write(file)
+	})
+}
+
+func TestPakBuilderRoundTrip(t *testing.T) {
+	bspData := writeTestBSP(t, newTestMapData(false))
+	qbspData := writeTestBSP(t, newTestMapData(true))
+
+	tests := []struct {
+		name     string
+		addName  string // name given to the builder
+		filename string // name stored in the PAK
+		data     []byte
+	}{
+		{"IBSP map", "maps/test.bsp", "maps/test.bsp", bspData},
+		{"QBSP map", "maps/test_qbsp.bsp", "maps/test_qbsp.bsp", qbspData},
+		{"text file", "scripts/test.txt", "scripts/test.txt", []byte("text with an odd length")},
+		{"empty file", "empty.dat", "empty.dat", []byte{}},
+		{"backslashes", "sound\\test.wav", "sound/test.wav", []byte{1, 2, 3, 4}},
+	}
+
+	builder := NewPakBuilder()
+	for _, test := range tests {
+		if err := builder.AddFile(test.addName, test.data); err != nil {
+			t.Fatalf("Failed to add %v: %v", test.addName, err)
+		}
+	}
+	pakData := writeTestPAK(t, builder)
+
+	pakReader := bytes.NewReader(pakData)
+	pakFileMap, err := LoadQ2PAK(pakReader)
+	if err != nil {
+		t.Fatalf("Failed to load written PAK: %v", err)
+	}
+	if len(pakFileMap) != len(tests) {
+		t.Errorf("PAK has %v files, want %v", len(pakFileMap), len(tests))
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pakFile, exists := pakFileMap[test.filename]
+			if !exists {
+				t.Fatalf("%v is missing from the PAK", test.filename)
+			}
+			data := pakData[pakFile.Offset : pakFile.Offset+pakFile.Length]
+			if !bytes.Equal(data, test.data) {
+				t.Errorf("%v has %v bytes that don't match the %v bytes added", test.filename, len(data), len(test.data))
+			}
+		})
+	}
+
+	// The maps load the same from the PAK as they were written
+	mapTests := []struct {
+		filename string
+		isQBSP   bool
+	}{
+		{"maps/test.bsp", false},
+		{"maps/test_qbsp.bsp", true},
+	}
+	for _, mapTest := range mapTests {
+		mapData, err := LoadQ2BSPFromPAK(pakReader, pakFileMap, mapTest.filename)
+		if err != nil {
+			t.Fatalf("Failed to load %v from PAK: %v", mapTest.filename, err)
+		}
+		checkMapData(t, mapData, newTestMapData(mapTest.isQBSP))
+	}
+
+	// Rebuilding the PAK from itself keeps the files in the same order
+	rebuiltData := writeTestPAK(t, NewPakBuilderFromPAK(pakReader, pakFileMap))
+	if !bytes.Equal(rebuiltData, pakData) {
+		t.Errorf("Rebuilt PAK is %v bytes that don't match the original %v bytes", len(rebuiltData), len(pakData))
+	}
+}
+
+func TestPakBuilderReplaceAndRemove(t *testing.T) {
+	builder := NewPakBuilder()
+	files := []struct {
+		filename string
+		data     []byte
+	}{
+		{"a.txt", []byte("first")},
+		{"b.txt", []byte("second")},
+		{"a.txt", []byte("replaced")},
+	}
+	for _, file := range files {
+		if err := builder.AddFile(file.filename, file.data); err != nil {
+			t.Fatalf("Failed to add %v: %v", file.filename, err)
+		}
+	}
+	if err := builder.RemoveFile("b.txt"); err != nil {
+		t.Fatalf("Failed to remove b.txt: %v", err)
+	}
+	if err := builder.RemoveFile("missing.txt"); err == nil {
+		t.Errorf("Removed a file that was never added")
+	}
+
+	pakData := writeTestPAK(t, builder)
+	pakFileMap, err := LoadQ2PAK(bytes.NewReader(pakData))
+	if err != nil {
+		t.Fatalf("Failed to load written PAK: %v", err)
+	}
+	if len(pakFileMap) != 1 {
+		t.Fatalf("PAK has %v files, want 1", len(pakFileMap))
+	}
+	pakFile := pakFileMap["a.txt"]
+	if data := pakData[pakFile.Offset : pakFile.Offset+pakFile.Length]; string(data) != "replaced" {
+		t.Errorf("a.txt = %q, want %q", data, "replaced")
+	}
+}
+
+func TestPakBuilderInvalidFilenames(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+	}{
+		{"empty", ""},
+		{"too long", "maps/" + string(bytes.Repeat([]byte("a"), 52))},
+		{"null byte", "maps/a\x00.bsp"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := NewPakBuilder().AddFile(test.filename, []byte{1}); err == nil {
+				t.Errorf("Added file with invalid name %q", test.filename)
+			}
+		})
+	}
+}
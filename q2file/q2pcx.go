@@ -0,0 +1,172 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// 256 colors with r, g, b values
+type Palette [256][3]uint8
+
+type PcxHeader struct {
+	Manufacturer uint8 // always 10
+	Version      uint8
+	Encoding     uint8 // 1 for run length encoding
+	BitsPerPixel uint8
+
+	XMin uint16
+	YMin uint16
+	XMax uint16
+	YMax uint16
+
+	HorizontalDPI uint16
+	VerticalDPI   uint16
+	EgaPalette    [48]uint8
+	Reserved      uint8
+
+	ColorPlanes  uint8  // 1 for paletted images, 3 for 24-bit images
+	BytesPerLine uint16 // bytes in each color plane of a scanline
+	PaletteType  uint16
+	Filler       [58]uint8
+}
+
+// Convert the palette to a color palette for image.Paletted
+func (palette *Palette) ColorPalette() color.Palette {
+	colors := make(color.Palette, len(palette))
+	for i, rgb := range palette {
+		colors[i] = color.RGBA{rgb[0], rgb[1], rgb[2], 255}
+	}
+	return colors
+}
+
+// Load an 8-bit paletted or 24-bit PCX image
+// The palette is nil for 24-bit images
+func LoadQ2PCX(r io.ReaderAt) (image.Image, *Palette, error) {
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := PcxHeader{}
+	headerSize := int(unsafe.Sizeof(header))
+	if len(data) < headerSize {
+		return nil, nil, fmt.Errorf("PCX Header: File is too small")
+	}
+	if err := binary.Read(bytes.NewReader(data[:headerSize]), binary.LittleEndian, &header); err != nil {
+		return nil, nil, err
+	}
+
+	// Verify format
+	if header.Manufacturer != 10 {
+		return nil, nil, fmt.Errorf("PCX Header: Wrong manufacturer %v", header.Manufacturer)
+	}
+	if header.Encoding != 1 {
+		return nil, nil, fmt.Errorf("PCX Header: Unsupported encoding %v", header.Encoding)
+	}
+	if header.BitsPerPixel != 8 || (header.ColorPlanes != 1 && header.ColorPlanes != 3) {
+		return nil, nil, fmt.Errorf("PCX Header: Unsupported format with %v bits per pixel and %v planes",
+			header.BitsPerPixel, header.ColorPlanes)
+	}
+	if header.XMax < header.XMin || header.YMax < header.YMin {
+		return nil, nil, fmt.Errorf("PCX Header: Invalid dimensions")
+	}
+
+	width := int(header.XMax-header.XMin) + 1
+	height := int(header.YMax-header.YMin) + 1
+	if int(header.BytesPerLine) < width {
+		return nil, nil, fmt.Errorf("PCX Header: Bytes per line %v is less than width %v", header.BytesPerLine, width)
+	}
+
+	// Paletted images store the palette in the last 769 bytes
+	encodedData := data[headerSize:]
+	var palette *Palette
+	if header.ColorPlanes == 1 {
+		if len(encodedData) < 769 || encodedData[len(encodedData)-769] != 0x0C {
+			return nil, nil, fmt.Errorf("PCX file is missing the 256 color palette")
+		}
+		palette = &Palette{}
+		paletteData := encodedData[len(encodedData)-768:]
+		for i := 0; i < 256; i++ {
+			palette[i] = [3]uint8{paletteData[i*3], paletteData[i*3+1], paletteData[i*3+2]}
+		}
+		encodedData = encodedData[:len(encodedData)-769]
+	}
+
+	scanlineSize := int(header.BytesPerLine) * int(header.ColorPlanes)
+	pixels, err := decodePCXRunLength(encodedData, scanlineSize*height)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bounds := image.Rect(0, 0, width, height)
+	if palette != nil {
+		newImage := image.NewPaletted(bounds, palette.ColorPalette())
+		for y := 0; y < height; y++ {
+			copy(newImage.Pix[y*newImage.Stride:y*newImage.Stride+width], pixels[y*scanlineSize:])
+		}
+		return newImage, palette, nil
+	}
+
+	// Each scanline has a red, green and blue plane
+	newImage := image.NewRGBA(bounds)
+	bytesPerLine := int(header.BytesPerLine)
+	for y := 0; y < height; y++ {
+		scanline := pixels[y*scanlineSize:]
+		for x := 0; x < width; x++ {
+			offset := y*newImage.Stride + x*4
+			newImage.Pix[offset+0] = scanline[x]
+			newImage.Pix[offset+1] = scanline[bytesPerLine+x]
+			newImage.Pix[offset+2] = scanline[bytesPerLine*2+x]
+			newImage.Pix[offset+3] = 255
+		}
+	}
+	return newImage, nil, nil
+}
+
+// Load only the palette, which is how colormap.pcx is used
+func LoadPaletteFromPCX(r io.ReaderAt) (Palette, error) {
+	_, palette, err := LoadQ2PCX(r)
+	if err != nil {
+		return Palette{}, err
+	}
+	if palette == nil {
+		return Palette{}, fmt.Errorf("PCX file doesn't have a palette")
+	}
+	return *palette, nil
+}
+
+func decodePCXRunLength(encodedData []uint8, size int) ([]uint8, error) {
+	pixels := make([]uint8, size)
+	readIndex := 0
+	writeIndex := 0
+	for writeIndex < size {
+		if readIndex >= len(encodedData) {
+			return nil, fmt.Errorf("PCX image data ended early")
+		}
+		value := encodedData[readIndex]
+		readIndex++
+
+		// The top two bits are set for a run of the next byte
+		runLength := 1
+		if value&0xC0 == 0xC0 {
+			runLength = int(value & 0x3F)
+			if readIndex >= len(encodedData) {
+				return nil, fmt.Errorf("PCX image data ended early")
+			}
+			value = encodedData[readIndex]
+			readIndex++
+		}
+
+		for i := 0; i < runLength && writeIndex < size; i++ {
+			pixels[writeIndex] = value
+			writeIndex++
+		}
+	}
+	return pixels, nil
+}
@@ -0,0 +1,90 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+const (
+	SP2MaxFrames = 32
+)
+
+type SP2Header struct {
+	Magic     [4]byte // magic number ("IDS2")
+	Version   uint32  // version of the SP2 format (2)
+	NumFrames int32
+}
+
+type sp2FrameData struct {
+	Width    int32
+	Height   int32
+	OriginX  int32
+	OriginY  int32
+	SkinName [64]byte
+}
+
+// Each frame is a PCX image drawn facing the camera
+type SP2Frame struct {
+	Width    int32
+	Height   int32
+	OriginX  int32 // offset of the sprite origin from the left of the image
+	OriginY  int32 // offset of the sprite origin from the bottom of the image
+	SkinName string
+}
+
+type SP2Sprite struct {
+	Header SP2Header
+	Frames []SP2Frame
+}
+
+func LoadQ2SP2(r io.ReaderAt) (*SP2Sprite, error) {
+	header := SP2Header{}
+
+	// Load header
+	headerSize := int64(unsafe.Sizeof(header))
+	headerReader := io.NewSectionReader(r, 0, headerSize)
+	if err := binary.Read(headerReader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	// Verify format
+	var magic = []byte("IDS2")
+	if !bytes.Equal(magic, header.Magic[:]) {
+		return nil, fmt.Errorf("SP2 Header: Wrong magic %v", header.Magic)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("SP2 Header: Wrong version %v", header.Version)
+	}
+	if header.NumFrames <= 0 || header.NumFrames > SP2MaxFrames {
+		return nil, fmt.Errorf("SP2 Header: Invalid frame count %v", header.NumFrames)
+	}
+
+	// Each frame is 80 bytes
+	frames := make([]SP2Frame, header.NumFrames)
+	frameReader := io.NewSectionReader(r, headerSize, int64(header.NumFrames)*80)
+	for i := 0; i < int(header.NumFrames); i++ {
+		frameData := sp2FrameData{}
+		if err := binary.Read(frameReader, binary.LittleEndian, &frameData); err != nil {
+			return nil, fmt.Errorf("Failed to load SP2 frame %v", i)
+		}
+		if frameData.Width <= 0 || frameData.Height <= 0 {
+			return nil, fmt.Errorf("SP2 frame %v has invalid size %vx%v", i, frameData.Width, frameData.Height)
+		}
+
+		frames[i] = SP2Frame{
+			Width:    frameData.Width,
+			Height:   frameData.Height,
+			OriginX:  frameData.OriginX,
+			OriginY:  frameData.OriginY,
+			SkinName: byteToString(frameData.SkinName[:]),
+		}
+	}
+
+	return &SP2Sprite{
+		Header: header,
+		Frames: frames,
+	}, nil
+}
@@ -0,0 +1,146 @@
+package q2file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	tgaTypeTrueColor    = 2
+	tgaTypeGrayscale    = 3
+	tgaTypeTrueColorRLE = 10
+	tgaTypeGrayscaleRLE = 11
+
+	// Set in the descriptor if the first row is the top of the image
+	tgaDescriptorTopOrigin = 0x20
+)
+
+type TgaHeader struct {
+	IDLength        uint8
+	ColorMapType    uint8
+	ImageType       uint8
+	ColorMapStart   uint16
+	ColorMapLength  uint16
+	ColorMapDepth   uint8
+	XOrigin         uint16
+	YOrigin         uint16
+	Width           uint16
+	Height          uint16
+	PixelDepth      uint8
+	ImageDescriptor uint8
+}
+
+// Load a truecolor or grayscale TGA image, with or without run length encoding
+// Quake 2 only uses 24-bit and 32-bit images, which are stored as BGR(A)
+func LoadQ2TGA(r io.Reader) (image.Image, error) {
+	reader := bufio.NewReader(r)
+
+	header := TgaHeader{}
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	isRunLength := false
+	switch header.ImageType {
+	case tgaTypeTrueColor, tgaTypeGrayscale:
+	case tgaTypeTrueColorRLE, tgaTypeGrayscaleRLE:
+		isRunLength = true
+	default:
+		return nil, fmt.Errorf("TGA Header: Unsupported image type %v", header.ImageType)
+	}
+
+	isGrayscale := header.ImageType == tgaTypeGrayscale || header.ImageType == tgaTypeGrayscaleRLE
+	bytesPerPixel := int(header.PixelDepth / 8)
+	if isGrayscale && header.PixelDepth != 8 {
+		return nil, fmt.Errorf("TGA Header: Unsupported grayscale depth %v", header.PixelDepth)
+	}
+	if !isGrayscale && header.PixelDepth != 24 && header.PixelDepth != 32 {
+		return nil, fmt.Errorf("TGA Header: Unsupported pixel depth %v", header.PixelDepth)
+	}
+	if header.Width == 0 || header.Height == 0 {
+		return nil, fmt.Errorf("TGA Header: Invalid size %vx%v", header.Width, header.Height)
+	}
+
+	// Skip the image id and color map, which truecolor images don't need
+	skipLength := int64(header.IDLength)
+	if header.ColorMapType != 0 {
+		skipLength += int64(header.ColorMapLength) * int64((header.ColorMapDepth+7)/8)
+	}
+	if _, err := io.CopyN(io.Discard, reader, skipLength); err != nil {
+		return nil, fmt.Errorf("TGA image is truncated")
+	}
+
+	width := int(header.Width)
+	height := int(header.Height)
+	pixels := make([]uint8, width*height*bytesPerPixel)
+	var err error
+	if isRunLength {
+		err = decodeTGARunLength(reader, pixels, bytesPerPixel)
+	} else {
+		_, err = io.ReadFull(reader, pixels)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("TGA image is truncated")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	topOrigin := header.ImageDescriptor&tgaDescriptorTopOrigin != 0
+	for y := 0; y < height; y++ {
+		// Rows are stored from the bottom up unless the descriptor says otherwise
+		row := height - 1 - y
+		if topOrigin {
+			row = y
+		}
+		for x := 0; x < width; x++ {
+			pixel := pixels[(row*width+x)*bytesPerPixel:]
+			var rgba color.RGBA
+			switch bytesPerPixel {
+			case 1:
+				rgba = color.RGBA{pixel[0], pixel[0], pixel[0], 255}
+			case 3:
+				rgba = color.RGBA{pixel[2], pixel[1], pixel[0], 255}
+			case 4:
+				rgba = color.RGBA{pixel[2], pixel[1], pixel[0], pixel[3]}
+			}
+			img.SetRGBA(x, y, rgba)
+		}
+	}
+	return img, nil
+}
+
+// Each packet is either a run of one repeated pixel or a list of raw pixels
+// Packets can cross row boundaries
+func decodeTGARunLength(reader *bufio.Reader, pixels []uint8, bytesPerPixel int) error {
+	pixel := make([]uint8, bytesPerPixel)
+	for offset := 0; offset < len(pixels); {
+		packetHeader, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		count := int(packetHeader&0x7F) + 1
+		if offset+count*bytesPerPixel > len(pixels) {
+			return fmt.Errorf("TGA run length packet overflows the image")
+		}
+
+		if packetHeader&0x80 != 0 {
+			if _, err := io.ReadFull(reader, pixel); err != nil {
+				return err
+			}
+			for i := 0; i < count; i++ {
+				copy(pixels[offset:], pixel)
+				offset += bytesPerPixel
+			}
+		} else {
+			if _, err := io.ReadFull(reader, pixels[offset:offset+count*bytesPerPixel]); err != nil {
+				return err
+			}
+			offset += count * bytesPerPixel
+		}
+	}
+	return nil
+}
@@ -7,8 +7,8 @@ import (
 	"unsafe"
 )
 
-// usually stored in .pcx file
-var palette = [256][3]uint8{
+// Default Quake 2 palette, used when pics/colormap.pcx isn't available
+var defaultPalette = Palette{
 	{0, 0, 0}, {15, 15, 15}, {31, 31, 31}, {47, 47, 47}, {63, 63, 63}, {75, 75, 75}, {91, 91, 91}, {107, 107, 107}, {123, 123, 123}, {139, 139, 139}, {155, 155, 155}, {171, 171, 171}, {187, 187, 187}, {203, 203, 203}, {219, 219, 219}, {235, 235, 235}, {99, 75, 35}, {91, 67, 31}, {83, 63, 31}, {79, 59, 27}, {71, 55, 27}, {63, 47, 23}, {59, 43, 23}, {51, 39, 19}, {47, 35, 19}, {43, 31, 19}, {39, 27, 15}, {35, 23, 15}, {27, 19, 11}, {23, 15, 11}, {19, 15, 7}, {15, 11, 7},
 	{95, 95, 111}, {91, 91, 103}, {91, 83, 95}, {87, 79, 91}, {83, 75, 83}, {79, 71, 75}, {71, 63, 67}, {63, 59, 59}, {59, 55, 55}, {51, 47, 47}, {47, 43, 43}, {39, 39, 39}, {35, 35, 35}, {27, 27, 27}, {23, 23, 23}, {19, 19, 19}, {143, 119, 83}, {123, 99, 67}, {115, 91, 59}, {103, 79, 47}, {207, 151, 75}, {167, 123, 59}, {139, 103, 47}, {111, 83, 39}, {235, 159, 39}, {203, 139, 35}, {175, 119, 31}, {147, 99, 27}, {119, 79, 23}, {91, 59, 15}, {63, 39, 11}, {35, 23, 7},
 	{167, 59, 43}, {159, 47, 35}, {151, 43, 27}, {139, 39, 19}, {127, 31, 15}, {115, 23, 11}, {103, 23, 7}, {87, 19, 0}, {75, 15, 0}, {67, 15, 0}, {59, 15, 0}, {51, 11, 0}, {43, 11, 0}, {35, 11, 0}, {27, 7, 0}, {19, 7, 0}, {123, 95, 75}, {115, 87, 67}, {107, 83, 63}, {103, 79, 59}, {95, 71, 55}, {87, 67, 51}, {83, 63, 47}, {75, 55, 43}, {67, 51, 39}, {63, 47, 35}, {55, 39, 27}, {47, 35, 23}, {39, 27, 19}, {31, 23, 15}, {23, 15, 11}, {15, 11, 7},
@@ -32,44 +32,88 @@ type WalHeader struct {
 	Value    uint32
 }
 
-// Load image file
-func LoadQ2WAL(r io.ReaderAt) ([]uint8, WalHeader, error) {
-	// Read the header
+const PaletteFilename = "pics/colormap.pcx"
+
+func DefaultPalette() Palette {
+	return defaultPalette
+}
+
+// Number of mip levels stored in a WAL file
+const WalMipLevels = 4
+
+// Load image file using the default palette
+// Returns the rgb values for each mip level, starting with the full size image
+func LoadQ2WAL(r io.ReaderAt) ([][]uint8, WalHeader, error) {
+	return LoadQ2WALWithPalette(r, defaultPalette)
+}
+
+// Load image file, converting each palette index to rgb
+func LoadQ2WALWithPalette(r io.ReaderAt, palette Palette) ([][]uint8, WalHeader, error) {
+	walData, err := LoadQ2WALHeader(r)
+	if err != nil {
+		return nil, WalHeader{}, err
+	}
+
+	// Load image pixel values for every mip level
+	mipLevels := make([][]uint8, WalMipLevels)
+	for level := 0; level < WalMipLevels; level++ {
+		image, err := loadImage(walData, level, r, palette)
+		if err != nil {
+			return nil, WalHeader{}, fmt.Errorf("Failed to load image for mip level %v", level)
+		}
+		mipLevels[level] = image
+	}
+
+	return mipLevels, walData, nil
+}
+
+// Read only the header, for the texture size and surface flags
+func LoadQ2WALHeader(r io.ReaderAt) (WalHeader, error) {
 	walData := WalHeader{}
 	reader := io.NewSectionReader(r, int64(0), int64(unsafe.Sizeof(walData)))
 	if err := binary.Read(reader, binary.LittleEndian, &walData); err != nil {
-		return nil, WalHeader{}, err
+		return WalHeader{}, err
 	}
+	return walData, nil
+}
 
-	// Load image pixel values
-	image, err := loadImage(walData, r)
-	if err != nil {
-		return nil, WalHeader{}, fmt.Errorf("Failed to load image")
+// Each mip level is half the width and height of the previous level
+func GetWALMipSize(walData WalHeader, level int) (uint32, uint32) {
+	width := walData.Width >> uint32(level)
+	height := walData.Height >> uint32(level)
+	if width == 0 {
+		width = 1
 	}
-
-	return image, walData, nil
+	if height == 0 {
+		height = 1
+	}
+	return width, height
 }
 
 // Load image stored in WAL file
-func loadImage(walData WalHeader, r io.ReaderAt) ([]uint8, error) {
-	// Read the rgb values for each pixel in the texture
-	byteCount := walData.Width * walData.Height * 3
-	newImage := make([]uint8, byteCount)
-
-	reader := io.NewSectionReader(r, int64(unsafe.Sizeof(walData)), int64(byteCount))
-	for i := 0; i < int(byteCount); i += 3 {
-		paletteIndex := uint8(0)
+func loadImage(walData WalHeader, level int, r io.ReaderAt, palette Palette) ([]uint8, error) {
+	width, height := GetWALMipSize(walData, level)
+	offset := walData.Offset[level]
+	if offset <= 0 {
+		return nil, fmt.Errorf("Mip level %v has invalid offset %v", level, offset)
+	}
 
-		if err := binary.Read(reader, binary.LittleEndian, &paletteIndex); err != nil {
-			return nil, err
-		}
+	// Each pixel is stored as a palette index
+	pixelCount := int(width * height)
+	paletteIndices := make([]uint8, pixelCount)
+	if _, err := r.ReadAt(paletteIndices, int64(offset)); err != nil {
+		return nil, err
+	}
 
+	// Read the rgb values for each pixel in the texture
+	newImage := make([]uint8, pixelCount*3)
+	for i, paletteIndex := range paletteIndices {
 		// add new color
 		rgbColor := palette[paletteIndex]
 		// each integer represents r, g, b respectively
-		newImage[i+0] = rgbColor[0]
-		newImage[i+1] = rgbColor[1]
-		newImage[i+2] = rgbColor[2]
+		newImage[i*3+0] = rgbColor[0]
+		newImage[i*3+1] = rgbColor[1]
+		newImage[i*3+2] = rgbColor[2]
 	}
 
 	return newImage, nil
@@ -0,0 +1,141 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	wavFormatPCM = 1
+)
+
+// The fmt chunk of a WAV file
+type WavFormat struct {
+	AudioFormat   uint16 // 1 for uncompressed PCM
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// Quake 2 sounds are 8-bit or 16-bit PCM, usually mono at 11025 or 22050 Hz
+type WavSound struct {
+	Format WavFormat
+	// Samples from -1 to 1, with the channels mixed down to mono
+	Samples []float32
+	// Sample the sound loops back to, or -1 if it doesn't loop
+	LoopStart int
+}
+
+type wavChunkHeader struct {
+	ID   [4]byte
+	Size uint32
+}
+
+func LoadQ2WAV(r io.ReaderAt) (*WavSound, error) {
+	riffHeader := struct {
+		ChunkID [4]byte
+		Size    uint32
+		Format  [4]byte
+	}{}
+	reader := io.NewSectionReader(r, 0, 12)
+	if err := binary.Read(reader, binary.LittleEndian, &riffHeader); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(riffHeader.ChunkID[:], []byte("RIFF")) || !bytes.Equal(riffHeader.Format[:], []byte("WAVE")) {
+		return nil, fmt.Errorf("WAV Header: Not a RIFF WAVE file")
+	}
+
+	sound := &WavSound{LoopStart: -1}
+	var sampleData []uint8
+	hasFormat := false
+
+	// Chunks can be in any order and are padded to an even size
+	offset := int64(12)
+	for {
+		chunk := wavChunkHeader{}
+		chunkReader := io.NewSectionReader(r, offset, 8)
+		if err := binary.Read(chunkReader, binary.LittleEndian, &chunk); err != nil {
+			break
+		}
+		dataOffset := offset + 8
+
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			formatReader := io.NewSectionReader(r, dataOffset, int64(chunk.Size))
+			if err := binary.Read(formatReader, binary.LittleEndian, &sound.Format); err != nil {
+				return nil, fmt.Errorf("WAV fmt chunk is truncated")
+			}
+			hasFormat = true
+		case "data":
+			// Only the bytes in the file are read, so a bad size can't allocate more than that
+			data, err := io.ReadAll(io.NewSectionReader(r, dataOffset, int64(chunk.Size)))
+			if err != nil || len(data) != int(chunk.Size) {
+				return nil, fmt.Errorf("WAV data chunk is truncated")
+			}
+			sampleData = data
+		case "cue ":
+			// The sample offset of the first cue point is where the loop starts
+			cueData := make([]uint8, 28)
+			if _, err := r.ReadAt(cueData, dataOffset); err == nil {
+				sound.LoopStart = int(binary.LittleEndian.Uint32(cueData[24:28]))
+			}
+		}
+
+		offset = dataOffset + int64(chunk.Size) + int64(chunk.Size&1)
+	}
+
+	if !hasFormat {
+		return nil, fmt.Errorf("WAV file has no fmt chunk")
+	}
+	if sampleData == nil {
+		return nil, fmt.Errorf("WAV file has no data chunk")
+	}
+	format := sound.Format
+	if format.AudioFormat != wavFormatPCM {
+		return nil, fmt.Errorf("WAV format %v is not PCM", format.AudioFormat)
+	}
+	if format.BitsPerSample != 8 && format.BitsPerSample != 16 {
+		return nil, fmt.Errorf("WAV has unsupported sample size %v", format.BitsPerSample)
+	}
+	if format.Channels == 0 || format.SampleRate == 0 {
+		return nil, fmt.Errorf("WAV has invalid format %v channels at %v Hz", format.Channels, format.SampleRate)
+	}
+
+	sound.Samples = decodeWAVSamples(sampleData, format)
+	if sound.LoopStart >= len(sound.Samples) {
+		sound.LoopStart = -1
+	}
+	return sound, nil
+}
+
+// 8-bit samples are unsigned and 16-bit samples are signed
+func decodeWAVSamples(sampleData []uint8, format WavFormat) []float32 {
+	bytesPerSample := int(format.BitsPerSample / 8)
+	channels := int(format.Channels)
+	frameSize := bytesPerSample * channels
+	numFrames := len(sampleData) / frameSize
+
+	samples := make([]float32, numFrames)
+	for i := 0; i < numFrames; i++ {
+		sum := float32(0)
+		for channel := 0; channel < channels; channel++ {
+			sampleOffset := i*frameSize + channel*bytesPerSample
+			if bytesPerSample == 1 {
+				sum += (float32(sampleData[sampleOffset]) - 128) / 128
+			} else {
+				sum += float32(int16(binary.LittleEndian.Uint16(sampleData[sampleOffset:]))) / 32768
+			}
+		}
+		samples[i] = sum / float32(channels)
+	}
+	return samples
+}
+
+// Length of the sound in seconds
+func (sound *WavSound) Duration() float64 {
+	return float64(len(sound.Samples)) / float64(sound.Format.SampleRate)
+}
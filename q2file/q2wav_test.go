@@ -0,0 +1,115 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// Build a WAV file with any sample size, and a cue chunk if loopStart isn't -1
+func buildTestWAV(format WavFormat, sampleData []byte, loopStart int) []byte {
+	chunks := &bytes.Buffer{}
+	binary.Write(chunks, binary.LittleEndian, wavChunkHeader{ID: [4]byte{'f', 'm', 't', ' '}, Size: 16})
+	binary.Write(chunks, binary.LittleEndian, format)
+	if loopStart >= 0 {
+		// One cue point, the sample offset is its last field
+		binary.Write(chunks, binary.LittleEndian, wavChunkHeader{ID: [4]byte{'c', 'u', 'e', ' '}, Size: 28})
+		binary.Write(chunks, binary.LittleEndian, [6]uint32{1, 0, 0, 0, 0, 0})
+		binary.Write(chunks, binary.LittleEndian, uint32(loopStart))
+	}
+	binary.Write(chunks, binary.LittleEndian, wavChunkHeader{ID: [4]byte{'d', 'a', 't', 'a'}, Size: uint32(len(sampleData))})
+	chunks.Write(sampleData)
+
+	wav := &bytes.Buffer{}
+	wav.WriteString("RIFF")
+	binary.Write(wav, binary.LittleEndian, uint32(4+chunks.Len()))
+	wav.WriteString("WAVE")
+	wav.Write(chunks.Bytes())
+	return wav.Bytes()
+}
+
+func checkWAVSamples(t *testing.T, samples []float32, want []float32) {
+	t.Helper()
+	if len(samples) != len(want) {
+		t.Fatalf("Loaded %v samples, want %v", len(samples), len(want))
+	}
+	for i := range want {
+		if math.Abs(float64(samples[i]-want[i])) > 1e-6 {
+			t.Errorf("Sample %v is %v, want %v", i, samples[i], want[i])
+		}
+	}
+}
+
+func TestWriteWAVRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		samples  []int16
+		want     []float32
+	}{
+		{"mono", 1, []int16{0, 16384, -32768, 32767}, []float32{0, 0.5, -1, 32767.0 / 32768}},
+		{"stereo downmix", 2, []int16{16384, -16384, 16384, 0, -32768, -32768}, []float32{0, 0.25, -1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			if err := WriteWAV(buffer, 22050, test.channels, test.samples); err != nil {
+				t.Fatalf("Failed to write WAV: %v", err)
+			}
+
+			sound, err := LoadQ2WAV(bytes.NewReader(buffer.Bytes()))
+			if err != nil {
+				t.Fatalf("Failed to load written WAV: %v", err)
+			}
+			if sound.Format.SampleRate != 22050 || int(sound.Format.Channels) != test.channels || sound.Format.BitsPerSample != 16 {
+				t.Errorf("Format is %+v, want 16-bit with %v channels at 22050 Hz", sound.Format, test.channels)
+			}
+			if sound.LoopStart != -1 {
+				t.Errorf("Loop start is %v, want -1 without a cue chunk", sound.LoopStart)
+			}
+			checkWAVSamples(t, sound.Samples, test.want)
+		})
+	}
+
+	if err := WriteWAV(&bytes.Buffer{}, 22050, 2, []int16{1, 2, 3}); err == nil {
+		t.Errorf("Wrote 3 samples as stereo without an error")
+	}
+}
+
+func TestLoadQ2WAV(t *testing.T) {
+	format8 := WavFormat{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: 11025, ByteRate: 11025, BlockAlign: 1, BitsPerSample: 8}
+	format16 := WavFormat{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: 11025, ByteRate: 22050, BlockAlign: 2, BitsPerSample: 16}
+	stereo8 := WavFormat{AudioFormat: wavFormatPCM, Channels: 2, SampleRate: 11025, ByteRate: 22050, BlockAlign: 2, BitsPerSample: 8}
+
+	tests := []struct {
+		name      string
+		format    WavFormat
+		data      []byte
+		loopStart int
+		want      []float32
+		wantLoop  int
+	}{
+		// 8-bit samples are unsigned with 128 as silence
+		{"8-bit unsigned", format8, []byte{0, 128, 192, 255}, -1, []float32{-1, 0, 0.5, 127.0 / 128}, -1},
+		// 16-bit samples are signed, 0x8000 is the most negative value
+		{"16-bit signed", format16, []byte{0x00, 0x80, 0x00, 0x00, 0x00, 0x40}, -1, []float32{-1, 0, 0.5}, -1},
+		{"8-bit stereo downmix", stereo8, []byte{0, 255, 128, 192}, -1, []float32{-1.0 / 256, 0.25}, -1},
+		{"cue loop start", format8, []byte{128, 128, 128, 128}, 2, []float32{0, 0, 0, 0}, 2},
+		{"cue past the end", format8, []byte{128, 128}, 5, []float32{0, 0}, -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sound, err := LoadQ2WAV(bytes.NewReader(buildTestWAV(test.format, test.data, test.loopStart)))
+			if err != nil {
+				t.Fatalf("Failed to load WAV: %v", err)
+			}
+			checkWAVSamples(t, sound.Samples, test.want)
+			if sound.LoopStart != test.wantLoop {
+				t.Errorf("Loop start is %v, want %v", sound.LoopStart, test.wantLoop)
+			}
+		})
+	}
+}
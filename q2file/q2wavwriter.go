@@ -0,0 +1,40 @@
+package q2file
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Write 16-bit PCM samples, interleaved if there is more than one channel
+func WriteWAV(w io.Writer, sampleRate int, channels int, samples []int16) error {
+	if channels <= 0 || len(samples)%channels != 0 {
+		return fmt.Errorf("WAV writer: %v samples can't be split into %v channels", len(samples), channels)
+	}
+
+	dataSize := uint32(len(samples) * 2)
+	format := WavFormat{
+		AudioFormat:   wavFormatPCM,
+		Channels:      uint16(channels),
+		SampleRate:    uint32(sampleRate),
+		ByteRate:      uint32(sampleRate * channels * 2),
+		BlockAlign:    uint16(channels * 2),
+		BitsPerSample: 16,
+	}
+
+	// RIFF header, then the fmt and data chunks
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(4 + 8 + 16 + 8 + dataSize),
+		[4]byte{'W', 'A', 'V', 'E'},
+		wavChunkHeader{ID: [4]byte{'f', 'm', 't', ' '}, Size: 16},
+		format,
+		wavChunkHeader{ID: [4]byte{'d', 'a', 't', 'a'}, Size: dataSize},
+	}
+	for _, value := range header {
+		if err := binary.Write(w, binary.LittleEndian, value); err != nil {
+			return err
+		}
+	}
+	return binary.Write(w, binary.LittleEndian, samples)
+}
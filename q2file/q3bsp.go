@@ -0,0 +1,438 @@
+package q2file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+const Q3BSPVersion = 46
+
+const (
+	Q3LumpEntities    = 0
+	Q3LumpShaders     = 1
+	Q3LumpPlanes      = 2
+	Q3LumpBSPNodes    = 3
+	Q3LumpBSPLeaves   = 4
+	Q3LumpLeafFaces   = 5
+	Q3LumpLeafBrushes = 6
+	Q3LumpModels      = 7
+	Q3LumpBrushes     = 8
+	Q3LumpBrushSides  = 9
+	Q3LumpVertices    = 10
+	Q3LumpMeshVerts   = 11
+	Q3LumpEffects     = 12
+	Q3LumpFaces       = 13
+	Q3LumpLightmaps   = 14
+	Q3LumpLightGrid   = 15
+	Q3LumpVisibility  = 16
+)
+
+// Face types
+const (
+	Q3FacePolygon   = int32(1)
+	Q3FacePatch     = int32(2)
+	Q3FaceMesh      = int32(3)
+	Q3FaceBillboard = int32(4)
+)
+
+const (
+	// Each lightmap is a 128x128 rgb image
+	Q3LightmapSize = 128
+	// Default lightgrid cell size, unless worldspawn has a "gridsize" key
+	q3DefaultGridSize = "64 64 128"
+)
+
+type Q3Header struct {
+	Magic   [4]byte  // magic number ("IBSP")
+	Version uint32   // version of the BSP format (46)
+	Lumps   [17]Lump // directory of the lumps
+}
+
+// Shaders are usually texture paths without an extension, such as textures/base_wall/concrete
+type Q3Shader struct {
+	Name     [64]byte
+	Flags    int32 // surface flags
+	Contents int32
+}
+
+type Q3Plane struct {
+	Normal   [3]float32
+	Distance float32
+}
+
+type Q3BSPNode struct {
+	Plane    int32
+	Children [2]int32 // negative children are leaves, -(leaf + 1)
+	BBoxMin  [3]int32
+	BBoxMax  [3]int32
+}
+
+type Q3BSPLeaf struct {
+	Cluster int32 // negative if the leaf is outside the map
+	Area    int32
+
+	BBoxMin [3]int32
+	BBoxMax [3]int32
+
+	FirstLeafFace  int32
+	NumLeafFaces   int32
+	FirstLeafBrush int32
+	NumLeafBrushes int32
+}
+
+type Q3Model struct {
+	BBoxMin [3]float32
+	BBoxMax [3]float32
+
+	FirstFace  int32
+	NumFaces   int32
+	FirstBrush int32
+	NumBrushes int32
+}
+
+type Q3Brush struct {
+	FirstSide int32
+	NumSides  int32
+	Shader    int32
+}
+
+type Q3BrushSide struct {
+	Plane  int32
+	Shader int32
+}
+
+type Q3Vertex struct {
+	Position [3]float32
+	TexCoord [2]float32 // surface texture coordinates, already divided by the texture size
+	LightUV  [2]float32 // coordinates in the lightmap of the face
+	Normal   [3]float32
+	Color    [4]uint8
+}
+
+// Fog volumes, which use a brush for their bounds
+type Q3Effect struct {
+	Name    [64]byte
+	Brush   int32
+	Unknown int32 // visible side of the brush, -1 if there is none
+}
+
+type Q3Face struct {
+	Shader int32
+	Effect int32 // -1 if the face isn't fogged
+	Type   int32 // polygon, patch, mesh or billboard
+
+	FirstVertex   int32
+	NumVertices   int32
+	FirstMeshVert int32 // triangles for polygons and meshes, as offsets from the first vertex
+	NumMeshVerts  int32
+
+	Lightmap       int32 // -1 if the face has no lightmap
+	LightmapStart  [2]int32
+	LightmapSize   [2]int32
+	LightmapOrigin [3]float32
+	LightmapVecs   [2][3]float32
+	Normal         [3]float32
+	PatchSize      [2]int32 // number of control points in each direction
+}
+
+// Light that reaches a cell in a uniform grid over the world
+type Q3LightVolume struct {
+	Ambient     [3]uint8
+	Directional [3]uint8
+	Direction   [2]uint8 // phi and theta, where 256 is a full circle
+}
+
+type Q3MapData struct {
+	Entities    []Entity
+	Shaders     []Q3Shader
+	Planes      []Q3Plane
+	Nodes       []Q3BSPNode
+	BSPLeaves   []Q3BSPLeaf
+	LeafFaces   []int32
+	LeafBrushes []int32
+	Models      []Q3Model
+	Brushes     []Q3Brush
+	BrushSides  []Q3BrushSide
+	Vertices    []Q3Vertex
+	MeshVerts   []int32
+	Effects     []Q3Effect
+	Faces       []Q3Face
+	Lightmaps   [][]uint8 // Q3LightmapSize * Q3LightmapSize * 3 bytes each
+	LightGrid   []Q3LightVolume
+
+	// Uncompressed PVS, with one row of bits for every cluster
+	NumClusters     int
+	BytesPerCluster int
+	VisibilityData  []uint8
+}
+
+func LoadQ3BSP(r io.ReaderAt) (*Q3MapData, error) {
+	header := Q3Header{}
+
+	lumpReader := io.NewSectionReader(r, 0, int64(unsafe.Sizeof(header)))
+	if err := binary.Read(lumpReader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	var magic = []byte("IBSP")
+	if !bytes.Equal(magic, header.Magic[:]) {
+		return nil, fmt.Errorf("BSP Header: Wrong magic %v", header.Magic)
+	}
+	if header.Version != Q3BSPVersion {
+		return nil, fmt.Errorf("BSP Header: Wrong version %v", header.Version)
+	}
+
+	fmt.Println("Header total lumps:", len(header.Lumps))
+
+	mapData := &Q3MapData{}
+	entities, err := loadEntities(header.Lumps[Q3LumpEntities], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load entities: %v", err)
+	}
+	mapData.Entities = entities
+
+	// Every other lump is an array of fixed size items
+	lumps := header.Lumps
+	mapData.Shaders = make([]Q3Shader, lumps[Q3LumpShaders].Length/72)
+	mapData.Planes = make([]Q3Plane, lumps[Q3LumpPlanes].Length/16)
+	mapData.Nodes = make([]Q3BSPNode, lumps[Q3LumpBSPNodes].Length/36)
+	mapData.BSPLeaves = make([]Q3BSPLeaf, lumps[Q3LumpBSPLeaves].Length/48)
+	mapData.LeafFaces = make([]int32, lumps[Q3LumpLeafFaces].Length/4)
+	mapData.LeafBrushes = make([]int32, lumps[Q3LumpLeafBrushes].Length/4)
+	mapData.Models = make([]Q3Model, lumps[Q3LumpModels].Length/40)
+	mapData.Brushes = make([]Q3Brush, lumps[Q3LumpBrushes].Length/12)
+	mapData.BrushSides = make([]Q3BrushSide, lumps[Q3LumpBrushSides].Length/8)
+	mapData.Vertices = make([]Q3Vertex, lumps[Q3LumpVertices].Length/44)
+	mapData.MeshVerts = make([]int32, lumps[Q3LumpMeshVerts].Length/4)
+	mapData.Effects = make([]Q3Effect, lumps[Q3LumpEffects].Length/72)
+	mapData.Faces = make([]Q3Face, lumps[Q3LumpFaces].Length/104)
+	mapData.LightGrid = make([]Q3LightVolume, lumps[Q3LumpLightGrid].Length/8)
+
+	lumpArrays := []struct {
+		lumpIndex int
+		name      string
+		data      interface{}
+		count     int
+	}{
+		{Q3LumpShaders, "shaders", mapData.Shaders, len(mapData.Shaders)},
+		{Q3LumpPlanes, "planes", mapData.Planes, len(mapData.Planes)},
+		{Q3LumpBSPNodes, "BSP nodes", mapData.Nodes, len(mapData.Nodes)},
+		{Q3LumpBSPLeaves, "BSP leaves", mapData.BSPLeaves, len(mapData.BSPLeaves)},
+		{Q3LumpLeafFaces, "leaf faces", mapData.LeafFaces, len(mapData.LeafFaces)},
+		{Q3LumpLeafBrushes, "leaf brushes", mapData.LeafBrushes, len(mapData.LeafBrushes)},
+		{Q3LumpModels, "models", mapData.Models, len(mapData.Models)},
+		{Q3LumpBrushes, "brushes", mapData.Brushes, len(mapData.Brushes)},
+		{Q3LumpBrushSides, "brush sides", mapData.BrushSides, len(mapData.BrushSides)},
+		{Q3LumpVertices, "vertices", mapData.Vertices, len(mapData.Vertices)},
+		{Q3LumpMeshVerts, "mesh vertices", mapData.MeshVerts, len(mapData.MeshVerts)},
+		{Q3LumpEffects, "effects", mapData.Effects, len(mapData.Effects)},
+		{Q3LumpFaces, "faces", mapData.Faces, len(mapData.Faces)},
+		{Q3LumpLightGrid, "light grid", mapData.LightGrid, len(mapData.LightGrid)},
+	}
+	for _, lumpArray := range lumpArrays {
+		lump := lumps[lumpArray.lumpIndex]
+		reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+		if err := binary.Read(reader, binary.LittleEndian, lumpArray.data); err != nil {
+			return nil, fmt.Errorf("Failed to load %v: %v", lumpArray.name, err)
+		}
+		fmt.Println("Q3 "+lumpArray.name+" count:", lumpArray.count)
+	}
+
+	lightmapData, err := loadRawLump(header.Lumps[Q3LumpLightmaps], r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load lightmaps")
+	}
+	lightmapBytes := Q3LightmapSize * Q3LightmapSize * 3
+	mapData.Lightmaps = make([][]uint8, len(lightmapData)/lightmapBytes)
+	for i := range mapData.Lightmaps {
+		mapData.Lightmaps[i] = lightmapData[i*lightmapBytes : (i+1)*lightmapBytes]
+	}
+	fmt.Println("Lightmap count:", len(mapData.Lightmaps))
+
+	if err := mapData.loadVisibility(header.Lumps[Q3LumpVisibility], r); err != nil {
+		return nil, fmt.Errorf("Failed to load visibility data: %v", err)
+	}
+
+	if err := mapData.validate(); err != nil {
+		return nil, err
+	}
+	return mapData, nil
+}
+
+// The visibility lump starts with the number of clusters and the size of each row
+func (mapData *Q3MapData) loadVisibility(lump Lump, r io.ReaderAt) error {
+	if lump.Length == 0 {
+		return nil
+	}
+
+	reader := io.NewSectionReader(r, int64(lump.Offset), int64(lump.Length))
+	sizes := [2]int32{}
+	if err := binary.Read(reader, binary.LittleEndian, &sizes); err != nil {
+		return err
+	}
+	if sizes[0] < 0 || sizes[1] < 0 || int64(sizes[0])*int64(sizes[1]) > int64(lump.Length)-8 {
+		return fmt.Errorf("invalid size %v clusters with %v bytes each", sizes[0], sizes[1])
+	}
+
+	mapData.NumClusters = int(sizes[0])
+	mapData.BytesPerCluster = int(sizes[1])
+	mapData.VisibilityData = make([]uint8, mapData.NumClusters*mapData.BytesPerCluster)
+	if _, err := io.ReadFull(reader, mapData.VisibilityData); err != nil {
+		return err
+	}
+
+	fmt.Println("Visibility cluster count:", mapData.NumClusters)
+	return nil
+}
+
+// Check the indices the renderer follows, so a broken map fails to load instead of crashing later
+func (mapData *Q3MapData) validate() error {
+	for i, face := range mapData.Faces {
+		if face.Shader < 0 || int(face.Shader) >= len(mapData.Shaders) {
+			return fmt.Errorf("Face %v has invalid shader %v", i, face.Shader)
+		}
+		if face.FirstVertex < 0 || face.NumVertices < 0 || int(face.FirstVertex)+int(face.NumVertices) > len(mapData.Vertices) {
+			return fmt.Errorf("Face %v has invalid vertex range %v-%v", i, face.FirstVertex, face.FirstVertex+face.NumVertices)
+		}
+		if face.FirstMeshVert < 0 || face.NumMeshVerts < 0 || int(face.FirstMeshVert)+int(face.NumMeshVerts) > len(mapData.MeshVerts) {
+			return fmt.Errorf("Face %v has invalid mesh vertex range %v-%v", i, face.FirstMeshVert, face.FirstMeshVert+face.NumMeshVerts)
+		}
+		for _, meshVert := range mapData.MeshVerts[face.FirstMeshVert : face.FirstMeshVert+face.NumMeshVerts] {
+			if meshVert < 0 || meshVert >= face.NumVertices {
+				return fmt.Errorf("Face %v has invalid mesh vertex %v", i, meshVert)
+			}
+		}
+		if face.Type == Q3FacePatch && int(face.PatchSize[0])*int(face.PatchSize[1]) > int(face.NumVertices) {
+			return fmt.Errorf("Face %v has invalid patch size %vx%v", i, face.PatchSize[0], face.PatchSize[1])
+		}
+		if face.Lightmap < -1 || int(face.Lightmap) >= len(mapData.Lightmaps) {
+			return fmt.Errorf("Face %v has invalid lightmap %v", i, face.Lightmap)
+		}
+	}
+	for i, node := range mapData.Nodes {
+		if node.Plane < 0 || int(node.Plane) >= len(mapData.Planes) {
+			return fmt.Errorf("Node %v has invalid plane %v", i, node.Plane)
+		}
+		for _, child := range node.Children {
+			if child >= 0 && int(child) >= len(mapData.Nodes) {
+				return fmt.Errorf("Node %v has invalid child node %v", i, child)
+			}
+			if child < 0 && int(-(child+1)) >= len(mapData.BSPLeaves) {
+				return fmt.Errorf("Node %v has invalid child leaf %v", i, -(child + 1))
+			}
+		}
+	}
+	for i, model := range mapData.Models {
+		if model.FirstFace < 0 || model.NumFaces < 0 || int(model.FirstFace)+int(model.NumFaces) > len(mapData.Faces) {
+			return fmt.Errorf("Model %v has invalid face range %v-%v", i, model.FirstFace, model.FirstFace+model.NumFaces)
+		}
+	}
+	for i, leaf := range mapData.BSPLeaves {
+		if leaf.FirstLeafFace < 0 || leaf.NumLeafFaces < 0 || int(leaf.FirstLeafFace)+int(leaf.NumLeafFaces) > len(mapData.LeafFaces) {
+			return fmt.Errorf("Leaf %v has invalid leaf face range %v-%v", i, leaf.FirstLeafFace, leaf.FirstLeafFace+leaf.NumLeafFaces)
+		}
+	}
+	for i, faceId := range mapData.LeafFaces {
+		if faceId < 0 || int(faceId) >= len(mapData.Faces) {
+			return fmt.Errorf("Leaf face %v has invalid face %v", i, faceId)
+		}
+	}
+	return nil
+}
+
+func (shader Q3Shader) GetName() string {
+	return byteToString(shader.Name[:])
+}
+
+// Walk down the BSP tree to the leaf containing the position
+func (mapData *Q3MapData) FindLeaf(position [3]float32) int {
+	nodeIndex := int32(0)
+	for nodeIndex >= 0 && int(nodeIndex) < len(mapData.Nodes) {
+		node := mapData.Nodes[nodeIndex]
+		plane := mapData.Planes[node.Plane]
+		distance := plane.Normal[0]*position[0] + plane.Normal[1]*position[1] + plane.Normal[2]*position[2] - plane.Distance
+		if distance >= 0 {
+			nodeIndex = node.Children[0]
+		} else {
+			nodeIndex = node.Children[1]
+		}
+	}
+	return int(-(nodeIndex + 1))
+}
+
+// Clusters can always see themselves, and everything is visible from outside the map or without visibility data
+// Leaves outside the map are never visible
+func (mapData *Q3MapData) IsClusterVisible(fromCluster int, toCluster int) bool {
+	if toCluster < 0 {
+		return false
+	}
+	if fromCluster < 0 || mapData.VisibilityData == nil || fromCluster == toCluster {
+		return true
+	}
+	if fromCluster >= mapData.NumClusters || toCluster >= mapData.NumClusters {
+		return false
+	}
+	row := mapData.VisibilityData[fromCluster*mapData.BytesPerCluster:]
+	return row[toCluster/8]&(1<<uint(toCluster%8)) != 0
+}
+
+// Number of light grid cells along each axis, which covers the world bounds
+func (mapData *Q3MapData) LightGridDimensions() ([3]int, [3]float32) {
+	gridSize := [3]float32{}
+	sizeText := q3DefaultGridSize
+	if worldspawns := FindEntitiesByClass(mapData.Entities, "worldspawn"); len(worldspawns) > 0 {
+		if value, exists := worldspawns[0].Get("gridsize"); exists {
+			sizeText = value
+		}
+	}
+	defaultFields := strings.Fields(q3DefaultGridSize)
+	fields := strings.Fields(sizeText)
+	for axis := 0; axis < 3; axis++ {
+		value, err := 0.0, fmt.Errorf("missing")
+		if axis < len(fields) {
+			value, err = strconv.ParseFloat(fields[axis], 32)
+		}
+		if err != nil || value <= 0 {
+			value, _ = strconv.ParseFloat(defaultFields[axis], 32)
+		}
+		gridSize[axis] = float32(value)
+	}
+
+	dimensions := [3]int{}
+	if len(mapData.Models) == 0 {
+		return dimensions, gridSize
+	}
+	world := mapData.Models[0]
+	for axis := 0; axis < 3; axis++ {
+		minCell := math.Ceil(float64(world.BBoxMin[axis] / gridSize[axis]))
+		maxCell := math.Floor(float64(world.BBoxMax[axis] / gridSize[axis]))
+		dimensions[axis] = int(maxCell-minCell) + 1
+	}
+	return dimensions, gridSize
+}
+
+// Get the triangles of a face as a flat list with three vertices per triangle
+// Patches are tessellated with the given number of subdivisions per 3x3 section
+// Billboards (flares) have no triangles
+func (mapData *Q3MapData) GetFaceTriangles(face Q3Face, tessellationLevel int) []Q3Vertex {
+	switch face.Type {
+	case Q3FacePolygon, Q3FaceMesh:
+		triangles := make([]Q3Vertex, face.NumMeshVerts)
+		for i := 0; i < int(face.NumMeshVerts); i++ {
+			meshVert := mapData.MeshVerts[int(face.FirstMeshVert)+i]
+			triangles[i] = mapData.Vertices[face.FirstVertex+meshVert]
+		}
+		return triangles
+	case Q3FacePatch:
+		controlPoints := mapData.Vertices[face.FirstVertex : face.FirstVertex+face.NumVertices]
+		return TessellateQ3Patch(controlPoints, int(face.PatchSize[0]), int(face.PatchSize[1]), tessellationLevel)
+	default:
+		return []Q3Vertex{}
+	}
+}
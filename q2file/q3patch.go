@@ -0,0 +1,123 @@
+package q2file
+
+import (
+	"math"
+)
+
+// Patches are grids of control points, where every 3x3 section is a biquadratic Bezier surface
+// Each section is split into tessellationLevel x tessellationLevel quads
+// Returns a flat list with three vertices per triangle
+func TessellateQ3Patch(controlPoints []Q3Vertex, width int, height int, tessellationLevel int) []Q3Vertex {
+	if tessellationLevel < 1 {
+		tessellationLevel = 1
+	}
+	if width < 3 || height < 3 || width%2 == 0 || height%2 == 0 || len(controlPoints) < width*height {
+		return []Q3Vertex{}
+	}
+
+	numSectionsX := (width - 1) / 2
+	numSectionsY := (height - 1) / 2
+	triangles := make([]Q3Vertex, 0, numSectionsX*numSectionsY*tessellationLevel*tessellationLevel*6)
+
+	for sectionY := 0; sectionY < numSectionsY; sectionY++ {
+		for sectionX := 0; sectionX < numSectionsX; sectionX++ {
+			section := [9]Q3Vertex{}
+			for row := 0; row < 3; row++ {
+				for column := 0; column < 3; column++ {
+					section[row*3+column] = controlPoints[(sectionY*2+row)*width+sectionX*2+column]
+				}
+			}
+
+			grid := tessellateQ3Section(section, tessellationLevel)
+			rowSize := tessellationLevel + 1
+			for row := 0; row < tessellationLevel; row++ {
+				for column := 0; column < tessellationLevel; column++ {
+					v00 := grid[row*rowSize+column]
+					v01 := grid[row*rowSize+column+1]
+					v10 := grid[(row+1)*rowSize+column]
+					v11 := grid[(row+1)*rowSize+column+1]
+					triangles = appendQ3Triangle(triangles, v00, v10, v01)
+					triangles = appendQ3Triangle(triangles, v01, v10, v11)
+				}
+			}
+		}
+	}
+	return triangles
+}
+
+// Evaluate a 3x3 section at evenly spaced points
+func tessellateQ3Section(section [9]Q3Vertex, tessellationLevel int) []Q3Vertex {
+	rowSize := tessellationLevel + 1
+	grid := make([]Q3Vertex, rowSize*rowSize)
+	for row := 0; row < rowSize; row++ {
+		v := float32(row) / float32(tessellationLevel)
+		// Interpolate along each column of control points first
+		column := [3]Q3Vertex{}
+		for i := 0; i < 3; i++ {
+			column[i] = bezierQ3Vertex(section[i], section[3+i], section[6+i], v)
+		}
+		for i := 0; i < rowSize; i++ {
+			u := float32(i) / float32(tessellationLevel)
+			vertex := bezierQ3Vertex(column[0], column[1], column[2], u)
+			vertex.Normal = normalizeQ3Vector(vertex.Normal)
+			grid[row*rowSize+i] = vertex
+		}
+	}
+	return grid
+}
+
+// Quadratic Bezier curve through three control points
+func bezierQ3Vertex(p0 Q3Vertex, p1 Q3Vertex, p2 Q3Vertex, t float32) Q3Vertex {
+	b0 := (1 - t) * (1 - t)
+	b1 := 2 * t * (1 - t)
+	b2 := t * t
+
+	vertex := Q3Vertex{}
+	for axis := 0; axis < 3; axis++ {
+		vertex.Position[axis] = p0.Position[axis]*b0 + p1.Position[axis]*b1 + p2.Position[axis]*b2
+		vertex.Normal[axis] = p0.Normal[axis]*b0 + p1.Normal[axis]*b1 + p2.Normal[axis]*b2
+	}
+	for axis := 0; axis < 2; axis++ {
+		vertex.TexCoord[axis] = p0.TexCoord[axis]*b0 + p1.TexCoord[axis]*b1 + p2.TexCoord[axis]*b2
+		vertex.LightUV[axis] = p0.LightUV[axis]*b0 + p1.LightUV[axis]*b1 + p2.LightUV[axis]*b2
+	}
+	for channel := 0; channel < 4; channel++ {
+		color := float32(p0.Color[channel])*b0 + float32(p1.Color[channel])*b1 + float32(p2.Color[channel])*b2
+		vertex.Color[channel] = uint8(math.Min(255, math.Max(0, math.Round(float64(color)))))
+	}
+	return vertex
+}
+
+// Use the same clockwise winding as the polygon faces, based on the vertex normals
+// Triangles that collapsed to a line are left out
+func appendQ3Triangle(triangles []Q3Vertex, a Q3Vertex, b Q3Vertex, c Q3Vertex) []Q3Vertex {
+	edge1 := [3]float32{}
+	edge2 := [3]float32{}
+	normal := [3]float32{}
+	for axis := 0; axis < 3; axis++ {
+		edge1[axis] = b.Position[axis] - a.Position[axis]
+		edge2[axis] = c.Position[axis] - a.Position[axis]
+		normal[axis] = a.Normal[axis] + b.Normal[axis] + c.Normal[axis]
+	}
+	cross := [3]float32{
+		edge1[1]*edge2[2] - edge1[2]*edge2[1],
+		edge1[2]*edge2[0] - edge1[0]*edge2[2],
+		edge1[0]*edge2[1] - edge1[1]*edge2[0],
+	}
+	if cross[0] == 0 && cross[1] == 0 && cross[2] == 0 {
+		return triangles
+	}
+
+	if cross[0]*normal[0]+cross[1]*normal[1]+cross[2]*normal[2] > 0 {
+		return append(triangles, a, c, b)
+	}
+	return append(triangles, a, b, c)
+}
+
+func normalizeQ3Vector(vector [3]float32) [3]float32 {
+	length := float32(math.Sqrt(float64(vector[0]*vector[0] + vector[1]*vector[1] + vector[2]*vector[2])))
+	if length == 0 {
+		return vector
+	}
+	return [3]float32{vector[0] / length, vector[1] / length, vector[2] / length}
+}
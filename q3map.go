@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/samuelyuan/go-quake2/q2file"
+	"github.com/samuelyuan/go-quake2/render"
+)
+
+// Check the header without loading the whole map
+func isQ3Map(fileSystem *q2file.FileSystem, bspFilename string) bool {
+	bspReader, err := fileSystem.Open(bspFilename)
+	if err != nil {
+		return false
+	}
+	version, err := q2file.GetBSPVersion(bspReader)
+	return err == nil && version == q2file.Q3BSPVersion
+}
+
+// Quake 3 shaders are drawn with the image of the same name, shader scripts aren't parsed
+func createQ3TextureList(
+	fileSystem *q2file.FileSystem,
+	replacementFileSystem *q2file.FileSystem,
+	shaders []q2file.Q3Shader,
+) []render.MapTexture {
+	mapTextures := make([]render.MapTexture, len(shaders))
+	for i, shader := range shaders {
+		baseFilename := strings.ToLower(strings.Trim(shader.GetName(), " "))
+		shaderImage, _ := loadReplacementImage(fileSystem, replacementFileSystem, baseFilename)
+		if shaderImage == nil {
+			fmt.Println("Warning: shader image", baseFilename, "is missing.")
+			mapTextures[i] = render.NewMapTexture(0, 0, 0)
+			continue
+		}
+
+		bounds := shaderImage.Bounds()
+		texId := render.BuildImageTexture(shaderImage)
+		mapTextures[i] = render.NewMapTexture(texId, uint32(bounds.Dx()), uint32(bounds.Dy()))
+	}
+	return mapTextures
+}
+
+// Faces in every leaf whose cluster can be seen from the camera's cluster
+func getQ3VisibleFaces(mapData *q2file.Q3MapData, cameraCluster int) []int {
+	visibleFaces := make(map[int]bool)
+	for _, leaf := range mapData.BSPLeaves {
+		if !mapData.IsClusterVisible(cameraCluster, int(leaf.Cluster)) {
+			continue
+		}
+		for i := 0; i < int(leaf.NumLeafFaces); i++ {
+			visibleFaces[int(mapData.LeafFaces[int(leaf.FirstLeafFace)+i])] = true
+		}
+	}
+	return getFaceIdsFromUniqueFaces(visibleFaces)
+}
+
+// Quake 3 maps only show the world and brush models, using the same camera and draw path as Quake 2 maps
+func runQ3Map(
+	renderer *render.Renderer,
+	fileSystem *q2file.FileSystem,
+	replacementFileSystem *q2file.FileSystem,
+	bspFilename string,
+	tessellationLevel int,
+) error {
+	bspReader, err := fileSystem.Open(bspFilename)
+	if err != nil {
+		return err
+	}
+	mapData, err := q2file.LoadQ3BSP(bspReader)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Quake 3 BSP map successfully loaded")
+
+	mapTextures := createQ3TextureList(fileSystem, replacementFileSystem, mapData.Shaders)
+	fmt.Println("Textures successfully loaded")
+
+	// Brush entities (doors, platforms) aren't part of the leaf faces
+	modelFaceIds := make([]int, 0)
+	for modelId := 1; modelId < len(mapData.Models); modelId++ {
+		modelFaceIds = append(modelFaceIds, render.GetQ3ModelFaceIds(mapData, modelId)...)
+	}
+	modelRenderMap := render.CreateQ3RenderingData(mapData, mapTextures, modelFaceIds, tessellationLevel)
+
+	// Start at a spawn point, since the default camera position is usually outside the map
+	camera := NewCamera(windowHandler)
+	spawnPoints := q2file.FindEntitiesByClass(mapData.Entities, "info_player_deathmatch")
+	if len(spawnPoints) > 0 {
+		if origin, err := spawnPoints[0].GetVector("origin"); err == nil {
+			angle, _ := spawnPoints[0].GetFloat("angle")
+			camera.SetView(origin, 0, angle)
+		}
+	}
+
+	// The world is built once, moving to a different cluster only changes which faces are drawn
+	renderMap := render.CreateQ3RenderingData(mapData, mapTextures, render.GetQ3ModelFaceIds(mapData, 0), tessellationLevel)
+	prevCluster := -2
+	for !windowHandler.ShouldClose() {
+		windowHandler.StartFrame()
+		renderer.PrepareFrame(camera.GetViewMatrix(), camera.GetPerspectiveMatrix())
+
+		// Update the polygons if the player is in a different cluster
+		leafIndex := mapData.FindLeaf(camera.GetCameraPosition())
+		cluster := -1
+		if leafIndex >= 0 && leafIndex < len(mapData.BSPLeaves) {
+			cluster = int(mapData.BSPLeaves[leafIndex].Cluster)
+		}
+		if cluster != prevCluster {
+			visibleFaces := getQ3VisibleFaces(mapData, cluster)
+			if len(visibleFaces) > 0 {
+				renderMap.SetVisibleFaces(visibleFaces)
+			}
+			prevCluster = cluster
+		}
+		render.DrawMap(renderer, renderMap, windowHandler.GetElapsedTime())
+		render.DrawMap(renderer, modelRenderMap, windowHandler.GetElapsedTime())
+
+		camera.UpdateViewMatrix()
+	}
+	return nil
+}
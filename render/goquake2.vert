@@ -5,6 +5,7 @@ layout (location = 2) in vec2 texCoord2;
 out vec2 fragTexCoord;
 out vec2 vertexLightmapCoord;
 
+uniform mat4 model;
 uniform mat4 view;
 uniform mat4 projection;
 
@@ -12,5 +13,5 @@ void main() {
   fragTexCoord = vertTexCoord;
   vertexLightmapCoord = texCoord2;
 
-  gl_Position = projection * view * vec4(position, 1.0);
+  gl_Position = projection * view * model * vec4(position, 1.0);
 }
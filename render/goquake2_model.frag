@@ -0,0 +1,18 @@
+#version 410
+
+uniform sampler2D skin;
+
+in vec2 fragTexCoord;
+in vec3 fragNormal;
+out vec4 fragColor;
+
+const vec3 lightDirection = normalize(vec3(0.5, 0.3, 1.0));
+
+void main() {
+  vec4 skinColor = texture(skin, fragTexCoord.st);
+
+  // Models don't use the lightmap, so use a fixed light instead
+  float shade = 0.6 + 0.4 * max(dot(normalize(fragNormal), lightDirection), 0.0);
+
+  fragColor = vec4(skinColor.rgb * shade, skinColor.a);
+}
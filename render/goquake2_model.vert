@@ -0,0 +1,23 @@
+#version 410
+layout (location = 0) in vec3 position;
+layout (location = 1) in vec3 normal;
+layout (location = 2) in vec3 nextPosition;
+layout (location = 3) in vec3 nextNormal;
+layout (location = 4) in vec2 vertTexCoord;
+out vec2 fragTexCoord;
+out vec3 fragNormal;
+
+uniform mat4 model;
+uniform mat4 view;
+uniform mat4 projection;
+uniform float frameLerp;
+
+void main() {
+  fragTexCoord = vertTexCoord;
+
+  // Interpolate between the current and next animation frame
+  vec3 lerpPosition = mix(position, nextPosition, frameLerp);
+  fragNormal = mat3(model) * normalize(mix(normal, nextNormal, frameLerp));
+
+  gl_Position = projection * view * model * vec4(lerpPosition, 1.0);
+}
@@ -0,0 +1,10 @@
+#version 410
+
+uniform samplerCube sky;
+
+in vec3 skyDirection;
+out vec4 fragColor;
+
+void main() {
+  fragColor = vec4(texture(sky, skyDirection).rgb, 1.0);
+}
@@ -0,0 +1,19 @@
+#version 410
+layout (location = 0) in vec3 position;
+out vec3 skyDirection;
+
+uniform mat4 view;
+uniform mat4 projection;
+uniform mat4 skyRotation;
+
+void main() {
+  // Quake 2 axes (x forward, y left, z up) to the cube map axes
+  vec3 direction = mat3(skyRotation) * position;
+  skyDirection = vec3(direction.y, direction.z, -direction.x);
+
+  // Only the rotation of the camera is used, so the sky is always around the camera
+  vec4 clipPosition = projection * mat4(mat3(view)) * vec4(position, 1.0);
+
+  // Put the sky on the far plane
+  gl_Position = clipPosition.xyww;
+}
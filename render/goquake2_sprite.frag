@@ -0,0 +1,16 @@
+#version 410
+
+uniform sampler2D diffuse;
+uniform float alpha;
+
+in vec2 fragTexCoord;
+out vec4 fragColor;
+
+void main() {
+  vec4 diffuseColor = texture(diffuse, fragTexCoord.st);
+  if (diffuseColor.a * alpha < 0.01) {
+    discard;
+  }
+
+  fragColor = vec4(diffuseColor.rgb, diffuseColor.a * alpha);
+}
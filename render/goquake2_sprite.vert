@@ -0,0 +1,19 @@
+#version 410
+layout (location = 0) in vec2 corner;
+layout (location = 1) in vec2 vertTexCoord;
+out vec2 fragTexCoord;
+
+uniform mat4 view;
+uniform mat4 projection;
+uniform vec3 origin;
+uniform vec3 cameraRight;
+uniform vec3 cameraUp;
+
+void main() {
+  fragTexCoord = vertTexCoord;
+
+  // Build the quad from the camera axes so it always faces the camera
+  vec3 position = origin + cameraRight * corner.x + cameraUp * corner.y;
+
+  gl_Position = projection * view * vec4(position, 1.0);
+}
@@ -0,0 +1,11 @@
+#version 410
+
+uniform sampler2D diffuse;
+
+in vec2 fragTexCoord;
+out vec4 fragColor;
+
+void main() {
+  // Liquids don't have a lightmap and are drawn fully bright
+  fragColor = texture(diffuse, fragTexCoord.st);
+}
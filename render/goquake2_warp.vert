@@ -0,0 +1,19 @@
+#version 410
+layout (location = 0) in vec3 position;
+layout (location = 1) in vec2 vertTexCoord;
+out vec2 fragTexCoord;
+
+uniform mat4 model;
+uniform mat4 view;
+uniform mat4 projection;
+uniform float time;
+uniform vec2 textureSize;
+
+void main() {
+  // Each coordinate is moved by up to 8 texels, depending on the other coordinate
+  vec2 texel = vertTexCoord * textureSize;
+  vec2 turbulence = 8.0 * sin(texel.ts * 0.125 + time);
+  fragTexCoord = (texel + turbulence) / textureSize;
+
+  gl_Position = projection * view * model * vec4(position, 1.0);
+}
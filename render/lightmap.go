@@ -2,17 +2,38 @@ package render
 
 import (
 	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/samuelyuan/go-quake2/q2file"
 )
 
 const (
 	LIGHTMAP_SIZE = int32(512)
+	// Border around each lightmap, so bilinear filtering doesn't blend neighbouring faces
+	LIGHTMAP_PADDING = int32(1)
+	// Center of the white texel allocated first on page 0 (for non-lightmapped faces)
+	LIGHTMAP_WHITE_UV = (float32(LIGHTMAP_PADDING) + 0.5) / float32(LIGHTMAP_SIZE)
 )
 
+// Lightmaps are packed into as many pages as the map needs
 type MapLightmap struct {
+	Pages []*LightmapPage
+
+	// Faces with light styles are redrawn when the brightness of one of their styles changes
+	StyleSurfaces []LightStyleSurface
+	StyleValues   [q2file.MaxLightStyles]float32
+}
+
+type LightmapPage struct {
 	Texture uint32
 	Root    LightmapNode
 }
 
+// The lightmap of a face is made from up to 4 layers, one for each light style
+type LightStyleSurface struct {
+	Rect   *LightmapNode
+	Offset uint32 // offset of the first layer, the other layers follow it
+	Styles []uint8
+}
+
 type LightmapNode struct {
 	X      int32
 	Y      int32
@@ -20,14 +41,33 @@ type LightmapNode struct {
 	Height int32
 	Nodes  []LightmapNode
 	Filled bool
+	Page   int
 }
 
 func NewLightmap() *MapLightmap {
-	textureId := generateTexture()
+	// Every style starts at normal brightness until the first update
+	styleValues := [q2file.MaxLightStyles]float32{}
+	for i := range styleValues {
+		styleValues[i] = 1.0
+	}
+
+	lightmap := &MapLightmap{
+		Pages:         []*LightmapPage{},
+		StyleSurfaces: []LightStyleSurface{},
+		StyleValues:   styleValues,
+	}
 
+	// The first rectangle is in the top left corner of the first page
+	whiteRect := lightmap.AllocateRect(1, 1)
+	whitePixel := []uint8{255, 255, 255, 255}
+	lightmap.updateSubTexture(whiteRect, whitePixel)
+	return lightmap
+}
+
+func newLightmapPage() *LightmapPage {
 	// Setup BSP tree here
-	return &MapLightmap{
-		Texture: textureId,
+	return &LightmapPage{
+		Texture: generateTexture(),
 		Root: LightmapNode{
 			X:      0,
 			Y:      0,
@@ -49,70 +89,189 @@ func generateTexture() uint32 {
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_LINEAR)
 
-	// Set the last pixel to white (for non-lightmapped faces)
-	whitePixel := []uint8{255, 255, 255, 255}
-	gl.TexSubImage2D(gl.TEXTURE_2D, 0, LIGHTMAP_SIZE-1, LIGHTMAP_SIZE-1, 1, 1, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(whitePixel))
-
 	return textureId
 }
 
+// Find space for a lightmap with a border around it, opening a new page when the others are full
+// The returned rectangle is the inside of the border, nil if the lightmap is larger than a page
+func (lightmap *MapLightmap) AllocateRect(width int32, height int32) *LightmapNode {
+	paddedWidth := width + 2*LIGHTMAP_PADDING
+	paddedHeight := height + 2*LIGHTMAP_PADDING
+	if paddedWidth > LIGHTMAP_SIZE || paddedHeight > LIGHTMAP_SIZE {
+		return nil
+	}
+
+	for page := 0; ; page++ {
+		if page == len(lightmap.Pages) {
+			lightmap.Pages = append(lightmap.Pages, newLightmapPage())
+		}
+		paddedRect := AllocateLightmapRect(&lightmap.Pages[page].Root, paddedWidth, paddedHeight)
+		if paddedRect != nil {
+			return &LightmapNode{
+				X:      paddedRect.X + LIGHTMAP_PADDING,
+				Y:      paddedRect.Y + LIGHTMAP_PADDING,
+				Width:  width,
+				Height: height,
+				Nodes:  []LightmapNode{},
+				Filled: true,
+				Page:   page,
+			}
+		}
+	}
+}
+
 func (lightmap *MapLightmap) GenerateMipmaps() {
-	gl.BindTexture(gl.TEXTURE_2D, lightmap.Texture)
-	gl.GenerateMipmap(gl.TEXTURE_2D)
+	for _, page := range lightmap.Pages {
+		gl.BindTexture(gl.TEXTURE_2D, page.Texture)
+		gl.GenerateMipmap(gl.TEXTURE_2D)
+	}
+}
+
+func (lightmap *MapLightmap) GetPageTexture(page int) uint32 {
+	return lightmap.Pages[page].Texture
+}
+
+// Quake 3 lightmaps are brighter, since the engine only shifts them by one overbright bit
+func (lightmap *MapLightmap) CopyQ3LightmapToTexture(lightmapData []uint8, destinationNode *LightmapNode) {
+	lightmap.copyScaledLightmapToTexture(0, lightmapData, destinationNode, destinationNode.Width*destinationNode.Height, 2)
 }
 
-func (lightmap *MapLightmap) CopyMapLightmapToTexture(
+func (lightmap *MapLightmap) copyScaledLightmapToTexture(
 	arrayOffset uint32,
 	lightmapData []uint8,
 	destinationNode *LightmapNode,
 	totalPixels int32,
+	lightScale int,
 ) {
 	// Each pixel has 4 values for RGBA
 	pixels := make([]uint8, totalPixels*4)
-	curByteWrite := 0
 
 	baseIndexRead := int(arrayOffset)
 	for i := 0; i < int(totalPixels); i++ {
 		// Change the brightness
-		lightScale := 4
 		r := int(lightmapData[baseIndexRead+0]) * lightScale
 		g := int(lightmapData[baseIndexRead+1]) * lightScale
 		b := int(lightmapData[baseIndexRead+2]) * lightScale
-		max := 0
-		if r > g {
-			max = r
-		} else {
-			max = g
+		setLightmapPixel(pixels, i, r, g, b)
+
+		// read only 3 components
+		baseIndexRead += 3
+	}
+
+	lightmap.updateSubTexture(destinationNode, pixels)
+}
+
+// Add up the layers of a face, each scaled by the brightness of its style
+func (lightmap *MapLightmap) copyStyledLightmapToTexture(lightmapData []uint8, surface LightStyleSurface) {
+	totalPixels := int(surface.Rect.Width * surface.Rect.Height)
+	pixels := make([]uint8, totalPixels*4)
+
+	for i := 0; i < totalPixels; i++ {
+		var r, g, b float32
+		for layer, style := range surface.Styles {
+			scale := lightmap.StyleValues[style] * 4
+			baseIndexRead := int(surface.Offset) + (layer*totalPixels+i)*3
+			r += float32(lightmapData[baseIndexRead+0]) * scale
+			g += float32(lightmapData[baseIndexRead+1]) * scale
+			b += float32(lightmapData[baseIndexRead+2]) * scale
 		}
-		if b > max {
-			max = b
+		setLightmapPixel(pixels, i, int(r), int(g), int(b))
+	}
+
+	lightmap.updateSubTexture(surface.Rect, pixels)
+}
+
+// Add a face with light styles and draw it at the current style brightness
+func (lightmap *MapLightmap) AddStyleSurface(lightmapData []uint8, surface LightStyleSurface) {
+	lightmap.StyleSurfaces = append(lightmap.StyleSurfaces, surface)
+	lightmap.copyStyledLightmapToTexture(lightmapData, surface)
+}
+
+// Redraw the faces whose styles changed brightness since the last update
+// Most styles only change every 0.1 seconds, so most frames don't upload anything
+func (lightmap *MapLightmap) UpdateLightStyles(lightmapData []uint8, styleValues [q2file.MaxLightStyles]float32) {
+	changedStyles := [q2file.MaxLightStyles]bool{}
+	anyChanged := false
+	for i := range styleValues {
+		if styleValues[i] != lightmap.StyleValues[i] {
+			changedStyles[i] = true
+			anyChanged = true
 		}
-		// Rescale color components if any component exceeds the maximum value (255)
-		if max > 255 {
-			t := float32(255.0) / float32(max)
+	}
+	if !anyChanged {
+		return
+	}
+	lightmap.StyleValues = styleValues
 
-			r = int(float32(r) * t)
-			g = int(float32(g) * t)
-			b = int(float32(b) * t)
+	updatedPages := make([]bool, len(lightmap.Pages))
+	for _, surface := range lightmap.StyleSurfaces {
+		for _, style := range surface.Styles {
+			if changedStyles[style] {
+				lightmap.copyStyledLightmapToTexture(lightmapData, surface)
+				updatedPages[surface.Rect.Page] = true
+				break
+			}
+		}
+	}
+	for page, updated := range updatedPages {
+		if updated {
+			gl.BindTexture(gl.TEXTURE_2D, lightmap.Pages[page].Texture)
+			gl.GenerateMipmap(gl.TEXTURE_2D)
 		}
+	}
+}
 
-		pixels[curByteWrite+0] = uint8(r)
-		pixels[curByteWrite+1] = uint8(g)
-		pixels[curByteWrite+2] = uint8(b)
-		pixels[curByteWrite+3] = 255
-		curByteWrite += 4
+// Rescale color components if any component exceeds the maximum value (255)
+func setLightmapPixel(pixels []uint8, index int, r int, g int, b int) {
+	max := 0
+	if r > g {
+		max = r
+	} else {
+		max = g
+	}
+	if b > max {
+		max = b
+	}
+	if max > 255 {
+		t := float32(255.0) / float32(max)
 
-		// read only 3 components
-		baseIndexRead += 3
+		r = int(float32(r) * t)
+		g = int(float32(g) * t)
+		b = int(float32(b) * t)
 	}
 
-	lightmap.updateSubTexture(destinationNode, pixels)
+	pixels[index*4+0] = uint8(r)
+	pixels[index*4+1] = uint8(g)
+	pixels[index*4+2] = uint8(b)
+	pixels[index*4+3] = 255
 }
 
+// Copy the lightmap into the allocated rectangle, repeating the edge pixels into the border
 func (lightmap *MapLightmap) updateSubTexture(node *LightmapNode, pixels []uint8) {
-	// Copy the lightmap into the allocated rectangle
-	gl.BindTexture(gl.TEXTURE_2D, lightmap.Texture)
-	gl.TexSubImage2D(gl.TEXTURE_2D, 0, node.X, node.Y, node.Width, node.Height, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(pixels))
+	paddedWidth := node.Width + 2*LIGHTMAP_PADDING
+	paddedHeight := node.Height + 2*LIGHTMAP_PADDING
+	paddedPixels := make([]uint8, paddedWidth*paddedHeight*4)
+	for y := int32(0); y < paddedHeight; y++ {
+		sourceY := clampInt32(y-LIGHTMAP_PADDING, 0, node.Height-1)
+		for x := int32(0); x < paddedWidth; x++ {
+			sourceX := clampInt32(x-LIGHTMAP_PADDING, 0, node.Width-1)
+			copy(paddedPixels[(y*paddedWidth+x)*4:(y*paddedWidth+x+1)*4], pixels[(sourceY*node.Width+sourceX)*4:])
+		}
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, lightmap.Pages[node.Page].Texture)
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, node.X-LIGHTMAP_PADDING, node.Y-LIGHTMAP_PADDING, paddedWidth, paddedHeight,
+		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(paddedPixels))
+}
+
+func clampInt32(value int32, min int32, max int32) int32 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
 }
 
 // Navigate the Lightmap BSP tree and find an empty spot of the right size
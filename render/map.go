@@ -2,21 +2,46 @@ package render
 
 import (
 	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
 	"github.com/samuelyuan/go-quake2/q2file"
 )
 
 const (
-	SURFACE_SKY = uint32(4)
-	FLOAT_SIZE  = 4
+	SURFACE_SKY  = uint32(4)
+	SURFACE_WARP = uint32(8)
+	FLOAT_SIZE   = 4
 )
 
+// The vertices and lightmaps of every face are uploaded once
+// Visibility changes only rewrite the index buffer
 type RenderMap struct {
 	MapTextures  []MapTexture
 	MapLightmap  *MapLightmap
 	VertexBuffer []float32
+	FaceRanges   map[int]FaceRange
+	DrawBatches  []DrawBatch
+	// Sky faces are only drawn to the depth buffer, so the sky box shows through them
+	SkyBatch DrawBatch
+	// Water, slime and lava faces are drawn with the warp shader
+	WarpBatches []DrawBatch
+
+	Vao uint32
+	Vbo uint32
+	Ebo uint32
+
+	// Reused between calls to SetVisibleFaces
+	indices []uint32
 }
 
-func CreateRenderingData(mapData *q2file.MapData, mapTextures []MapTexture, faceIds []int) RenderMap {
+// Range in the index buffer of the visible faces with the same texture and lightmap page
+type DrawBatch struct {
+	TextureId    int
+	LightmapPage int
+	IndexOffset  int32
+	IndexCount   int32
+}
+
+func CreateRenderingData(mapData *q2file.MapData, mapTextures []MapTexture, faceIds []int) *RenderMap {
 	surfacesByTexture := make(map[int][]Surface)
 
 	// lightmap is shared by all polygons
@@ -26,11 +51,6 @@ func CreateRenderingData(mapData *q2file.MapData, mapTextures []MapTexture, face
 		faceInfo := mapData.Faces[faceId]
 		texInfo := mapData.TexInfos[faceInfo.TextureInfo]
 
-		// Hide skybox
-		if texInfo.Flags&SURFACE_SKY != 0 {
-			continue
-		}
-
 		// Get index in texture array
 		filename := convertByteArrayToString(texInfo.TextureName)
 		texId := mapData.TextureIds[filename]
@@ -43,8 +63,11 @@ func CreateRenderingData(mapData *q2file.MapData, mapTextures []MapTexture, face
 		}
 
 		faceVertices := getAllFaceVertices(mapData, faceInfo)
-		surface := NewSurface(faceVertices, texInfo, mapTexture.Width, mapTexture.Height)
-		surface.UpdateLightmap(lightmap, faceVertices, texInfo, faceInfo.LightmapOffset, mapData)
+		if texInfo.Flags&SURFACE_WARP != 0 {
+			faceVertices = getWarpFaceVertices(mapData, faceInfo)
+		}
+		surface := NewSurface(faceId, faceVertices, texInfo, mapTexture.Width, mapTexture.Height)
+		surface.UpdateLightmap(lightmap, faceVertices, texInfo, faceInfo, mapData)
 
 		// Add all triangle data for this texture
 		surfacesByTexture[texId] = append(surfacesByTexture[texId], *surface)
@@ -53,23 +76,27 @@ func CreateRenderingData(mapData *q2file.MapData, mapTextures []MapTexture, face
 	lightmap.GenerateMipmaps()
 
 	polygonBuffer := NewPolygonBuffer(surfacesByTexture, mapTextures)
-	renderMap := RenderMap{
+	return NewRenderMap(lightmap, polygonBuffer)
+}
+
+// Upload the vertex buffer and start with every face visible
+func NewRenderMap(lightmap *MapLightmap, polygonBuffer *PolygonBuffer) *RenderMap {
+	renderMap := &RenderMap{
 		MapLightmap:  lightmap,
 		MapTextures:  polygonBuffer.MapTextures,
 		VertexBuffer: polygonBuffer.Buffer,
+		FaceRanges:   polygonBuffer.FaceRanges,
 	}
-	return renderMap
-}
 
-func DrawMap(renderer *Renderer, renderMap RenderMap) {
-	programShader := renderer.Shader.ProgramShader
-	gl.BindVertexArray(renderer.Vao)
-	gl.BindBuffer(gl.ARRAY_BUFFER, renderer.Vbo)
+	gl.GenVertexArrays(1, &renderMap.Vao)
+	gl.BindVertexArray(renderMap.Vao)
 
 	vertices := renderMap.VertexBuffer
-
-	// Fill vertex buffer
-	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*FLOAT_SIZE, gl.Ptr(vertices), gl.STATIC_DRAW)
+	gl.GenBuffers(1, &renderMap.Vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, renderMap.Vbo)
+	if len(vertices) > 0 {
+		gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*FLOAT_SIZE, gl.Ptr(vertices), gl.STATIC_DRAW)
+	}
 
 	// 3 floats for vertex, 2 floats for texture UV, 2 floats for lightmap UV
 	stride := int32(TexturedVertexSize * FLOAT_SIZE)
@@ -86,33 +113,181 @@ func DrawMap(renderer *Renderer, renderMap RenderMap) {
 	gl.VertexAttribPointer(2, 2, gl.FLOAT, false, stride, gl.PtrOffset(5*FLOAT_SIZE))
 	gl.EnableVertexAttribArray(2)
 
+	// The index buffer is part of the vertex array state
+	// Each face is drawn at most once, so it never needs more indices than there are vertices
+	maxIndices := 0
+	for _, faceRange := range renderMap.FaceRanges {
+		maxIndices += int(faceRange.VertCount)
+	}
+	renderMap.indices = make([]uint32, 0, maxIndices)
+	gl.GenBuffers(1, &renderMap.Ebo)
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, renderMap.Ebo)
+	if maxIndices > 0 {
+		gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, maxIndices*4, nil, gl.DYNAMIC_DRAW)
+	}
+	gl.BindVertexArray(0)
+
+	allFaceIds := make([]int, 0, len(renderMap.FaceRanges))
+	for faceId := range renderMap.FaceRanges {
+		allFaceIds = append(allFaceIds, faceId)
+	}
+	renderMap.SetVisibleFaces(allFaceIds)
+	return renderMap
+}
+
+// Rebuild the index buffer from the faces that can be seen, sorted by texture
+// Faces that aren't in the vertex buffer, such as sky faces, are skipped
+func (renderMap *RenderMap) SetVisibleFaces(faceIds []int) {
+	numPages := len(renderMap.MapLightmap.Pages)
+	facesByBatch := make([][]FaceRange, len(renderMap.MapTextures)*numPages)
+	for _, faceId := range faceIds {
+		faceRange, exists := renderMap.FaceRanges[faceId]
+		if !exists {
+			continue
+		}
+		batchIndex := faceRange.TextureId*numPages + faceRange.LightmapPage
+		facesByBatch[batchIndex] = append(facesByBatch[batchIndex], faceRange)
+	}
+
+	indices := renderMap.indices[:0]
+	renderMap.DrawBatches = make([]DrawBatch, 0)
+	renderMap.WarpBatches = make([]DrawBatch, 0)
+	skyFaces := make([]FaceRange, 0)
+	warpFacesByTexture := make([][]FaceRange, len(renderMap.MapTextures))
+	for batchIndex, faceRanges := range facesByBatch {
+		if len(faceRanges) == 0 {
+			continue
+		}
+		batch := DrawBatch{
+			TextureId:    batchIndex / numPages,
+			LightmapPage: batchIndex % numPages,
+			IndexOffset:  int32(len(indices)),
+		}
+		for _, faceRange := range faceRanges {
+			if faceRange.IsSky {
+				skyFaces = append(skyFaces, faceRange)
+				continue
+			}
+			if faceRange.IsWarp {
+				warpFacesByTexture[faceRange.TextureId] = append(warpFacesByTexture[faceRange.TextureId], faceRange)
+				continue
+			}
+			indices = appendFaceIndices(indices, faceRange)
+		}
+		batch.IndexCount = int32(len(indices)) - batch.IndexOffset
+		if batch.IndexCount > 0 {
+			renderMap.DrawBatches = append(renderMap.DrawBatches, batch)
+		}
+	}
+
+	// Warped faces don't use the lightmap, so they are only batched by texture
+	for textureId, faceRanges := range warpFacesByTexture {
+		if len(faceRanges) == 0 {
+			continue
+		}
+		batch := DrawBatch{
+			TextureId:   textureId,
+			IndexOffset: int32(len(indices)),
+		}
+		for _, faceRange := range faceRanges {
+			indices = appendFaceIndices(indices, faceRange)
+		}
+		batch.IndexCount = int32(len(indices)) - batch.IndexOffset
+		renderMap.WarpBatches = append(renderMap.WarpBatches, batch)
+	}
+
+	renderMap.SkyBatch = DrawBatch{IndexOffset: int32(len(indices))}
+	for _, faceRange := range skyFaces {
+		indices = appendFaceIndices(indices, faceRange)
+	}
+	renderMap.SkyBatch.IndexCount = int32(len(indices)) - renderMap.SkyBatch.IndexOffset
+
+	renderMap.indices = indices
+
+	// The buffer was sized for every face, so only the used part is updated
+	gl.BindVertexArray(renderMap.Vao)
+	if len(indices) > 0 {
+		gl.BufferSubData(gl.ELEMENT_ARRAY_BUFFER, 0, len(indices)*4, gl.Ptr(indices))
+	}
+	gl.BindVertexArray(0)
+}
+
+func appendFaceIndices(indices []uint32, faceRange FaceRange) []uint32 {
+	for i := uint32(0); i < faceRange.VertCount; i++ {
+		indices = append(indices, faceRange.VertOffset+i)
+	}
+	return indices
+}
+
+// Build the rendering data for the faces of a single model
+// Model 0 is the world, the other models are brush entities like doors and platforms
+func CreateModelRenderingData(mapData *q2file.MapData, mapTextures []MapTexture, modelIds []int) *RenderMap {
+	faceIds := make([]int, 0)
+	for _, modelId := range modelIds {
+		faceIds = append(faceIds, GetModelFaceIds(mapData, modelId)...)
+	}
+	return CreateRenderingData(mapData, mapTextures, faceIds)
+}
+
+func GetModelFaceIds(mapData *q2file.MapData, modelId int) []int {
+	model := mapData.Models[modelId]
+	faceIds := make([]int, int(model.NumFaces))
+	for offset := 0; offset < int(model.NumFaces); offset++ {
+		faceIds[offset] = int(model.FirstFace) + offset
+	}
+	return faceIds
+}
+
+// Redraw the lightmaps of faces with flickering or switchable lights
+func UpdateLightStyles(renderMap *RenderMap, mapData *q2file.MapData, styleValues [q2file.MaxLightStyles]float32) {
+	renderMap.MapLightmap.UpdateLightStyles(mapData.LightmapData, styleValues)
+}
+
+func DrawMap(renderer *Renderer, renderMap *RenderMap, elapsedTime float64) {
+	programShader := renderer.Shader.ProgramShader
+	gl.BindVertexArray(renderMap.Vao)
+
 	diffuseUniform := gl.GetUniformLocation(programShader, gl.Str("diffuse\x00"))
 	gl.Uniform1i(diffuseUniform, 0)
 
-	// Bind the lightmap texture shared by all the faces
-	gl.ActiveTexture(gl.TEXTURE1)
-	gl.BindTexture(gl.TEXTURE_2D, (*renderMap.MapLightmap).Texture)
 	lightmapUniform := gl.GetUniformLocation(programShader, gl.Str("lightmap\x00"))
 	gl.Uniform1i(lightmapUniform, 1)
 
-	// Since faces are sorted by texture, we loop through all textures in the map
-	mapTextures := renderMap.MapTextures
-	for i := 0; i < len(mapTextures); i++ {
-		texture := mapTextures[i]
-
-		if texture.VertCount == 0 {
-			continue
-		}
+	// Sky faces hide the world behind them, like in the game
+	if renderMap.SkyBatch.IndexCount > 0 {
+		gl.ColorMask(false, false, false, false)
+		gl.DrawElements(gl.TRIANGLES, renderMap.SkyBatch.IndexCount, gl.UNSIGNED_INT, gl.PtrOffset(int(renderMap.SkyBatch.IndexOffset)*4))
+		gl.ColorMask(true, true, true, true)
+	}
 
+	// Since faces are sorted by texture and lightmap page, each batch is drawn with one call
+	for _, batch := range renderMap.DrawBatches {
 		// Bind the texture
 		gl.ActiveTexture(gl.TEXTURE0)
-		gl.BindTexture(gl.TEXTURE_2D, texture.Id)
+		gl.BindTexture(gl.TEXTURE_2D, renderMap.MapTextures[batch.TextureId].Id)
+
+		// Bind the lightmap page
+		gl.ActiveTexture(gl.TEXTURE1)
+		gl.BindTexture(gl.TEXTURE_2D, renderMap.MapLightmap.GetPageTexture(batch.LightmapPage))
 
-		// Draw all faces for this texture
-		gl.DrawArrays(gl.TRIANGLES, texture.VertOffset, texture.VertCount)
+		// Draw all visible faces for this batch
+		gl.DrawElements(gl.TRIANGLES, batch.IndexCount, gl.UNSIGNED_INT, gl.PtrOffset(int(batch.IndexOffset)*4))
 	}
+	drawWarpBatches(renderer, renderMap, elapsedTime)
+
+	gl.BindVertexArray(renderer.Vao)
+}
+
+// Draw a brush model moved by its entity, such as an opening door or a moving platform
+func DrawBrushModel(renderer *Renderer, renderMap *RenderMap, origin [3]float32, angles [3]float32, elapsedTime float64) {
+	modelMatrix := mgl32.Translate3D(origin[0], origin[1], origin[2])
+	modelMatrix = modelMatrix.Mul4(mgl32.HomogRotate3DZ(mgl32.DegToRad(angles[1])))
+	modelMatrix = modelMatrix.Mul4(mgl32.HomogRotate3DY(mgl32.DegToRad(-angles[0])))
+	modelMatrix = modelMatrix.Mul4(mgl32.HomogRotate3DX(mgl32.DegToRad(-angles[2])))
 
-	return
+	renderer.setMapModelMatrix(modelMatrix)
+	DrawMap(renderer, renderMap, elapsedTime)
+	renderer.setMapModelMatrix(mgl32.Ident4())
 }
 
 func getAllFaceVertices(mapData *q2file.MapData, faceInfo q2file.Face) []q2file.Vertex {
@@ -123,7 +298,7 @@ func getAllFaceVertices(mapData *q2file.MapData, faceInfo q2file.Face) []q2file.
 	v1 := getEdgeVertex(mapData, int(faceInfo.FirstEdge)+1)
 
 	// Generate triangle fan from map face
-	var offset uint16
+	var offset uint32
 	for offset = 2; offset < faceInfo.NumEdges; offset++ {
 		v2 := getEdgeVertex(mapData, int(faceInfo.FirstEdge)+int(offset))
 
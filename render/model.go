@@ -0,0 +1,198 @@
+package render
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/samuelyuan/go-quake2/q2file"
+)
+
+const (
+	// 3 floats for position, 3 floats for normal
+	ModelVertexSize = 6
+	// Models are animated at 10 frames per second
+	ModelFramesPerSecond = 10.0
+)
+
+// MD2 mesh stored in its own vertex buffers
+// Every frame is uploaded, so animating only changes which part of the buffer is used
+type ModelMesh struct {
+	Vao         uint32
+	FrameVbo    uint32
+	TexCoordVbo uint32
+	SkinTexture uint32
+	NumVertices int32
+	NumFrames   int
+	MD2Model    *q2file.MD2Model
+}
+
+// A model placed in the world at an entity origin
+type ModelInstance struct {
+	Mesh       *ModelMesh
+	Origin     [3]float32
+	Angles     [3]float32 // pitch, yaw, roll in degrees
+	FirstFrame int
+	NumFrames  int
+
+	// Set by demo playback to interpolate between recorded frames instead of looping
+	FixedFrame bool
+	OldFrame   int
+	Frame      int
+	FrameLerp  float32 // 0 at the old frame, 1 at the current frame
+}
+
+func NewModelMesh(md2Model *q2file.MD2Model, skinTexture uint32) *ModelMesh {
+	mesh := &ModelMesh{
+		SkinTexture: skinTexture,
+		NumVertices: int32(len(md2Model.Triangles) * 3),
+		NumFrames:   len(md2Model.Frames),
+		MD2Model:    md2Model,
+	}
+
+	// Expand the triangles so each vertex has its own texture coordinates
+	frameBuffer := make([]float32, 0, mesh.NumFrames*int(mesh.NumVertices)*ModelVertexSize)
+	for _, frame := range md2Model.Frames {
+		for _, triangle := range md2Model.Triangles {
+			for i := 0; i < 3; i++ {
+				vertexIndex := int(triangle.VertexIndices[i])
+				position := frame.GetVertex(vertexIndex)
+				normal := frame.GetNormal(vertexIndex)
+				frameBuffer = append(frameBuffer, position[0], position[1], position[2], normal[0], normal[1], normal[2])
+			}
+		}
+	}
+
+	skinWidth := float32(md2Model.Header.SkinWidth)
+	skinHeight := float32(md2Model.Header.SkinHeight)
+	texCoordBuffer := make([]float32, 0, int(mesh.NumVertices)*2)
+	for _, triangle := range md2Model.Triangles {
+		for i := 0; i < 3; i++ {
+			texCoord := md2Model.TexCoords[triangle.TexCoordIndices[i]]
+			texCoordBuffer = append(texCoordBuffer, float32(texCoord.S)/skinWidth, float32(texCoord.T)/skinHeight)
+		}
+	}
+
+	gl.GenVertexArrays(1, &mesh.Vao)
+	gl.BindVertexArray(mesh.Vao)
+
+	gl.GenBuffers(1, &mesh.FrameVbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, mesh.FrameVbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(frameBuffer)*FLOAT_SIZE, gl.Ptr(frameBuffer), gl.STATIC_DRAW)
+
+	gl.GenBuffers(1, &mesh.TexCoordVbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, mesh.TexCoordVbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(texCoordBuffer)*FLOAT_SIZE, gl.Ptr(texCoordBuffer), gl.STATIC_DRAW)
+
+	// Texture coordinates are the same for every frame
+	gl.VertexAttribPointer(4, 2, gl.FLOAT, false, 2*FLOAT_SIZE, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(4)
+
+	for attribute := uint32(0); attribute < 4; attribute++ {
+		gl.EnableVertexAttribArray(attribute)
+	}
+
+	gl.BindVertexArray(0)
+	return mesh
+}
+
+// Point the position and normal attributes at the current and next frames
+func (mesh *ModelMesh) bindFrames(frame int, nextFrame int) {
+	stride := int32(ModelVertexSize * FLOAT_SIZE)
+	frameSize := int(mesh.NumVertices) * ModelVertexSize * FLOAT_SIZE
+	offset := frame * frameSize
+	nextOffset := nextFrame * frameSize
+
+	gl.BindBuffer(gl.ARRAY_BUFFER, mesh.FrameVbo)
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, stride, gl.PtrOffset(offset))
+	gl.VertexAttribPointer(1, 3, gl.FLOAT, false, stride, gl.PtrOffset(offset+3*FLOAT_SIZE))
+	gl.VertexAttribPointer(2, 3, gl.FLOAT, false, stride, gl.PtrOffset(nextOffset))
+	gl.VertexAttribPointer(3, 3, gl.FLOAT, false, stride, gl.PtrOffset(nextOffset+3*FLOAT_SIZE))
+}
+
+// Initialize a texture from an image, such as a PCX skin
+func BuildImageTexture(img image.Image) uint32 {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	var texId uint32
+	gl.GenTextures(1, &texId)
+	gl.BindTexture(gl.TEXTURE_2D, texId)
+
+	gl.TexImage2D(uint32(gl.TEXTURE_2D), 0, int32(gl.RGBA), int32(bounds.Dx()), int32(bounds.Dy()),
+		0, uint32(gl.RGBA), uint32(gl.UNSIGNED_BYTE), gl.Ptr(rgba.Pix))
+	gl.GenerateMipmap(gl.TEXTURE_2D)
+
+	gl.TexParameteri(uint32(gl.TEXTURE_2D), gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(uint32(gl.TEXTURE_2D), gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_LINEAR)
+
+	return texId
+}
+
+// Draw all models, interpolating between animation frames using the elapsed time
+func DrawModels(renderer *Renderer, instances []ModelInstance, elapsedTime float64) {
+	programShader := renderer.ModelShader.ProgramShader
+	gl.UseProgram(programShader)
+
+	viewLoc := gl.GetUniformLocation(programShader, gl.Str("view\x00"))
+	gl.UniformMatrix4fv(viewLoc, 1, false, &renderer.ViewMatrix[0])
+	projectionLoc := gl.GetUniformLocation(programShader, gl.Str("projection\x00"))
+	gl.UniformMatrix4fv(projectionLoc, 1, false, &renderer.ProjectionMatrix[0])
+
+	modelLoc := gl.GetUniformLocation(programShader, gl.Str("model\x00"))
+	frameLerpLoc := gl.GetUniformLocation(programShader, gl.Str("frameLerp\x00"))
+	skinUniform := gl.GetUniformLocation(programShader, gl.Str("skin\x00"))
+	gl.Uniform1i(skinUniform, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+
+	animationTime := elapsedTime * ModelFramesPerSecond
+	frameTime := math.Floor(animationTime)
+	frameLerp := float32(animationTime - frameTime)
+
+	for _, instance := range instances {
+		mesh := instance.Mesh
+		if mesh == nil || mesh.NumVertices == 0 {
+			continue
+		}
+
+		numFrames := instance.NumFrames
+		if numFrames <= 0 {
+			numFrames = 1
+		}
+		frame := instance.FirstFrame + int(frameTime)%numFrames
+		nextFrame := instance.FirstFrame + (int(frameTime)+1)%numFrames
+		instanceLerp := frameLerp
+		if instance.FixedFrame {
+			frame = clampModelFrame(instance.OldFrame, mesh.NumFrames)
+			nextFrame = clampModelFrame(instance.Frame, mesh.NumFrames)
+			instanceLerp = instance.FrameLerp
+		}
+
+		modelMatrix := mgl32.Translate3D(instance.Origin[0], instance.Origin[1], instance.Origin[2])
+		modelMatrix = modelMatrix.Mul4(mgl32.HomogRotate3DZ(mgl32.DegToRad(instance.Angles[1])))
+		modelMatrix = modelMatrix.Mul4(mgl32.HomogRotate3DY(mgl32.DegToRad(-instance.Angles[0])))
+		modelMatrix = modelMatrix.Mul4(mgl32.HomogRotate3DX(mgl32.DegToRad(-instance.Angles[2])))
+		gl.UniformMatrix4fv(modelLoc, 1, false, &modelMatrix[0])
+		gl.Uniform1f(frameLerpLoc, instanceLerp)
+
+		gl.BindVertexArray(mesh.Vao)
+		mesh.bindFrames(frame, nextFrame)
+		gl.BindTexture(gl.TEXTURE_2D, mesh.SkinTexture)
+		gl.DrawArrays(gl.TRIANGLES, 0, mesh.NumVertices)
+	}
+
+	// Switch back to the map shader
+	gl.BindVertexArray(renderer.Vao)
+	gl.UseProgram(renderer.Shader.ProgramShader)
+}
+
+// Recorded frames can be out of range if the demo used a different model
+func clampModelFrame(frame int, numFrames int) int {
+	if frame < 0 || frame >= numFrames {
+		return 0
+	}
+	return frame
+}
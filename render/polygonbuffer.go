@@ -12,6 +12,17 @@ const (
 type PolygonBuffer struct {
 	Buffer      []float32 // Contains vertices, texture UV, lightmap UV
 	MapTextures []MapTexture
+	FaceRanges  map[int]FaceRange
+}
+
+// Vertices of a face in the buffer, which are drawn when the face is visible
+type FaceRange struct {
+	TextureId    int
+	LightmapPage int
+	VertOffset   uint32
+	VertCount    uint32
+	IsSky        bool
+	IsWarp       bool
 }
 
 // Rearrange data by texture
@@ -33,24 +44,23 @@ func NewPolygonBuffer(surfacesByTexture map[int][]Surface, mapTextures []MapText
 	}
 
 	polygonBuffer := &PolygonBuffer{}
-	polygonBuffer.MapTextures = make([]MapTexture, len(mapTextures))
-	// Copy
-	for index, mapTexture := range mapTextures {
-		polygonBuffer.MapTextures[index] = mapTexture
-		polygonBuffer.MapTextures[index].VertOffset = 0
-		polygonBuffer.MapTextures[index].VertCount = int32(0)
-	}
+	polygonBuffer.MapTextures = mapTextures
 	polygonBuffer.Buffer = make([]float32, bufferSize)
+	polygonBuffer.FaceRanges = make(map[int]FaceRange)
 
 	bufferOffset := 0
 	for _, textureId := range texKeys {
-		// The renderer will need the offset and number of floats
-		polygonBuffer.MapTextures[textureId].VertOffset = int32(bufferOffset / TexturedVertexSize)
-		polygonBuffer.MapTextures[textureId].VertCount = int32(0)
-
 		// Fill in the buffer
 		for _, surface := range surfacesByTexture[textureId] {
-			polygonBuffer.MapTextures[textureId].VertCount += int32(len(surface.TexturedVertices))
+			// The index buffer is built from the vertex range of each visible face
+			polygonBuffer.FaceRanges[surface.FaceId] = FaceRange{
+				TextureId:    textureId,
+				LightmapPage: surface.LightmapPage,
+				VertOffset:   uint32(bufferOffset / TexturedVertexSize),
+				VertCount:    uint32(len(surface.TexturedVertices)),
+				IsSky:        surface.TexInfo.Flags&SURFACE_SKY != 0,
+				IsWarp:       surface.TexInfo.Flags&SURFACE_WARP != 0,
+			}
 
 			for _, vertex := range surface.TexturedVertices {
 				polygonBuffer.setVertexPosition(bufferOffset, vertex)
@@ -0,0 +1,104 @@
+package render
+
+import (
+	"github.com/samuelyuan/go-quake2/q2file"
+)
+
+// Build the rendering data for Quake 3 faces, with the same buffer layout as Quake 2 faces
+// Patches are tessellated into triangles and each 128x128 lightmap gets its own space in the shared lightmap
+func CreateQ3RenderingData(
+	mapData *q2file.Q3MapData,
+	mapTextures []MapTexture,
+	faceIds []int,
+	tessellationLevel int,
+) *RenderMap {
+	surfacesByTexture := make(map[int][]Surface)
+
+	// lightmap is shared by all polygons
+	lightmap := NewLightmap()
+	lightmapRects := make(map[int]*LightmapNode)
+
+	for _, faceId := range faceIds {
+		face := mapData.Faces[faceId]
+		shader := mapData.Shaders[face.Shader]
+
+		// Hide skybox and faces that are only used for collision
+		if uint32(shader.Flags)&(q2file.SurfaceSky|q2file.SurfaceNoDraw) != 0 {
+			continue
+		}
+
+		triangles := mapData.GetFaceTriangles(face, tessellationLevel)
+		if len(triangles) == 0 {
+			continue
+		}
+
+		texId := int(face.Shader)
+		lightmapRect := getQ3LightmapRect(lightmap, lightmapRects, mapData, int(face.Lightmap))
+		surface := NewQ3Surface(faceId, triangles, lightmapRect)
+		surfacesByTexture[texId] = append(surfacesByTexture[texId], *surface)
+	}
+
+	lightmap.GenerateMipmaps()
+
+	polygonBuffer := NewPolygonBuffer(surfacesByTexture, mapTextures)
+	return NewRenderMap(lightmap, polygonBuffer)
+}
+
+func GetQ3ModelFaceIds(mapData *q2file.Q3MapData, modelId int) []int {
+	model := mapData.Models[modelId]
+	faceIds := make([]int, int(model.NumFaces))
+	for offset := 0; offset < int(model.NumFaces); offset++ {
+		faceIds[offset] = int(model.FirstFace) + offset
+	}
+	return faceIds
+}
+
+// The texture coordinates are already divided by the texture size
+// Lightmap coordinates are moved into the space of the face's lightmap
+func NewQ3Surface(faceId int, triangles []q2file.Q3Vertex, lightmapRect *LightmapNode) *Surface {
+	surface := &Surface{}
+	surface.FaceId = faceId
+	surface.TexturedVertices = make([]TexturedVertex, len(triangles))
+	for i, vertex := range triangles {
+		texturedVertex := TexturedVertex{
+			X:        vertex.Position[0],
+			Y:        vertex.Position[1],
+			Z:        vertex.Position[2],
+			TextureU: vertex.TexCoord[0],
+			TextureV: vertex.TexCoord[1],
+			LightU:   LIGHTMAP_WHITE_UV,
+			LightV:   LIGHTMAP_WHITE_UV,
+		}
+
+		if lightmapRect != nil {
+			surface.LightmapPage = lightmapRect.Page
+			texturedVertex.LightU = (float32(lightmapRect.X) + vertex.LightUV[0]*float32(lightmapRect.Width)) / float32(LIGHTMAP_SIZE)
+			texturedVertex.LightV = (float32(lightmapRect.Y) + vertex.LightUV[1]*float32(lightmapRect.Height)) / float32(LIGHTMAP_SIZE)
+		}
+		surface.TexturedVertices[i] = texturedVertex
+	}
+	return surface
+}
+
+// Copy each lightmap into the shared lightmap the first time a face uses it
+// Faces without a lightmap are fully bright
+func getQ3LightmapRect(
+	lightmap *MapLightmap,
+	lightmapRects map[int]*LightmapNode,
+	mapData *q2file.Q3MapData,
+	lightmapIndex int,
+) *LightmapNode {
+	if lightmapIndex < 0 || lightmapIndex >= len(mapData.Lightmaps) {
+		return nil
+	}
+	if lightmapRect, exists := lightmapRects[lightmapIndex]; exists {
+		return lightmapRect
+	}
+
+	lightmapRect := lightmap.AllocateRect(q2file.Q3LightmapSize, q2file.Q3LightmapSize)
+	if lightmapRect != nil {
+		lightmap.CopyQ3LightmapToTexture(mapData.Lightmaps[lightmapIndex], lightmapRect)
+	}
+	lightmapRects[lightmapIndex] = lightmapRect
+	return lightmapRect
+}
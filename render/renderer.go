@@ -8,9 +8,19 @@ import (
 )
 
 type Renderer struct {
-	Vao    uint32
-	Vbo    uint32
-	Shader *Shader
+	Vao          uint32
+	Vbo          uint32
+	Shader       *Shader
+	ModelShader  *Shader
+	SpriteShader *Shader
+	SkyShader    *Shader
+	WarpShader   *Shader
+
+	// Camera matrices for the current frame
+	ViewMatrix       mgl32.Mat4
+	ProjectionMatrix mgl32.Mat4
+	// Transform of the brush model being drawn, shared with the warp shader
+	MapModelMatrix mgl32.Mat4
 }
 
 func NewRenderer() *Renderer {
@@ -26,6 +36,10 @@ func (r *Renderer) Init() {
 	fmt.Println("OpenGL version", version)
 
 	r.Shader = NewShader("render/goquake2.vert", "render/goquake2.frag")
+	r.ModelShader = NewShader("render/goquake2_model.vert", "render/goquake2_model.frag")
+	r.SpriteShader = NewShader("render/goquake2_sprite.vert", "render/goquake2_sprite.frag")
+	r.SkyShader = NewShader("render/goquake2_sky.vert", "render/goquake2_sky.frag")
+	r.WarpShader = NewShader("render/goquake2_warp.vert", "render/goquake2_warp.frag")
 
 	gl.ClearColor(0.0, 0.0, 0.0, 1.0)
 	gl.Enable(gl.DEPTH_TEST)
@@ -45,6 +59,9 @@ func (r *Renderer) Init() {
 func (r *Renderer) PrepareFrame(viewMatrix mgl32.Mat4, projectionMatrix mgl32.Mat4) {
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 
+	r.ViewMatrix = viewMatrix
+	r.ProjectionMatrix = projectionMatrix
+
 	programShader := r.Shader.ProgramShader
 
 	gl.UseProgram(programShader)
@@ -55,4 +72,13 @@ func (r *Renderer) PrepareFrame(viewMatrix mgl32.Mat4, projectionMatrix mgl32.Ma
 
 	projectionLoc := gl.GetUniformLocation(programShader, gl.Str("projection\x00"))
 	gl.UniformMatrix4fv(projectionLoc, 1, false, &projectionMatrix[0])
+
+	// The world is drawn without any transform, only brush models are moved
+	r.setMapModelMatrix(mgl32.Ident4())
+}
+
+func (r *Renderer) setMapModelMatrix(modelMatrix mgl32.Mat4) {
+	r.MapModelMatrix = modelMatrix
+	modelLoc := gl.GetUniformLocation(r.Shader.ProgramShader, gl.Str("model\x00"))
+	gl.UniformMatrix4fv(modelLoc, 1, false, &modelMatrix[0])
 }
@@ -0,0 +1,156 @@
+package render
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// 3 floats for the direction of each corner
+	SkyVertexSize  = 3
+	skyNumVertices = 36
+)
+
+// Image suffixes of the env/ skybox, in the order used by Quake 2
+var SkySuffixes = [6]string{"rt", "bk", "lf", "ft", "up", "dn"}
+
+// The cube map face for each image in SkySuffixes, after the shader swaps the Quake 2 axes
+// The up and down images are turned upside down to line up with the sides
+var skyCubeFaces = [6]struct {
+	target  uint32
+	rotated bool
+}{
+	{gl.TEXTURE_CUBE_MAP_NEGATIVE_Z, false}, // rt looks along +x
+	{gl.TEXTURE_CUBE_MAP_POSITIVE_X, false}, // bk looks along +y
+	{gl.TEXTURE_CUBE_MAP_POSITIVE_Z, false}, // lf looks along -x
+	{gl.TEXTURE_CUBE_MAP_NEGATIVE_X, false}, // ft looks along -y
+	{gl.TEXTURE_CUBE_MAP_POSITIVE_Y, true},  // up looks along +z
+	{gl.TEXTURE_CUBE_MAP_NEGATIVE_Y, true},  // dn looks along -z
+}
+
+// Cube map drawn behind the world, which can rotate around an axis over time
+type SkyBox struct {
+	Vao     uint32
+	Vbo     uint32
+	Texture uint32
+	Rotate  float32 // degrees per second
+	Axis    [3]float32
+}
+
+// Images are given in the order of SkySuffixes
+func NewSkyBox(images [6]image.Image, rotate float32, axis [3]float32) *SkyBox {
+	skyBox := &SkyBox{
+		Rotate: rotate,
+		Axis:   axis,
+	}
+
+	gl.GenTextures(1, &skyBox.Texture)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, skyBox.Texture)
+	for i, img := range images {
+		rgba := toSkyRGBA(img, skyCubeFaces[i].rotated)
+		size := rgba.Bounds().Size()
+		gl.TexImage2D(skyCubeFaces[i].target, 0, int32(gl.RGBA), int32(size.X), int32(size.Y),
+			0, uint32(gl.RGBA), uint32(gl.UNSIGNED_BYTE), gl.Ptr(rgba.Pix))
+	}
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_R, gl.CLAMP_TO_EDGE)
+
+	// Two triangles for each side of a cube around the camera
+	corners := [8][3]float32{
+		{-1, -1, -1}, {1, -1, -1}, {1, 1, -1}, {-1, 1, -1},
+		{-1, -1, 1}, {1, -1, 1}, {1, 1, 1}, {-1, 1, 1},
+	}
+	sides := [6][4]int{
+		{0, 1, 2, 3}, {4, 5, 6, 7}, {0, 1, 5, 4},
+		{2, 3, 7, 6}, {1, 2, 6, 5}, {3, 0, 4, 7},
+	}
+	buffer := make([]float32, 0, skyNumVertices*SkyVertexSize)
+	for _, side := range sides {
+		for _, corner := range []int{side[0], side[1], side[2], side[0], side[2], side[3]} {
+			buffer = append(buffer, corners[corner][0], corners[corner][1], corners[corner][2])
+		}
+	}
+
+	gl.GenVertexArrays(1, &skyBox.Vao)
+	gl.BindVertexArray(skyBox.Vao)
+
+	gl.GenBuffers(1, &skyBox.Vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, skyBox.Vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(buffer)*FLOAT_SIZE, gl.Ptr(buffer), gl.STATIC_DRAW)
+
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, int32(SkyVertexSize*FLOAT_SIZE), gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(0)
+
+	gl.BindVertexArray(0)
+	return skyBox
+}
+
+// Copy the image into RGBA pixels, turning it upside down for the up and down faces
+func toSkyRGBA(img image.Image, rotated bool) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	if !rotated {
+		return rgba
+	}
+
+	// Turning the image upside down is the same as reversing the pixels
+	turned := image.NewRGBA(rgba.Bounds())
+	numPixels := len(rgba.Pix) / 4
+	for i := 0; i < numPixels; i++ {
+		copy(turned.Pix[i*4:i*4+4], rgba.Pix[(numPixels-1-i)*4:])
+	}
+	return turned
+}
+
+// Draw the sky first, without depth, so everything else is drawn in front of it
+func DrawSkyBox(renderer *Renderer, skyBox *SkyBox, elapsedTime float64) {
+	if skyBox == nil {
+		return
+	}
+
+	programShader := renderer.SkyShader.ProgramShader
+	gl.UseProgram(programShader)
+
+	viewLoc := gl.GetUniformLocation(programShader, gl.Str("view\x00"))
+	gl.UniformMatrix4fv(viewLoc, 1, false, &renderer.ViewMatrix[0])
+	projectionLoc := gl.GetUniformLocation(programShader, gl.Str("projection\x00"))
+	gl.UniformMatrix4fv(projectionLoc, 1, false, &renderer.ProjectionMatrix[0])
+
+	// The directions are turned the opposite way to make the sky look like it turns
+	skyRotation := mgl32.Ident4()
+	axis := mgl32.Vec3(skyBox.Axis)
+	if skyBox.Rotate != 0 && axis.Len() > 0 {
+		angle := mgl32.DegToRad(float32(float64(skyBox.Rotate) * elapsedTime))
+		skyRotation = mgl32.HomogRotate3D(-angle, axis.Normalize())
+	}
+	skyRotationLoc := gl.GetUniformLocation(programShader, gl.Str("skyRotation\x00"))
+	gl.UniformMatrix4fv(skyRotationLoc, 1, false, &skyRotation[0])
+
+	skyUniform := gl.GetUniformLocation(programShader, gl.Str("sky\x00"))
+	gl.Uniform1i(skyUniform, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, skyBox.Texture)
+
+	// The camera is inside the cube, so both sides are drawn
+	gl.Disable(gl.CULL_FACE)
+	gl.Disable(gl.DEPTH_TEST)
+	gl.DepthMask(false)
+
+	gl.BindVertexArray(skyBox.Vao)
+	gl.DrawArrays(gl.TRIANGLES, 0, skyNumVertices)
+
+	gl.DepthMask(true)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.CULL_FACE)
+
+	// Switch back to the map shader
+	gl.BindVertexArray(renderer.Vao)
+	gl.UseProgram(renderer.Shader.ProgramShader)
+}
@@ -0,0 +1,149 @@
+package render
+
+import (
+	"math"
+	"sort"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/samuelyuan/go-quake2/q2file"
+)
+
+const (
+	// 2 floats for the corner offset, 2 floats for texture UV
+	SpriteVertexSize = 4
+	// Each frame is a quad drawn as a triangle fan
+	spriteVerticesPerFrame = 4
+	// Sprites are animated at 10 frames per second
+	SpriteFramesPerSecond = 10.0
+)
+
+// SP2 sprite with one texture for each frame
+type SpriteMesh struct {
+	Vao           uint32
+	Vbo           uint32
+	FrameTextures []uint32
+	NumFrames     int
+}
+
+type SpriteInstance struct {
+	Mesh    *SpriteMesh
+	Origin  [3]float32
+	Frame   int
+	Animate bool    // loop through all frames instead of using Frame
+	Alpha   float32 // 1 for fully opaque
+}
+
+func NewSpriteMesh(sprite *q2file.SP2Sprite, frameTextures []uint32) *SpriteMesh {
+	mesh := &SpriteMesh{
+		FrameTextures: frameTextures,
+		NumFrames:     len(sprite.Frames),
+	}
+
+	// The origin is measured from the bottom left of the image
+	buffer := make([]float32, 0, len(sprite.Frames)*spriteVerticesPerFrame*SpriteVertexSize)
+	for _, frame := range sprite.Frames {
+		left := float32(-frame.OriginX)
+		right := float32(frame.Width - frame.OriginX)
+		bottom := float32(-frame.OriginY)
+		top := float32(frame.Height - frame.OriginY)
+
+		buffer = append(buffer,
+			left, bottom, 0, 1,
+			left, top, 0, 0,
+			right, top, 1, 0,
+			right, bottom, 1, 1,
+		)
+	}
+
+	gl.GenVertexArrays(1, &mesh.Vao)
+	gl.BindVertexArray(mesh.Vao)
+
+	gl.GenBuffers(1, &mesh.Vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, mesh.Vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(buffer)*FLOAT_SIZE, gl.Ptr(buffer), gl.STATIC_DRAW)
+
+	stride := int32(SpriteVertexSize * FLOAT_SIZE)
+	gl.VertexAttribPointer(0, 2, gl.FLOAT, false, stride, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(0)
+	gl.VertexAttribPointer(1, 2, gl.FLOAT, false, stride, gl.PtrOffset(2*FLOAT_SIZE))
+	gl.EnableVertexAttribArray(1)
+
+	gl.BindVertexArray(0)
+	return mesh
+}
+
+// Draw sprites after the world so they blend on top of it
+// Sprites are sorted from back to front and don't write to the depth buffer
+func DrawSprites(renderer *Renderer, instances []SpriteInstance, elapsedTime float64) {
+	if len(instances) == 0 {
+		return
+	}
+
+	programShader := renderer.SpriteShader.ProgramShader
+	gl.UseProgram(programShader)
+
+	viewMatrix := renderer.ViewMatrix
+	viewLoc := gl.GetUniformLocation(programShader, gl.Str("view\x00"))
+	gl.UniformMatrix4fv(viewLoc, 1, false, &viewMatrix[0])
+	projectionLoc := gl.GetUniformLocation(programShader, gl.Str("projection\x00"))
+	gl.UniformMatrix4fv(projectionLoc, 1, false, &renderer.ProjectionMatrix[0])
+
+	// The rows of the view rotation are the camera axes in world space
+	cameraRight := mgl32.Vec3{viewMatrix[0], viewMatrix[4], viewMatrix[8]}
+	cameraUp := mgl32.Vec3{viewMatrix[1], viewMatrix[5], viewMatrix[9]}
+	cameraRightLoc := gl.GetUniformLocation(programShader, gl.Str("cameraRight\x00"))
+	gl.Uniform3f(cameraRightLoc, cameraRight[0], cameraRight[1], cameraRight[2])
+	cameraUpLoc := gl.GetUniformLocation(programShader, gl.Str("cameraUp\x00"))
+	gl.Uniform3f(cameraUpLoc, cameraUp[0], cameraUp[1], cameraUp[2])
+
+	originLoc := gl.GetUniformLocation(programShader, gl.Str("origin\x00"))
+	alphaLoc := gl.GetUniformLocation(programShader, gl.Str("alpha\x00"))
+	diffuseUniform := gl.GetUniformLocation(programShader, gl.Str("diffuse\x00"))
+	gl.Uniform1i(diffuseUniform, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+
+	cameraPosition := viewMatrix.Inv().Col(3).Vec3()
+	sortedInstances := make([]SpriteInstance, len(instances))
+	copy(sortedInstances, instances)
+	sort.SliceStable(sortedInstances, func(i, j int) bool {
+		distanceI := mgl32.Vec3(sortedInstances[i].Origin).Sub(cameraPosition).LenSqr()
+		distanceJ := mgl32.Vec3(sortedInstances[j].Origin).Sub(cameraPosition).LenSqr()
+		return distanceI > distanceJ
+	})
+
+	// Both sides of the quad are visible and it shouldn't hide anything behind it
+	gl.Disable(gl.CULL_FACE)
+	gl.DepthMask(false)
+
+	animationFrame := int(math.Floor(elapsedTime * SpriteFramesPerSecond))
+	for _, instance := range sortedInstances {
+		mesh := instance.Mesh
+		if mesh == nil || mesh.NumFrames == 0 {
+			continue
+		}
+
+		frame := instance.Frame
+		if instance.Animate {
+			frame = animationFrame
+		}
+		frame = frame % mesh.NumFrames
+		if frame < 0 {
+			frame += mesh.NumFrames
+		}
+
+		gl.Uniform3f(originLoc, instance.Origin[0], instance.Origin[1], instance.Origin[2])
+		gl.Uniform1f(alphaLoc, instance.Alpha)
+
+		gl.BindVertexArray(mesh.Vao)
+		gl.BindTexture(gl.TEXTURE_2D, mesh.FrameTextures[frame])
+		gl.DrawArrays(gl.TRIANGLE_FAN, int32(frame*spriteVerticesPerFrame), spriteVerticesPerFrame)
+	}
+
+	gl.DepthMask(true)
+	gl.Enable(gl.CULL_FACE)
+
+	// Switch back to the map shader
+	gl.BindVertexArray(renderer.Vao)
+	gl.UseProgram(renderer.Shader.ProgramShader)
+}
@@ -8,8 +8,10 @@ import (
 
 // Contains all the triangles of a face to be passed to the renderer
 type Surface struct {
+	FaceId           int
 	TexInfo          q2file.TexInfo
 	TexturedVertices []TexturedVertex
+	LightmapPage     int
 }
 
 type TexturedVertex struct {
@@ -35,12 +37,14 @@ type LightmapDimensions struct {
 }
 
 func NewSurface(
+	faceId int,
 	faceVertices []q2file.Vertex,
 	texInfo q2file.TexInfo,
 	textureWidth uint32,
 	textureHeight uint32,
 ) *Surface {
 	surface := &Surface{}
+	surface.FaceId = faceId
 	surface.TexInfo = texInfo
 	surface.TexturedVertices = make([]TexturedVertex, len(faceVertices))
 	for i := 0; i < len(faceVertices); i++ {
@@ -57,8 +61,8 @@ func NewSurface(
 		texturedVertex.TextureU = uv[0] / float32(textureWidth)
 		texturedVertex.TextureV = uv[1] / float32(textureHeight)
 
-		texturedVertex.LightU = 0.999
-		texturedVertex.LightV = 0.999
+		texturedVertex.LightU = LIGHTMAP_WHITE_UV
+		texturedVertex.LightV = LIGHTMAP_WHITE_UV
 		surface.TexturedVertices[i] = texturedVertex
 	}
 
@@ -69,43 +73,129 @@ func (surface *Surface) UpdateLightmap(
 	lightmap *MapLightmap, // Update lightmap for this face
 	faceVertices []q2file.Vertex,
 	texInfo q2file.TexInfo,
-	faceLightmapOffset uint32,
+	faceInfo q2file.Face,
 	mapData *q2file.MapData,
 ) {
 	// Check if face has a lightmap
-	if texInfo.Flags == 0 {
-		lightmapDimensions := getLightmapDimensions(faceVertices, texInfo)
-		if lightmapDimensions.Height <= 0 || lightmapDimensions.Width <= 0 {
-			return
-		}
+	if texInfo.Flags != 0 {
+		return
+	}
 
-		// Navigate lightmap BSP to find correctly sized space
-		lightmapRect := AllocateLightmapRect(&lightmap.Root, lightmapDimensions.Width, lightmapDimensions.Height)
-		if lightmapRect == nil {
-			return
-		}
+	// Maps with decoupled lightmaps store the size and projection of each lightmap,
+	// which don't follow the texture axes
+	if surface.FaceId < len(mapData.DecoupledLightmaps) {
+		surface.updateDecoupledLightmap(lightmap, faceInfo, mapData.DecoupledLightmaps[surface.FaceId], mapData)
+		return
+	}
 
-		totalPixels := lightmapDimensions.Width * lightmapDimensions.Height
-		lightmap.CopyMapLightmapToTexture(faceLightmapOffset, mapData.LightmapData, lightmapRect, totalPixels)
+	lightmapDimensions := getLightmapDimensions(faceVertices, texInfo)
+	if lightmapDimensions.Height <= 0 || lightmapDimensions.Width <= 0 {
+		return
+	}
 
-		// Update lightmap texture coordinates for rendering
-		for i := 0; i < len(surface.TexturedVertices); i++ {
-			x := surface.TexturedVertices[i].X
-			y := surface.TexturedVertices[i].Y
-			z := surface.TexturedVertices[i].Z
+	// Faces without light data are left fully bright
+	totalPixels := lightmapDimensions.Width * lightmapDimensions.Height
+	styles := getFaceLightStyles(faceInfo, faceInfo.LightmapOffset, totalPixels, len(mapData.LightmapData))
+	if len(styles) == 0 {
+		return
+	}
 
-			s := ((x*texInfo.UAxis[0] + y*texInfo.UAxis[1] + z*texInfo.UAxis[2]) + texInfo.UOffset) - lightmapDimensions.MinU
-			s += float32((lightmapRect.X * 16) + 8)
-			s /= float32(LIGHTMAP_SIZE * 16)
+	// Navigate lightmap BSP to find correctly sized space
+	lightmapRect := lightmap.AllocateRect(lightmapDimensions.Width, lightmapDimensions.Height)
+	if lightmapRect == nil {
+		return
+	}
+	surface.LightmapPage = lightmapRect.Page
+
+	lightmap.AddStyleSurface(mapData.LightmapData, LightStyleSurface{
+		Rect:   lightmapRect,
+		Offset: faceInfo.LightmapOffset,
+		Styles: styles,
+	})
+
+	// Update lightmap texture coordinates for rendering
+	for i := 0; i < len(surface.TexturedVertices); i++ {
+		x := surface.TexturedVertices[i].X
+		y := surface.TexturedVertices[i].Y
+		z := surface.TexturedVertices[i].Z
+
+		s := ((x*texInfo.UAxis[0] + y*texInfo.UAxis[1] + z*texInfo.UAxis[2]) + texInfo.UOffset) - lightmapDimensions.MinU
+		s += float32((lightmapRect.X * 16) + 8)
+		s /= float32(LIGHTMAP_SIZE * 16)
+
+		t := ((x*texInfo.VAxis[0] + y*texInfo.VAxis[1] + z*texInfo.VAxis[2]) + texInfo.VOffset) - lightmapDimensions.MinV
+		t += float32((lightmapRect.Y * 16) + 8)
+		t /= float32(LIGHTMAP_SIZE * 16)
+
+		surface.TexturedVertices[i].LightU = s
+		surface.TexturedVertices[i].LightV = t
+	}
+}
 
-			t := ((x*texInfo.VAxis[0] + y*texInfo.VAxis[1] + z*texInfo.VAxis[2]) + texInfo.VOffset) - lightmapDimensions.MinV
-			t += float32((lightmapRect.Y * 16) + 8)
-			t /= float32(LIGHTMAP_SIZE * 16)
+// The projection gives lightmap texels directly, so there is no scale or texture minimum
+func (surface *Surface) updateDecoupledLightmap(
+	lightmap *MapLightmap,
+	faceInfo q2file.Face,
+	decoupled q2file.DecoupledLightmap,
+	mapData *q2file.MapData,
+) {
+	if decoupled.Offset < 0 || decoupled.Width == 0 || decoupled.Height == 0 {
+		return
+	}
 
-			surface.TexturedVertices[i].LightU = s
-			surface.TexturedVertices[i].LightV = t
+	totalPixels := int32(decoupled.Width) * int32(decoupled.Height)
+	styles := getFaceLightStyles(faceInfo, uint32(decoupled.Offset), totalPixels, len(mapData.LightmapData))
+	if len(styles) == 0 {
+		return
+	}
+
+	lightmapRect := lightmap.AllocateRect(int32(decoupled.Width), int32(decoupled.Height))
+	if lightmapRect == nil {
+		return
+	}
+	surface.LightmapPage = lightmapRect.Page
+
+	lightmap.AddStyleSurface(mapData.LightmapData, LightStyleSurface{
+		Rect:   lightmapRect,
+		Offset: uint32(decoupled.Offset),
+		Styles: styles,
+	})
+
+	axes := decoupled.WorldToLightmap
+	for i := 0; i < len(surface.TexturedVertices); i++ {
+		x := surface.TexturedVertices[i].X
+		y := surface.TexturedVertices[i].Y
+		z := surface.TexturedVertices[i].Z
+
+		// Move to the center of the texel
+		s := x*axes[0][0] + y*axes[0][1] + z*axes[0][2] + axes[0][3]
+		s += float32(lightmapRect.X) + 0.5
+		s /= float32(LIGHTMAP_SIZE)
+
+		t := x*axes[1][0] + y*axes[1][1] + z*axes[1][2] + axes[1][3]
+		t += float32(lightmapRect.Y) + 0.5
+		t /= float32(LIGHTMAP_SIZE)
+
+		surface.TexturedVertices[i].LightU = s
+		surface.TexturedVertices[i].LightV = t
+	}
+}
+
+// Get the styles of the lightmap layers stored for a face, starting at the given offset
+// The list ends at the first unused slot, or at the first layer past the end of the light data
+func getFaceLightStyles(faceInfo q2file.Face, lightmapOffset uint32, totalPixels int32, lightmapDataSize int) []uint8 {
+	styles := make([]uint8, 0, q2file.MaxFaceLightStyles)
+	for _, style := range faceInfo.LightmapSyles {
+		if style == q2file.LightStyleNone {
+			break
+		}
+		layerEnd := int64(lightmapOffset) + int64(len(styles)+1)*int64(totalPixels)*3
+		if layerEnd > int64(lightmapDataSize) {
+			break
 		}
+		styles = append(styles, style)
 	}
+	return styles
 }
 
 // Get the width and height of the lightmap
@@ -6,11 +6,11 @@ import (
 )
 
 type MapTexture struct {
-	Id         uint32
-	Width      uint32
-	Height     uint32
-	VertOffset int32
-	VertCount  int32
+	Id uint32
+	// Size of the WAL texture, which the texture coordinates are divided by
+	// This stays the same when a higher resolution replacement image is loaded
+	Width  uint32
+	Height uint32
 }
 
 func NewMapTexture(id uint32, width uint32, height uint32) MapTexture {
@@ -22,18 +22,42 @@ func NewMapTexture(id uint32, width uint32, height uint32) MapTexture {
 }
 
 // Initialize texture in OpenGL using image data
-func BuildWALTexture(imageData []uint8, walData q2file.WalHeader) uint32 {
+// The WAL mip levels are uploaded as levels 0-3 and smaller levels can be generated from the last one
+func BuildWALTexture(mipLevels [][]uint8, walData q2file.WalHeader, generateSmallerMips bool) uint32 {
+	return BuildMipTexture(mipLevels, walData.Width, walData.Height, generateSmallerMips)
+}
+
+// Same as a WAL texture, for rgb mip levels of a given full size
+// Used for Quake 1 textures, which are embedded in the map or stored in WAD files
+func BuildMipTexture(mipLevels [][]uint8, fullWidth uint32, fullHeight uint32, generateSmallerMips bool) uint32 {
 	var texId uint32
 	gl.GenTextures(1, &texId)
 	gl.BindTexture(gl.TEXTURE_2D, texId)
 
-	// Give the image to OpenGL
-	gl.TexImage2D(uint32(gl.TEXTURE_2D), 0, int32(gl.RGB), int32(walData.Width), int32(walData.Height),
-		0, uint32(gl.RGB), uint32(gl.UNSIGNED_BYTE), gl.Ptr(imageData))
+	// Rows are tightly packed RGB, which isn't a multiple of 4 bytes for small mip levels
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+
+	// Give each mip level to OpenGL
+	for level := 0; level < len(mipLevels); level++ {
+		width, height := q2file.GetWALMipSize(q2file.WalHeader{Width: fullWidth, Height: fullHeight}, level)
+		gl.TexImage2D(uint32(gl.TEXTURE_2D), int32(level), int32(gl.RGB), int32(width), int32(height),
+			0, uint32(gl.RGB), uint32(gl.UNSIGNED_BYTE), gl.Ptr(mipLevels[level]))
+	}
+
+	lastLevel := int32(len(mipLevels) - 1)
+	if generateSmallerMips {
+		// Generate the levels below the smallest WAL level without replacing the authored levels
+		gl.TexParameteri(uint32(gl.TEXTURE_2D), gl.TEXTURE_BASE_LEVEL, lastLevel)
+		gl.GenerateMipmap(gl.TEXTURE_2D)
+		gl.TexParameteri(uint32(gl.TEXTURE_2D), gl.TEXTURE_BASE_LEVEL, 0)
+	} else {
+		// The texture is only complete up to the last level that was uploaded
+		gl.TexParameteri(uint32(gl.TEXTURE_2D), gl.TEXTURE_MAX_LEVEL, lastLevel)
+	}
 
 	// Set texture wrapping/filtering options
 	gl.TexParameteri(uint32(gl.TEXTURE_2D), gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-	gl.TexParameteri(uint32(gl.TEXTURE_2D), gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(uint32(gl.TEXTURE_2D), gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_LINEAR)
 
 	return texId
 }
@@ -0,0 +1,132 @@
+package render
+
+import (
+	"math"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/samuelyuan/go-quake2/q2file"
+)
+
+const (
+	// Warped faces are split into pieces no larger than this, so the turbulence is smooth
+	WARP_SUBDIVIDE_SIZE = float32(64)
+	// Pieces thinner than this aren't split any further
+	warpMinSplitDistance = float32(8)
+)
+
+// Get the triangles of a water, slime or lava face after splitting it into small pieces
+func getWarpFaceVertices(mapData *q2file.MapData, faceInfo q2file.Face) []q2file.Vertex {
+	polygon := make([]q2file.Vertex, faceInfo.NumEdges)
+	for i := 0; i < int(faceInfo.NumEdges); i++ {
+		polygon[i] = getEdgeVertex(mapData, int(faceInfo.FirstEdge)+i)
+	}
+
+	faceVertices := make([]q2file.Vertex, 0)
+	for _, piece := range subdividePolygon(polygon) {
+		// Generate triangle fan from each piece
+		for i := 2; i < len(piece); i++ {
+			faceVertices = append(faceVertices, piece[0], piece[i-1], piece[i])
+		}
+	}
+	return faceVertices
+}
+
+// Split a polygon along the 64 unit grid lines closest to its center until every piece is small
+func subdividePolygon(polygon []q2file.Vertex) [][]q2file.Vertex {
+	mins := [3]float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	maxs := [3]float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+	for _, vertex := range polygon {
+		position := vertexToArray(vertex)
+		for axis := 0; axis < 3; axis++ {
+			mins[axis] = float32(math.Min(float64(mins[axis]), float64(position[axis])))
+			maxs[axis] = float32(math.Max(float64(maxs[axis]), float64(position[axis])))
+		}
+	}
+
+	for axis := 0; axis < 3; axis++ {
+		middle := (mins[axis] + maxs[axis]) / 2
+		middle = WARP_SUBDIVIDE_SIZE * float32(math.Floor(float64(middle/WARP_SUBDIVIDE_SIZE)+0.5))
+		if maxs[axis]-middle < warpMinSplitDistance || middle-mins[axis] < warpMinSplitDistance {
+			continue
+		}
+
+		front, back := splitPolygon(polygon, axis, middle)
+		if len(front) < 3 || len(back) < 3 {
+			continue
+		}
+		return append(subdividePolygon(front), subdividePolygon(back)...)
+	}
+	return [][]q2file.Vertex{polygon}
+}
+
+// Cut a polygon with the plane where the axis is equal to the distance
+// Vertices on the plane are kept in both halves
+func splitPolygon(polygon []q2file.Vertex, axis int, distance float32) ([]q2file.Vertex, []q2file.Vertex) {
+	front := make([]q2file.Vertex, 0, len(polygon)+1)
+	back := make([]q2file.Vertex, 0, len(polygon)+1)
+	for i := range polygon {
+		current := polygon[i]
+		next := polygon[(i+1)%len(polygon)]
+		currentDistance := vertexToArray(current)[axis] - distance
+		nextDistance := vertexToArray(next)[axis] - distance
+
+		if currentDistance >= 0 {
+			front = append(front, current)
+		}
+		if currentDistance <= 0 {
+			back = append(back, current)
+		}
+
+		// Add the point where the edge crosses the plane
+		if (currentDistance > 0 && nextDistance < 0) || (currentDistance < 0 && nextDistance > 0) {
+			fraction := currentDistance / (currentDistance - nextDistance)
+			crossing := q2file.Vertex{
+				X: current.X + fraction*(next.X-current.X),
+				Y: current.Y + fraction*(next.Y-current.Y),
+				Z: current.Z + fraction*(next.Z-current.Z),
+			}
+			front = append(front, crossing)
+			back = append(back, crossing)
+		}
+	}
+	return front, back
+}
+
+func vertexToArray(vertex q2file.Vertex) [3]float32 {
+	return [3]float32{vertex.X, vertex.Y, vertex.Z}
+}
+
+// Draw the warped faces with the texture coordinates moving over time
+// The vertex array of the map is already bound
+func drawWarpBatches(renderer *Renderer, renderMap *RenderMap, elapsedTime float64) {
+	if len(renderMap.WarpBatches) == 0 {
+		return
+	}
+
+	programShader := renderer.WarpShader.ProgramShader
+	gl.UseProgram(programShader)
+
+	viewLoc := gl.GetUniformLocation(programShader, gl.Str("view\x00"))
+	gl.UniformMatrix4fv(viewLoc, 1, false, &renderer.ViewMatrix[0])
+	projectionLoc := gl.GetUniformLocation(programShader, gl.Str("projection\x00"))
+	gl.UniformMatrix4fv(projectionLoc, 1, false, &renderer.ProjectionMatrix[0])
+	modelLoc := gl.GetUniformLocation(programShader, gl.Str("model\x00"))
+	gl.UniformMatrix4fv(modelLoc, 1, false, &renderer.MapModelMatrix[0])
+	timeLoc := gl.GetUniformLocation(programShader, gl.Str("time\x00"))
+	gl.Uniform1f(timeLoc, float32(elapsedTime))
+
+	textureSizeLoc := gl.GetUniformLocation(programShader, gl.Str("textureSize\x00"))
+	diffuseUniform := gl.GetUniformLocation(programShader, gl.Str("diffuse\x00"))
+	gl.Uniform1i(diffuseUniform, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+
+	for _, batch := range renderMap.WarpBatches {
+		texture := renderMap.MapTextures[batch.TextureId]
+		gl.Uniform2f(textureSizeLoc, float32(texture.Width), float32(texture.Height))
+		gl.BindTexture(gl.TEXTURE_2D, texture.Id)
+		gl.DrawElements(gl.TRIANGLES, batch.IndexCount, gl.UNSIGNED_INT, gl.PtrOffset(int(batch.IndexOffset)*4))
+	}
+
+	// Switch back to the map shader
+	gl.UseProgram(renderer.Shader.ProgramShader)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/samuelyuan/go-quake2/q2file"
+	"github.com/samuelyuan/go-quake2/render"
+)
+
+const (
+	// The game uses this sky when worldspawn doesn't have one
+	defaultSkyName = "unit1_"
+)
+
+// Get the worldspawn keys that choose the sky
+func getWorldspawnSky(mapData *q2file.MapData) q2file.Entity {
+	worldspawns := q2file.FindEntitiesByClass(mapData.Entities, "worldspawn")
+	if len(worldspawns) == 0 {
+		return q2file.Entity{}
+	}
+	return worldspawns[0]
+}
+
+// Load the six env/ images for the sky, using "sky", "skyrotate" and "skyaxis"
+// Quake 1 maps don't have a sky box unless the "sky" key is set
+func loadSkyBox(fileSystem *q2file.FileSystem, skyEntity q2file.Entity, useDefaultSky bool) *render.SkyBox {
+	skyName, _ := skyEntity.Get("sky")
+	if skyName == "" {
+		if !useDefaultSky {
+			return nil
+		}
+		skyName = defaultSkyName
+	}
+	rotate, _ := skyEntity.GetFloat("skyrotate")
+	axis, _ := skyEntity.GetVector("skyaxis")
+
+	images := [6]image.Image{}
+	for i, suffix := range render.SkySuffixes {
+		baseFilename := "env/" + skyName + suffix
+		skyImage, err := loadSkyImage(fileSystem, baseFilename)
+		if err != nil {
+			fmt.Println("Warning: sky", baseFilename, "can't be loaded:", err)
+			return nil
+		}
+
+		// Every side of a cube map has to be the same square size
+		size := skyImage.Bounds().Size()
+		if size.X != size.Y || (i > 0 && size != images[0].Bounds().Size()) {
+			fmt.Println("Warning: sky", baseFilename, "has a different size than the other sides:", size)
+			return nil
+		}
+		images[i] = skyImage
+	}
+
+	fmt.Println("Sky", skyName, "loaded")
+	return render.NewSkyBox(images, rotate, axis)
+}
+
+// The TGA images are used by the OpenGL renderer, the PCX images by the software renderer
+func loadSkyImage(fileSystem *q2file.FileSystem, baseFilename string) (image.Image, error) {
+	if tgaReader, err := fileSystem.Open(baseFilename + ".tga"); err == nil {
+		return q2file.LoadQ2TGA(tgaReader)
+	}
+
+	pcxReader, err := fileSystem.Open(baseFilename + ".pcx")
+	if err != nil {
+		return nil, err
+	}
+	skyImage, _, err := q2file.LoadQ2PCX(pcxReader)
+	return skyImage, err
+}
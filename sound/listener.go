@@ -0,0 +1,105 @@
+package sound
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samuelyuan/go-quake2/q2file"
+)
+
+// Where the listener is at a point in time
+type ListenerPosition struct {
+	Time   float64
+	Origin [3]float32
+	Angles [3]float32 // pitch, yaw, roll in degrees
+}
+
+// Listener positions sorted by time, such as a camera recording or the player in a demo
+type ListenerPath []ListenerPosition
+
+// Direction of the right ear, ignoring roll like the Quake 2 client does
+func (position ListenerPosition) Right() [3]float32 {
+	yaw := float64(position.Angles[1]) * math.Pi / 180
+	return [3]float32{float32(math.Sin(yaw)), float32(-math.Cos(yaw)), 0}
+}
+
+// Get the listener position at a time, interpolated between the two closest positions
+func (path ListenerPath) At(time float64) ListenerPosition {
+	if len(path) == 0 {
+		return ListenerPosition{Time: time}
+	}
+
+	nextIndex := sort.Search(len(path), func(i int) bool {
+		return path[i].Time > time
+	})
+	if nextIndex == 0 {
+		return path[0]
+	}
+	if nextIndex >= len(path) {
+		return path[len(path)-1]
+	}
+
+	prev := path[nextIndex-1]
+	next := path[nextIndex]
+	lerp := float32((time - prev.Time) / (next.Time - prev.Time))
+	position := ListenerPosition{Time: time}
+	for i := 0; i < 3; i++ {
+		position.Origin[i] = prev.Origin[i] + (next.Origin[i]-prev.Origin[i])*lerp
+		position.Angles[i] = q2file.LerpAngle(prev.Angles[i], next.Angles[i], lerp)
+	}
+	return position
+}
+
+func (path ListenerPath) Duration() float64 {
+	if len(path) == 0 {
+		return 0
+	}
+	return path[len(path)-1].Time
+}
+
+// Load a camera recording from a text file
+// Each line is "time x y z pitch yaw roll" and lines starting with # are ignored
+func LoadListenerPath(r io.Reader) (ListenerPath, error) {
+	path := make(ListenerPath, 0)
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("Listener path: line %v: expected 7 values, got %v", lineNumber, len(fields))
+		}
+		values := make([]float64, len(fields))
+		for i, field := range fields {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Listener path: line %v: invalid number %q", lineNumber, field)
+			}
+			values[i] = value
+		}
+
+		position := ListenerPosition{
+			Time:   values[0],
+			Origin: [3]float32{float32(values[1]), float32(values[2]), float32(values[3])},
+			Angles: [3]float32{float32(values[4]), float32(values[5]), float32(values[6])},
+		}
+		if len(path) > 0 && position.Time < path[len(path)-1].Time {
+			return nil, fmt.Errorf("Listener path: line %v: time %v is before the previous line", lineNumber, position.Time)
+		}
+		path = append(path, position)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
@@ -0,0 +1,200 @@
+package sound
+
+import (
+	"math"
+	"sort"
+
+	"github.com/samuelyuan/go-quake2/q2file"
+)
+
+const (
+	// Sounds closer than this play at full volume
+	SoundFullVolume = 80.0
+	// Looping sounds, such as speakers, always use this distance falloff
+	LoopDistanceMultiplier = 0.003
+
+	AttenuationNone   = 0
+	AttenuationNormal = 1
+	AttenuationIdle   = 2
+	AttenuationStatic = 3
+
+	// Spatialization is updated this many times per second as the listener moves
+	spatializeRate = 100
+)
+
+// A sound played at a position in the world
+type SoundEvent struct {
+	Sound     *q2file.WavSound
+	StartTime float64 // seconds from the start of the mix
+	Origin    [3]float32
+	Volume    float32 // 0 to 1
+	// How quickly the volume falls off with distance, 0 plays at full volume everywhere
+	DistanceMultiplier float32
+	// Looping sounds repeat from the WAV loop point until the end time
+	Looping bool
+	EndTime float64
+}
+
+// Convert an attenuation value, such as ATTN_NORM, to the distance falloff used by the mixer
+func AttenuationToDistanceMultiplier(attenuation float32) float32 {
+	if attenuation == AttenuationStatic {
+		return attenuation * 0.001
+	}
+	return attenuation * 0.0005
+}
+
+// Mixes sound events into a stereo buffer, the same way the Quake 2 client spatializes sounds
+type Mixer struct {
+	SampleRate   int
+	MasterVolume float32
+	events       []SoundEvent
+}
+
+func NewMixer(sampleRate int) *Mixer {
+	return &Mixer{
+		SampleRate:   sampleRate,
+		MasterVolume: 1.0,
+		events:       make([]SoundEvent, 0),
+	}
+}
+
+func (mixer *Mixer) AddSound(event SoundEvent) {
+	if event.Sound == nil || len(event.Sound.Samples) == 0 {
+		return
+	}
+	mixer.events = append(mixer.events, event)
+}
+
+func (mixer *Mixer) NumSounds() int {
+	return len(mixer.events)
+}
+
+// Mix all sounds heard from the listener path into interleaved stereo samples
+func (mixer *Mixer) Mix(path ListenerPath, duration float64) []int16 {
+	numFrames := int(duration * float64(mixer.SampleRate))
+	buffer := make([]float32, numFrames*2)
+
+	events := make([]SoundEvent, len(mixer.events))
+	copy(events, mixer.events)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].StartTime < events[j].StartTime
+	})
+
+	blockSize := mixer.SampleRate / spatializeRate
+	if blockSize <= 0 {
+		blockSize = 1
+	}
+
+	for _, event := range events {
+		mixer.mixEvent(buffer, event, path, blockSize)
+	}
+
+	samples := make([]int16, len(buffer))
+	for i, value := range buffer {
+		scaled := value * 32767
+		if scaled > 32767 {
+			scaled = 32767
+		} else if scaled < -32768 {
+			scaled = -32768
+		}
+		samples[i] = int16(scaled)
+	}
+	return samples
+}
+
+func (mixer *Mixer) mixEvent(buffer []float32, event SoundEvent, path ListenerPath, blockSize int) {
+	numFrames := len(buffer) / 2
+	startFrame := int(event.StartTime * float64(mixer.SampleRate))
+	if startFrame >= numFrames {
+		return
+	}
+
+	endFrame := numFrames
+	if event.Looping {
+		endFrame = int(math.Min(float64(numFrames), event.EndTime*float64(mixer.SampleRate)))
+	}
+
+	// Sounds are resampled to the output rate with linear interpolation
+	samples := event.Sound.Samples
+	step := float64(event.Sound.Format.SampleRate) / float64(mixer.SampleRate)
+	loopStart := event.Sound.LoopStart
+	if loopStart < 0 {
+		loopStart = 0
+	}
+
+	position := 0.0
+	if startFrame < 0 {
+		position = float64(-startFrame) * step
+		startFrame = 0
+	}
+
+	for blockStart := startFrame; blockStart < endFrame; blockStart += blockSize {
+		blockEnd := blockStart + blockSize
+		if blockEnd > endFrame {
+			blockEnd = endFrame
+		}
+
+		blockTime := float64(blockStart) / float64(mixer.SampleRate)
+		leftVolume, rightVolume := spatialize(event, path.At(blockTime))
+		leftVolume *= mixer.MasterVolume
+		rightVolume *= mixer.MasterVolume
+
+		for frame := blockStart; frame < blockEnd; frame++ {
+			if position >= float64(len(samples)-1) {
+				if !event.Looping {
+					return
+				}
+				position = float64(loopStart) + (position - float64(len(samples)-1))
+			}
+
+			index := int(position)
+			fraction := float32(position - float64(index))
+			sample := samples[index]
+			if index+1 < len(samples) {
+				sample += (samples[index+1] - sample) * fraction
+			}
+
+			buffer[frame*2] += sample * leftVolume
+			buffer[frame*2+1] += sample * rightVolume
+			position += step
+		}
+	}
+}
+
+// Get the left and right volume for a sound, based on the distance and direction from the listener
+// The volume falls off linearly, and sounds to one side are quieter in the other ear
+func spatialize(event SoundEvent, listener ListenerPosition) (float32, float32) {
+	if event.DistanceMultiplier == 0 {
+		return event.Volume, event.Volume
+	}
+
+	sourceVector := [3]float32{
+		event.Origin[0] - listener.Origin[0],
+		event.Origin[1] - listener.Origin[1],
+		event.Origin[2] - listener.Origin[2],
+	}
+	distance := float32(math.Sqrt(float64(sourceVector[0]*sourceVector[0] + sourceVector[1]*sourceVector[1] + sourceVector[2]*sourceVector[2])))
+	dot := float32(0)
+	if distance > 0 {
+		right := listener.Right()
+		dot = (sourceVector[0]*right[0] + sourceVector[1]*right[1] + sourceVector[2]*right[2]) / distance
+	}
+
+	distance -= SoundFullVolume
+	if distance < 0 {
+		distance = 0
+	}
+	distance *= event.DistanceMultiplier
+
+	rightScale := 0.5 * (1.0 + dot)
+	leftScale := 0.5 * (1.0 - dot)
+	leftVolume := event.Volume * (1.0 - distance) * leftScale
+	rightVolume := event.Volume * (1.0 - distance) * rightScale
+	if leftVolume < 0 {
+		leftVolume = 0
+	}
+	if rightVolume < 0 {
+		rightVolume = 0
+	}
+	return leftVolume, rightVolume
+}
@@ -0,0 +1,54 @@
+package sound
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSpatialize(t *testing.T) {
+	// Facing along +X, so the right ear points along -Y
+	listener := ListenerPosition{Origin: [3]float32{100, 100, 0}, Angles: [3]float32{0, 0, 0}}
+	normal := AttenuationToDistanceMultiplier(AttenuationNormal)
+
+	tests := []struct {
+		name       string
+		origin     [3]float32
+		multiplier float32
+		wantLeft   float32
+		wantRight  float32
+	}{
+		{"no attenuation", [3]float32{5000, 100, 0}, 0, 0.8, 0.8},
+		{"at the listener", [3]float32{100, 100, 0}, normal, 0.4, 0.4},
+		{"in front within full volume", [3]float32{100 + SoundFullVolume, 100, 0}, normal, 0.4, 0.4},
+		{"right within full volume", [3]float32{100, 100 - SoundFullVolume, 0}, normal, 0, 0.8},
+		{"left within full volume", [3]float32{100, 100 + SoundFullVolume, 0}, normal, 0.8, 0},
+		// Half way to silent with ATTN_NORM
+		{"right falloff", [3]float32{100, 100 - SoundFullVolume - 1000, 0}, normal, 0, 0.4},
+		{"in front falloff", [3]float32{100 + SoundFullVolume + 1000, 100, 0}, normal, 0.2, 0.2},
+		{"out of range", [3]float32{100 + SoundFullVolume + 3000, 100, 0}, normal, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := SoundEvent{Origin: test.origin, Volume: 0.8, DistanceMultiplier: test.multiplier}
+			left, right := spatialize(event, listener)
+			if math.Abs(float64(left-test.wantLeft)) > 1e-5 || math.Abs(float64(right-test.wantRight)) > 1e-5 {
+				t.Errorf("Volume is (%v, %v), want (%v, %v)", left, right, test.wantLeft, test.wantRight)
+			}
+		})
+	}
+}
+
+func TestSpatializeFollowsYaw(t *testing.T) {
+	event := SoundEvent{Origin: [3]float32{SoundFullVolume, 0, 0}, Volume: 1, DistanceMultiplier: LoopDistanceMultiplier}
+
+	// Turning left puts a sound along +X in the right ear
+	left, right := spatialize(event, ListenerPosition{Angles: [3]float32{0, 90, 0}})
+	if math.Abs(float64(left)) > 1e-5 || math.Abs(float64(right-1)) > 1e-5 {
+		t.Errorf("Volume facing +Y is (%v, %v), want (0, 1)", left, right)
+	}
+	left, right = spatialize(event, ListenerPosition{Angles: [3]float32{0, -90, 0}})
+	if math.Abs(float64(left-1)) > 1e-5 || math.Abs(float64(right)) > 1e-5 {
+		t.Errorf("Volume facing -Y is (%v, %v), want (1, 0)", left, right)
+	}
+}
@@ -0,0 +1,211 @@
+package sound
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/samuelyuan/go-quake2/q2file"
+)
+
+const (
+	// Set on target_speaker entities that start playing a looped sound
+	speakerLoopedOn    = 1
+	defaultPlayerModel = "male"
+)
+
+// Sound files are loaded once and shared by every event that plays them
+type SoundCache struct {
+	fileSystem *q2file.FileSystem
+	sounds     map[string]*q2file.WavSound
+}
+
+func NewSoundCache(fileSystem *q2file.FileSystem) *SoundCache {
+	return &SoundCache{
+		fileSystem: fileSystem,
+		sounds:     make(map[string]*q2file.WavSound),
+	}
+}
+
+// Load a sound by the name the game uses, which is relative to sound/
+// Names starting with * are player sounds, which use the male sounds since skins aren't tracked
+func (cache *SoundCache) Load(name string) *q2file.WavSound {
+	if sound, loaded := cache.sounds[name]; loaded {
+		return sound
+	}
+
+	filenames := []string{"sound/" + name}
+	if strings.HasPrefix(name, "*") {
+		filenames = []string{
+			"players/" + defaultPlayerModel + "/" + name[1:],
+			"sound/player/" + defaultPlayerModel + "/" + name[1:],
+		}
+	}
+
+	var sound *q2file.WavSound
+	for _, filename := range filenames {
+		wavReader, err := cache.fileSystem.Open(strings.ToLower(filename))
+		if err != nil {
+			continue
+		}
+		sound, err = q2file.LoadQ2WAV(wavReader)
+		if err != nil {
+			fmt.Println("Warning: sound", filename, "can't be loaded:", err)
+			continue
+		}
+		break
+	}
+	if sound == nil {
+		fmt.Println("Warning: sound", name, "is missing.")
+	}
+
+	cache.sounds[name] = sound
+	return sound
+}
+
+// Looped target_speaker entities play for the whole mix
+// Speakers that are triggered by the game aren't played, since triggers aren't simulated
+func CreateSpeakerEvents(cache *SoundCache, entities []q2file.Entity, duration float64) []SoundEvent {
+	events := make([]SoundEvent, 0)
+	for _, entity := range q2file.FindEntitiesByClass(entities, "target_speaker") {
+		spawnFlags, _ := entity.GetInt("spawnflags")
+		if spawnFlags&speakerLoopedOn == 0 {
+			continue
+		}
+
+		noise, hasNoise := entity.Get("noise")
+		origin, err := entity.GetVector("origin")
+		if !hasNoise || err != nil {
+			continue
+		}
+		if !strings.HasSuffix(strings.ToLower(noise), ".wav") {
+			noise += ".wav"
+		}
+
+		volume, err := entity.GetFloat("volume")
+		if err != nil || volume == 0 {
+			volume = 1.0
+		}
+
+		events = append(events, SoundEvent{
+			Sound:              cache.Load(noise),
+			StartTime:          0,
+			Origin:             origin,
+			Volume:             volume,
+			DistanceMultiplier: LoopDistanceMultiplier,
+			Looping:            true,
+			EndTime:            duration,
+		})
+	}
+	return events
+}
+
+// Everything needed to mix the audio heard by the player who recorded a demo
+type DemoAudio struct {
+	MapFilename string
+	Path        ListenerPath
+	Events      []SoundEvent
+}
+
+// Read the player view and sound events from the first level in a demo
+func LoadDemoAudio(cache *SoundCache, r io.Reader) (*DemoAudio, error) {
+	reader := q2file.NewDM2Reader(r)
+	demoClient := q2file.NewDM2Client()
+	audio := &DemoAudio{
+		Path:   make(ListenerPath, 0),
+		Events: make([]SoundEvent, 0),
+	}
+
+	var lastSnapshot *q2file.DM2Snapshot
+	firstFrame := int32(0)
+	for {
+		block, err := reader.NextBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Stop at the next level
+		if lastSnapshot != nil && block.HasServerData() {
+			break
+		}
+
+		snapshots, err := demoClient.ParseBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		for i := range snapshots {
+			if lastSnapshot == nil {
+				firstFrame = snapshots[i].ServerFrame
+			}
+			lastSnapshot = &snapshots[i]
+
+			playerState := lastSnapshot.PlayerState
+			position := ListenerPosition{
+				Time:   float64(lastSnapshot.ServerFrame-firstFrame) * q2file.DM2FrameTime,
+				Angles: playerState.ViewAngles,
+			}
+			for axis := 0; axis < 3; axis++ {
+				position.Origin[axis] = playerState.Origin[axis] + playerState.ViewOffset[axis]
+			}
+			audio.Path = append(audio.Path, position)
+		}
+
+		// Sounds are heard at the time of the last frame
+		if lastSnapshot == nil {
+			continue
+		}
+		for _, message := range block.Messages {
+			soundMessage, ok := message.(q2file.DM2Sound)
+			if !ok {
+				continue
+			}
+			event := createDemoSoundEvent(cache, demoClient, lastSnapshot, soundMessage)
+			if event != nil {
+				event.StartTime = audio.Path.Duration() + float64(soundMessage.TimeOffset)
+				audio.Events = append(audio.Events, *event)
+			}
+		}
+	}
+
+	if lastSnapshot == nil {
+		return nil, fmt.Errorf("Demo has no frames")
+	}
+	audio.MapFilename = demoClient.ConfigStrings[q2file.ConfigStringModels+1]
+	return audio, nil
+}
+
+func createDemoSoundEvent(
+	cache *SoundCache,
+	demoClient *q2file.DM2Client,
+	snapshot *q2file.DM2Snapshot,
+	soundMessage q2file.DM2Sound,
+) *SoundEvent {
+	name := demoClient.ConfigStrings[q2file.ConfigStringSounds+int(soundMessage.SoundIndex)]
+	if name == "" {
+		return nil
+	}
+	sound := cache.Load(name)
+	if sound == nil {
+		return nil
+	}
+
+	event := &SoundEvent{
+		Sound:              sound,
+		Volume:             soundMessage.Volume,
+		DistanceMultiplier: AttenuationToDistanceMultiplier(soundMessage.Attenuation),
+	}
+
+	// Sounds without a position come from an entity, or from the player if it's their own sound
+	viewEntity := int(demoClient.ServerData.PlayerNum) + 1
+	if soundMessage.Flags&q2file.SoundBitPosition != 0 {
+		event.Origin = soundMessage.Position
+	} else if entity := snapshot.FindEntity(int(soundMessage.Entity)); entity != nil && entity.Number != viewEntity {
+		event.Origin = entity.Origin
+	} else {
+		event.DistanceMultiplier = 0
+	}
+	return event
+}